@@ -1,9 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"regexp"
 	"syscall"
 
 	"github.com/gofiber/contrib/websocket"
@@ -14,13 +16,21 @@ import (
 
 	"github.com/khonE3/chat-backend/internal/config"
 	"github.com/khonE3/chat-backend/internal/handler"
+	"github.com/khonE3/chat-backend/internal/middleware"
+	"github.com/khonE3/chat-backend/internal/model"
 	"github.com/khonE3/chat-backend/internal/repository"
 	"github.com/khonE3/chat-backend/internal/service"
 	ws "github.com/khonE3/chat-backend/internal/websocket"
 	"github.com/khonE3/chat-backend/pkg/database"
 	redisclient "github.com/khonE3/chat-backend/pkg/redis"
+	"github.com/khonE3/chat-backend/pkg/storage"
+	"github.com/khonE3/chat-backend/pkg/unfurl"
 )
 
+// attachmentUploadPath matches POST /api/rooms/:id/attachments, the one route
+// allowed bodies up to UPLOAD_MAX_BYTES
+var attachmentUploadPath = regexp.MustCompile(`^/api/rooms/[^/]+/attachments/?$`)
+
 func main() {
 	// Load configuration
 	cfg := config.Load()
@@ -41,28 +51,71 @@ func main() {
 	defer rdb.Close()
 	log.Println("✅ Connected to Redis")
 
+	// Initialize attachment storage
+	store, err := newStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize %s storage: %v", cfg.StorageBackend, err)
+	}
+	log.Printf("✅ Using %s attachment storage", cfg.StorageBackend)
+
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(db)
 	roomRepo := repository.NewRoomRepository(db)
 	messageRepo := repository.NewMessageRepository(db, rdb)
 	presenceRepo := repository.NewPresenceRepository(rdb)
 	pubsubRepo := repository.NewPubSubRepository(rdb)
+	sessionRepo := repository.NewSessionRepository(rdb, cfg.SessionTTL)
+	credentialRepo := repository.NewCredentialRepository(db)
+	reactionRepo := repository.NewReactionRepository(db)
+	receiptRepo := repository.NewReceiptRepository(db)
+	dmRepo := repository.NewDMRepository(db)
+	attachmentRepo := repository.NewAttachmentRepository(db)
+	linkPreviewRepo := repository.NewLinkPreviewRepository(rdb)
+	mentionRepo := repository.NewMentionRepository(db)
+	rateLimitRepo := repository.NewRateLimitRepository(rdb)
+	moderationRepo := repository.NewModerationRepository(db)
+	automodRepo := repository.NewAutomodRepository(db, rdb)
+	reportRepo := repository.NewReportRepository(db)
 
 	// Initialize services
-	chatService := service.NewChatService(messageRepo, pubsubRepo, presenceRepo)
+	roomService := service.NewRoomService(roomRepo, moderationRepo)
+	validator := service.NewContentValidator(cfg.MaxMessageLength)
+	rateLimiter := service.NewRateLimitService(rateLimitRepo, roomRepo, map[service.RateLimitAction]model.RateLimit{
+		service.RateLimitMessage:    cfg.MessageRateLimit,
+		service.RateLimitTyping:     cfg.TypingRateLimit,
+		service.RateLimitRoomCreate: cfg.RoomCreateRateLimit,
+		service.RateLimitUserCreate: cfg.UserCreateRateLimit,
+	})
+	automodService := service.NewAutomodService(automodRepo, moderationRepo, messageRepo, userRepo, roomService, cfg.AdminUserIDs)
+	go automodService.Watch()
+	chatService := service.NewChatService(messageRepo, pubsubRepo, presenceRepo, reactionRepo, receiptRepo, roomService, rateLimiter, validator, automodService)
 	presenceService := service.NewPresenceService(presenceRepo)
+	dmService := service.NewDMService(dmRepo, userRepo, roomRepo, messageRepo, cfg.MaxGroupSize)
+	attachmentService := service.NewAttachmentService(attachmentRepo, messageRepo, roomService, rateLimiter, validator, store, cfg.UploadMaxBytes, cfg.UploadAllowedTypes)
+	mentionService := service.NewMentionService(mentionRepo, roomRepo, presenceRepo)
+	moderationService := service.NewModerationService(moderationRepo, roomRepo, userRepo, messageRepo, roomService)
+	reportService := service.NewReportService(reportRepo, messageRepo, roomService, moderationService, cfg.AdminUserIDs)
+	var previewService *service.LinkPreviewService
+	if cfg.LinkPreviewsEnabled {
+		fetcher := unfurl.NewFetcher(cfg.LinkPreviewTimeout, cfg.LinkPreviewMaxBytes)
+		previewService = service.NewLinkPreviewService(fetcher, linkPreviewRepo, messageRepo, cfg.LinkPreviewCacheTTL)
+	}
+	authService := service.NewAuthService(userRepo, credentialRepo, cfg.MaxLoginAttempts, cfg.LoginLockout, cfg.AllowNicknameLogin)
 
 	// Initialize Global WebSocket hub for homepage updates
-	globalHub := ws.NewGlobalHub(roomRepo)
+	globalHub := ws.NewGlobalHub(roomRepo, pubsubRepo)
 	go globalHub.Run()
 
 	// Initialize WebSocket hub for chat rooms
-	hub := ws.NewHub(chatService, presenceService, pubsubRepo, globalHub)
+	hub := ws.NewHub(chatService, presenceService, roomService, previewService, mentionService, rateLimiter, pubsubRepo, globalHub, cfg.WSMaxFrameBytes)
 	go hub.Run()
 
 	// Initialize Fiber app
 	app := fiber.New(fiber.Config{
 		AppName: "Isan Chat - หนองบัวลำภู 🏯",
+		// Leave room for the multipart envelope around the largest upload;
+		// every other route is held to the default by the BodyLimit below
+		BodyLimit: int(cfg.UploadMaxBytes) + 1<<20,
 	})
 
 	// Middleware
@@ -70,44 +123,130 @@ func main() {
 	app.Use(recover.New())
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     cfg.CORSOrigins,
-		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
+		AllowMethods:     "GET,POST,PUT,PATCH,DELETE,OPTIONS",
 		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,Upgrade,Connection,Sec-WebSocket-Key,Sec-WebSocket-Version,Sec-WebSocket-Extensions",
 		AllowCredentials: true,
 	}))
+	app.Use(middleware.BodyLimit(fiber.DefaultBodyLimit, func(c *fiber.Ctx) bool {
+		return c.Method() == fiber.MethodPost && attachmentUploadPath.MatchString(c.Path())
+	}))
 
 	// Health check
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
 			"status":  "ok",
 			"message": "สวัสดีจากหนองบัวลำภู! 🎋",
+			"cache": fiber.Map{
+				"history": chatService.HistoryCacheStats(),
+			},
 		})
 	})
 
+	// Auth middleware
+	requireAuth := middleware.RequireAuth(sessionRepo)
+	optionalAuth := middleware.OptionalAuth(sessionRepo)
+
+	// Rate limits for REST endpoints that create rooms and accounts
+	limitRoomCreate := middleware.RateLimit(rateLimiter, service.RateLimitRoomCreate, middleware.ByUser)
+	limitUserCreate := middleware.RateLimit(rateLimiter, service.RateLimitUserCreate, middleware.ByIP)
+
 	// API routes
 	api := app.Group("/api")
 
+	// Auth routes
+	userHandler := handler.NewUserHandler(userRepo, sessionRepo, authService)
+	api.Post("/auth/register", limitUserCreate, userHandler.Register)
+	api.Post("/auth/login", userHandler.Login)
+	api.Post("/auth/logout", requireAuth, userHandler.Logout)
+	api.Post("/auth/password", requireAuth, userHandler.ChangePassword)
+	api.Get("/auth/me", requireAuth, userHandler.Me)
+	api.Get("/auth/me/settings", requireAuth, userHandler.GetSettings)
+	api.Patch("/auth/me/settings", requireAuth, userHandler.UpdateSettings)
+
 	// User routes
-	userHandler := handler.NewUserHandler(userRepo)
-	api.Post("/users", userHandler.Create)
+	api.Post("/users", limitUserCreate, userHandler.Create)
 	api.Get("/users/:id", userHandler.GetByID)
 	api.Get("/users/username/:username", userHandler.GetByUsername)
 
+	mentionHandler := handler.NewMentionHandler(mentionService)
+	api.Get("/users/:id/mentions", requireAuth, mentionHandler.List)
+
 	// Room routes
-	roomHandler := handler.NewRoomHandler(roomRepo, userRepo)
-	api.Get("/rooms", roomHandler.List)
-	api.Post("/rooms", roomHandler.Create)
-	api.Get("/rooms/:id", roomHandler.GetByID)
-	api.Post("/rooms/:id/join", roomHandler.Join)
-	api.Get("/rooms/:id/members", roomHandler.GetMembers)
-	api.Post("/rooms/:id/read", roomHandler.MarkAsRead)
-	api.Get("/rooms/:id/unread", roomHandler.GetUnreadCount)
+	roomHandler := handler.NewRoomHandler(roomRepo, userRepo, roomService, hub, cfg.MaxSlowMode)
+	roomAccess := middleware.RequireRoomAccess(roomRepo, "id")
+	api.Get("/rooms", optionalAuth, roomHandler.List)
+	api.Post("/rooms", requireAuth, limitRoomCreate, roomHandler.Create)
+	api.Get("/rooms/:id", optionalAuth, roomHandler.GetByID)
+	api.Patch("/rooms/:id", requireAuth, roomHandler.Update)
+	api.Post("/rooms/:id/join", requireAuth, roomHandler.Join)
+	api.Get("/rooms/:id/members", optionalAuth, roomAccess, roomHandler.GetMembers)
+	api.Put("/rooms/:id/members/:userId/role", requireAuth, roomHandler.UpdateMemberRole)
+	api.Post("/rooms/:id/invitations", requireAuth, roomHandler.Invite)
+	api.Get("/rooms/:id/requests", requireAuth, roomHandler.ListJoinRequests)
+	api.Post("/rooms/:id/requests/:userId/approve", requireAuth, roomHandler.ApproveJoinRequest)
+	api.Post("/rooms/:id/requests/:userId/reject", requireAuth, roomHandler.RejectJoinRequest)
+	api.Get("/invitations", requireAuth, roomHandler.ListInvitations)
+	api.Get("/rooms/:id/unread", requireAuth, roomHandler.GetUnreadCount)
+
+	// Moderation routes
+	moderationHandler := handler.NewModerationHandler(moderationService, hub)
+	api.Get("/rooms/:id/restrictions", requireAuth, moderationHandler.List)
+	api.Post("/rooms/:id/members/:userId/kick", requireAuth, moderationHandler.Kick)
+	api.Post("/rooms/:id/members/:userId/ban", requireAuth, moderationHandler.Ban)
+	api.Delete("/rooms/:id/members/:userId/ban", requireAuth, moderationHandler.Unban)
+	api.Post("/rooms/:id/members/:userId/mute", requireAuth, moderationHandler.Mute)
+	api.Delete("/rooms/:id/members/:userId/mute", requireAuth, moderationHandler.Unmute)
+	api.Post("/rooms/:id/members/:userId/timeout", requireAuth, moderationHandler.Timeout)
+
+	// Automod routes
+	automodHandler := handler.NewAutomodHandler(automodService, chatService, hub)
+	api.Get("/automod/rules", requireAuth, automodHandler.ListGlobalRules)
+	api.Post("/automod/rules", requireAuth, automodHandler.CreateGlobalRule)
+	api.Put("/automod/rules/:id", requireAuth, automodHandler.UpdateRule)
+	api.Delete("/automod/rules/:id", requireAuth, automodHandler.DeleteRule)
+	api.Get("/rooms/:id/automod/rules", requireAuth, automodHandler.ListRoomRules)
+	api.Post("/rooms/:id/automod/rules", requireAuth, automodHandler.CreateRoomRule)
+	api.Get("/rooms/:id/automod/held", requireAuth, automodHandler.ListHeld)
+	api.Post("/automod/held/:id/approve", requireAuth, automodHandler.ApproveHeld)
+	api.Post("/automod/held/:id/reject", requireAuth, automodHandler.RejectHeld)
+
+	// Report routes
+	reportHandler := handler.NewReportHandler(reportService, hub)
+	api.Post("/messages/:id/report", requireAuth, reportHandler.Report)
+	api.Get("/reports", requireAuth, reportHandler.ListAll)
+	api.Get("/reports/:id", requireAuth, reportHandler.Get)
+	api.Post("/reports/:id/resolve", requireAuth, reportHandler.Resolve)
+	api.Get("/rooms/:id/reports", requireAuth, reportHandler.ListRoom)
+
+	// Direct message routes
+	dmHandler := handler.NewDMHandler(dmService, hub)
+	api.Get("/dms", requireAuth, dmHandler.Inbox)
+	api.Post("/dms/:userId", requireAuth, dmHandler.Open)
+	api.Get("/groups", requireAuth, dmHandler.ListGroups)
+	api.Post("/groups", requireAuth, limitRoomCreate, dmHandler.CreateGroup)
+	api.Post("/groups/:id/members", requireAuth, dmHandler.AddGroupMembers)
+	api.Delete("/groups/:id/members/:userId", requireAuth, dmHandler.RemoveGroupMember)
 
 	// Message routes
-	messageHandler := handler.NewMessageHandler(messageRepo)
-	api.Get("/rooms/:id/messages", messageHandler.GetByRoom)
+	messageHandler := handler.NewMessageHandler(messageRepo, chatService, hub)
+	api.Get("/rooms/:id/messages", optionalAuth, roomAccess, messageHandler.GetByRoom)
+	api.Post("/rooms/:id/read", requireAuth, messageHandler.MarkRead)
+	api.Get("/rooms/:id/messages/:msgId/readers", optionalAuth, roomAccess, messageHandler.GetReaders)
+	api.Patch("/rooms/:id/messages/:msgId", requireAuth, messageHandler.Edit)
+	api.Delete("/rooms/:id/messages/:msgId", requireAuth, messageHandler.Delete)
+	api.Post("/rooms/:id/messages/:msgId/reactions", requireAuth, messageHandler.AddReaction)
+	api.Delete("/rooms/:id/messages/:msgId/reactions/:emoji", requireAuth, messageHandler.RemoveReaction)
+	api.Get("/messages/:id/thread", optionalAuth, messageHandler.GetThread)
+	api.Get("/search", optionalAuth, messageHandler.Search)
+
+	// Attachment routes
+	attachmentHandler := handler.NewAttachmentHandler(attachmentService, hub)
+	api.Post("/rooms/:id/attachments", requireAuth, attachmentHandler.Upload)
+	api.Get("/attachments/:id", optionalAuth, attachmentHandler.Download)
+	api.Get("/attachments/:id/thumbnail", optionalAuth, attachmentHandler.Thumbnail)
 
 	// Global WebSocket route for homepage real-time updates (MUST be before /ws/:roomId)
-	app.Get("/ws/global", func(c *fiber.Ctx) error {
+	app.Get("/ws/global", optionalAuth, func(c *fiber.Ctx) error {
 		log.Printf("🌐 GET /ws/global - Global WebSocket request")
 		if !websocket.IsWebSocketUpgrade(c) {
 			return c.SendStatus(fiber.StatusUpgradeRequired)
@@ -120,8 +259,8 @@ func main() {
 	})
 
 	// WebSocket route for chat rooms
-	app.Get("/ws/:roomId", func(c *fiber.Ctx) error {
-		log.Printf("🎯 GET /ws/%s - Headers: %v", c.Params("roomId"), c.GetReqHeaders())
+	app.Get("/ws/:roomId", requireAuth, middleware.RequireRoomAccess(roomRepo, "roomId"), func(c *fiber.Ctx) error {
+		log.Printf("🎯 GET /ws/%s", c.Params("roomId"))
 		log.Printf("🎯 Upgrade header: %s", c.Get("Upgrade"))
 		log.Printf("🎯 Connection header: %s", c.Get("Connection"))
 
@@ -165,3 +304,21 @@ func main() {
 
 	log.Println("👋 Server exited")
 }
+
+// newStorage opens the attachment storage backend selected by STORAGE_BACKEND
+func newStorage(cfg *config.Config) (storage.Storage, error) {
+	switch cfg.StorageBackend {
+	case "local":
+		return storage.NewLocal(cfg.StorageLocalDir)
+	case "s3":
+		return storage.NewS3(storage.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+		})
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+}
@@ -4,8 +4,13 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"github.com/khonE3/chat-backend/internal/model"
 )
 
 type Config struct {
@@ -26,6 +31,43 @@ type Config struct {
 
 	// CORS
 	CORSOrigins string
+
+	// Auth
+	SessionTTL         time.Duration
+	MaxLoginAttempts   int
+	LoginLockout       time.Duration
+	AllowNicknameLogin bool        // demo deployments only
+	AdminUserIDs       []uuid.UUID // site admins, who manage global automod rules
+
+	// Conversations
+	MaxGroupSize     int
+	MaxMessageLength int   // characters, not bytes
+	WSMaxFrameBytes  int64 // larger WebSocket frames close the connection
+
+	// Attachments
+	UploadMaxBytes     int64
+	UploadAllowedTypes []string
+	StorageBackend     string // local | s3
+	StorageLocalDir    string
+	S3Endpoint         string
+	S3Region           string
+	S3Bucket           string
+	S3AccessKey        string
+	S3SecretKey        string
+	S3PathStyle        bool
+
+	// Link previews
+	LinkPreviewsEnabled bool
+	LinkPreviewTimeout  time.Duration
+	LinkPreviewMaxBytes int64
+	LinkPreviewCacheTTL time.Duration
+
+	// Rate limits, shared by all instances through Redis
+	MessageRateLimit    model.RateLimit // per user, WebSocket sends and uploads
+	TypingRateLimit     model.RateLimit // per user and room
+	RoomCreateRateLimit model.RateLimit // per user, rooms and groups
+	UserCreateRateLimit model.RateLimit // per client IP
+	MaxSlowMode         time.Duration
 }
 
 func Load() *Config {
@@ -50,6 +92,43 @@ func Load() *Config {
 
 		// CORS
 		CORSOrigins: getEnv("CORS_ORIGINS", "http://localhost:3000"),
+
+		// Auth
+		SessionTTL:         getDurationEnv("SESSION_TTL", 7*24*time.Hour),
+		MaxLoginAttempts:   getIntEnv("MAX_LOGIN_ATTEMPTS", 5),
+		LoginLockout:       getDurationEnv("LOGIN_LOCKOUT", 15*time.Minute),
+		AllowNicknameLogin: getBoolEnv("ALLOW_NICKNAME_LOGIN", false),
+		AdminUserIDs:       getUUIDListEnv("ADMIN_USER_IDS"),
+
+		// Conversations
+		MaxGroupSize:     getIntEnv("GROUP_DM_MAX_MEMBERS", 10),
+		MaxMessageLength: getIntEnv("MESSAGE_MAX_LENGTH", 4000),
+		WSMaxFrameBytes:  int64(getIntEnv("WS_MAX_FRAME_BYTES", 64<<10)),
+
+		// Attachments
+		UploadMaxBytes:     int64(getIntEnv("UPLOAD_MAX_BYTES", 10<<20)),
+		UploadAllowedTypes: getListEnv("UPLOAD_ALLOWED_TYPES", "image/jpeg,image/png,image/gif,image/webp,application/pdf,text/plain"),
+		StorageBackend:     getEnv("STORAGE_BACKEND", "local"),
+		StorageLocalDir:    getEnv("STORAGE_LOCAL_DIR", "./uploads"),
+		S3Endpoint:         getEnv("S3_ENDPOINT", "http://localhost:9000"),
+		S3Region:           getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:           getEnv("S3_BUCKET", "chat-attachments"),
+		S3AccessKey:        getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:        getEnv("S3_SECRET_KEY", ""),
+		S3PathStyle:        getBoolEnv("S3_PATH_STYLE", true),
+
+		// Link previews
+		LinkPreviewsEnabled: getBoolEnv("LINK_PREVIEWS_ENABLED", true),
+		LinkPreviewTimeout:  getDurationEnv("LINK_PREVIEW_TIMEOUT", 5*time.Second),
+		LinkPreviewMaxBytes: int64(getIntEnv("LINK_PREVIEW_MAX_BYTES", 512<<10)),
+		LinkPreviewCacheTTL: getDurationEnv("LINK_PREVIEW_CACHE_TTL", 24*time.Hour),
+
+		// Rate limits
+		MessageRateLimit:    getRateLimitEnv("RATE_LIMIT_MESSAGES", "10/10s"),
+		TypingRateLimit:     getRateLimitEnv("RATE_LIMIT_TYPING", "10/10s"),
+		RoomCreateRateLimit: getRateLimitEnv("RATE_LIMIT_ROOM_CREATE", "5/1h"),
+		UserCreateRateLimit: getRateLimitEnv("RATE_LIMIT_USER_CREATE", "10/1h"),
+		MaxSlowMode:         getDurationEnv("SLOW_MODE_MAX", 6*time.Hour),
 	}
 }
 
@@ -67,3 +146,60 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+func getIntEnv(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func getBoolEnv(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+func getListEnv(key, defaultValue string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getUUIDListEnv reads a comma-separated list of IDs, skipping any that
+// don't parse
+func getUUIDListEnv(key string) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, item := range getListEnv(key, "") {
+		if id, err := uuid.Parse(item); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func getRateLimitEnv(key, defaultValue string) model.RateLimit {
+	if limit, err := model.ParseRateLimit(getEnv(key, defaultValue)); err == nil {
+		return limit
+	}
+	limit, _ := model.ParseRateLimit(defaultValue)
+	return limit
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/khonE3/chat-backend/internal/middleware"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/service"
+	ws "github.com/khonE3/chat-backend/internal/websocket"
+)
+
+type AttachmentHandler struct {
+	attachmentService *service.AttachmentService
+	hub               *ws.Hub
+}
+
+func NewAttachmentHandler(attachmentService *service.AttachmentService, hub *ws.Hub) *AttachmentHandler {
+	return &AttachmentHandler{attachmentService: attachmentService, hub: hub}
+}
+
+// Upload posts a file to a room as an attachment message.
+// Multipart form: file (required), caption, nonce.
+func (h *AttachmentHandler) Upload(c *fiber.Ctx) error {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "A file is required",
+		})
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	defer file.Close()
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	msg, duplicate, err := h.attachmentService.Upload(ctx, roomID, userID, &service.Upload{
+		FileName: header.Filename,
+		Size:     header.Size,
+		Body:     file,
+		Caption:  c.FormValue("caption"),
+		Nonce:    c.FormValue("nonce"),
+	})
+	if err != nil {
+		return attachmentError(c, err)
+	}
+
+	sent := model.SentMessage{MessageWithUser: msg, Nonce: msg.Nonce}
+
+	// A resent upload was already broadcast the first time
+	if duplicate {
+		return c.JSON(sent)
+	}
+
+	h.hub.BroadcastMessage(msg)
+	h.hub.NotifyMentions(msg)
+	h.hub.UnfurlLinks(&msg.Message)
+
+	return c.Status(fiber.StatusCreated).JSON(sent)
+}
+
+// Download streams an attachment
+func (h *AttachmentHandler) Download(c *fiber.Ctx) error {
+	return h.serve(c, false)
+}
+
+// Thumbnail streams the thumbnail of an image attachment
+func (h *AttachmentHandler) Thumbnail(c *fiber.Ctx) error {
+	return h.serve(c, true)
+}
+
+func (h *AttachmentHandler) serve(c *fiber.Ctx, thumbnail bool) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid attachment ID",
+		})
+	}
+
+	viewerID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	att, body, err := h.attachmentService.Open(ctx, id, viewerID, thumbnail)
+	if err != nil {
+		return attachmentError(c, err)
+	}
+
+	// Uploads are never rendered as anything but their sniffed type, and
+	// only images open inline
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	if thumbnail {
+		c.Set(fiber.HeaderContentType, "image/jpeg")
+		return c.SendStream(body)
+	}
+
+	disposition := "attachment"
+	if isImage(att.ContentType) {
+		disposition = "inline"
+	}
+	c.Set(fiber.HeaderContentType, att.ContentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("%s; filename*=UTF-8''%s", disposition, url.PathEscape(att.FileName)))
+	return c.SendStream(body, int(att.SizeBytes))
+}
+
+func isImage(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// attachmentError maps AttachmentService errors to HTTP responses
+func attachmentError(c *fiber.Ctx, err error) error {
+	var limited *service.RateLimitError
+	if errors.As(err, &limited) {
+		return middleware.TooManyRequests(c, limited)
+	}
+
+	var invalid *service.ValidationError
+	if errors.As(err, &invalid) {
+		return validationError(c, invalid)
+	}
+
+	var restricted *service.RestrictedError
+	if errors.As(err, &restricted) {
+		return restrictedError(c, restricted)
+	}
+
+	switch {
+	case errors.Is(err, service.ErrAttachmentNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Attachment not found",
+		})
+	case errors.Is(err, service.ErrEmptyFile):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File is empty",
+		})
+	case errors.Is(err, service.ErrFileTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": "File is too large",
+		})
+	case errors.Is(err, service.ErrUnsupportedType):
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": "File type is not allowed",
+		})
+	case errors.Is(err, service.ErrInvalidNonce):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Nonce must be at most 64 bytes",
+		})
+	case errors.Is(err, service.ErrNonceReused):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Nonce already used for another message",
+		})
+	case errors.Is(err, service.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You don't have permission to do that in this room",
+		})
+	case errors.Is(err, pgx.ErrNoRows):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Room not found",
+		})
+	}
+	log.Printf("❌ Error handling attachment: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to process attachment",
+	})
+}
@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/khonE3/chat-backend/internal/middleware"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+	"github.com/khonE3/chat-backend/internal/service"
+	ws "github.com/khonE3/chat-backend/internal/websocket"
+)
+
+type AutomodHandler struct {
+	automodService *service.AutomodService
+	chatService    *service.ChatService
+	hub            *ws.Hub
+}
+
+func NewAutomodHandler(automodService *service.AutomodService, chatService *service.ChatService, hub *ws.Hub) *AutomodHandler {
+	return &AutomodHandler{automodService: automodService, chatService: chatService, hub: hub}
+}
+
+// ListGlobalRules returns the rules that apply in every room (site admins)
+func (h *AutomodHandler) ListGlobalRules(c *fiber.Ctx) error {
+	return h.listRules(c, nil)
+}
+
+// CreateGlobalRule adds a rule that applies in every room (site admins)
+func (h *AutomodHandler) CreateGlobalRule(c *fiber.Ctx) error {
+	return h.createRule(c, nil)
+}
+
+// ListRoomRules returns the room's own rules (admin and above)
+func (h *AutomodHandler) ListRoomRules(c *fiber.Ctx) error {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+	return h.listRules(c, &roomID)
+}
+
+// CreateRoomRule adds a rule to the room (admin and above)
+func (h *AutomodHandler) CreateRoomRule(c *fiber.Ctx) error {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+	return h.createRule(c, &roomID)
+}
+
+func (h *AutomodHandler) listRules(c *fiber.Ctx, roomID *uuid.UUID) error {
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	rules, err := h.automodService.ListRules(ctx, userID, roomID)
+	if err != nil {
+		return automodError(c, err)
+	}
+
+	return c.JSON(rules)
+}
+
+func (h *AutomodHandler) createRule(c *fiber.Ctx, roomID *uuid.UUID) error {
+	var req model.AutomodRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	rule, err := h.automodService.CreateRule(ctx, userID, roomID, &req)
+	if err != nil {
+		return automodError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(rule)
+}
+
+// UpdateRule replaces the settings of a rule, global or per room
+func (h *AutomodHandler) UpdateRule(c *fiber.Ctx) error {
+	ruleID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid rule ID",
+		})
+	}
+
+	var req model.AutomodRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	rule, err := h.automodService.UpdateRule(ctx, userID, ruleID, &req)
+	if err != nil {
+		return automodError(c, err)
+	}
+
+	return c.JSON(rule)
+}
+
+// DeleteRule removes a rule, global or per room
+func (h *AutomodHandler) DeleteRule(c *fiber.Ctx) error {
+	ruleID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid rule ID",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	if err := h.automodService.DeleteRule(ctx, userID, ruleID); err != nil {
+		return automodError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Rule deleted",
+	})
+}
+
+// ListHeld returns the room's messages awaiting review, oldest first.
+// Query: limit (default 50, max 100), offset.
+func (h *AutomodHandler) ListHeld(c *fiber.Ctx) error {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+
+	if limit > 100 {
+		limit = 100
+	}
+	if limit < 1 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	held, err := h.automodService.ListHeld(ctx, roomID, userID, limit, offset)
+	if err != nil {
+		return automodError(c, err)
+	}
+
+	return c.JSON(held)
+}
+
+// ApproveHeld posts a held message to its room as its sender
+func (h *AutomodHandler) ApproveHeld(c *fiber.Ctx) error {
+	return h.review(c, true)
+}
+
+// RejectHeld discards a held message
+func (h *AutomodHandler) RejectHeld(c *fiber.Ctx) error {
+	return h.review(c, false)
+}
+
+func (h *AutomodHandler) review(c *fiber.Ctx, approve bool) error {
+	heldID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid held message ID",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	held, err := h.automodService.Review(ctx, heldID, userID, approve)
+	if err != nil {
+		return automodError(c, err)
+	}
+	if !approve {
+		return c.JSON(held)
+	}
+
+	msg, err := h.chatService.PostHeld(ctx, held)
+	if err != nil {
+		if err := h.automodService.Reopen(ctx, heldID); err != nil {
+			log.Printf("❌ Error reopening held message %s: %v", heldID, err)
+		}
+		return automodError(c, err)
+	}
+
+	h.hub.AnnounceMessage(ctx, msg)
+	h.hub.AckHeld(msg)
+
+	return c.JSON(fiber.Map{
+		"held":    held,
+		"message": msg,
+	})
+}
+
+// automodError maps AutomodService errors to HTTP responses
+func automodError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidRule):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrTooManyRules):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "This scope already has the maximum number of rules",
+		})
+	case errors.Is(err, service.ErrRuleNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Rule not found",
+		})
+	case errors.Is(err, service.ErrHeldNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Held message not found",
+		})
+	case errors.Is(err, service.ErrSenderCannotPost):
+		// Checked before ErrForbidden, which the wrapped reason also matches
+		body := fiber.Map{
+			"error": "The sender can no longer post in this room",
+		}
+		var restricted *service.RestrictedError
+		if errors.As(err, &restricted) {
+			body["code"] = restricted.Detail().Code
+		}
+		return c.Status(fiber.StatusConflict).JSON(body)
+	case errors.Is(err, repository.ErrHeldNotPending):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Held message was already reviewed",
+		})
+	case errors.Is(err, service.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You don't have permission to manage automod here",
+		})
+	case errors.Is(err, pgx.ErrNoRows):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Room not found",
+		})
+	}
+	log.Printf("❌ Error handling automod: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to process automod request",
+	})
+}
@@ -2,22 +2,38 @@ package handler
 
 import (
 	"context"
+	"errors"
+	"log"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/middleware"
+	"github.com/khonE3/chat-backend/internal/model"
 	"github.com/khonE3/chat-backend/internal/repository"
+	"github.com/khonE3/chat-backend/internal/service"
+	ws "github.com/khonE3/chat-backend/internal/websocket"
 )
 
 type MessageHandler struct {
 	messageRepo *repository.MessageRepository
+	chatService *service.ChatService
+	hub         *ws.Hub
 }
 
-func NewMessageHandler(messageRepo *repository.MessageRepository) *MessageHandler {
-	return &MessageHandler{messageRepo: messageRepo}
+func NewMessageHandler(messageRepo *repository.MessageRepository, chatService *service.ChatService, hub *ws.Hub) *MessageHandler {
+	return &MessageHandler{
+		messageRepo: messageRepo,
+		chatService: chatService,
+		hub:         hub,
+	}
 }
 
-// GetByRoom gets messages for a room with pagination
+// GetByRoom gets messages for a room with keyset pagination.
+// Query: limit, before / after (cursor), around (message ID).
+// The legacy offset parameter still works when no cursor is given.
 func (h *MessageHandler) GetByRoom(c *fiber.Ctx) error {
 	roomIDStr := c.Params("id")
 	roomID, err := uuid.Parse(roomIDStr)
@@ -37,18 +53,466 @@ func (h *MessageHandler) GetByRoom(c *fiber.Ctx) error {
 	if limit < 1 {
 		limit = 50
 	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	q := &model.HistoryQuery{Limit: limit, Offset: offset}
+
+	if v := c.Query("before"); v != "" {
+		if q.Before, err = model.DecodeMessageCursor(v); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid before cursor",
+			})
+		}
+	}
+
+	if v := c.Query("after"); v != "" {
+		if q.After, err = model.DecodeMessageCursor(v); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid after cursor",
+			})
+		}
+	}
+
+	if v := c.Query("around"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid around message ID",
+			})
+		}
+		q.Around = &id
+	}
+
+	if (q.Before != nil && q.After != nil) || (q.Around != nil && (q.Before != nil || q.After != nil)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Use only one of before, after and around",
+		})
+	}
+
+	// Anonymous callers keep uuid.Nil, so nothing shows as reacted by them
+	viewerID, _ := middleware.UserID(c)
 
 	ctx := context.Background()
-	messages, err := h.messageRepo.GetByRoom(ctx, roomID, limit, offset)
+	page, err := h.chatService.GetMessageHistory(ctx, roomID.String(), q, viewerID)
 	if err != nil {
+		if errors.Is(err, service.ErrMessageNotFound) {
+			return messageError(c, err)
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch messages",
 		})
 	}
 
 	return c.JSON(fiber.Map{
-		"messages": messages,
-		"limit":    limit,
-		"offset":   offset,
+		"messages":    page.Messages,
+		"next_cursor": page.NextCursor,
+		"prev_cursor": page.PrevCursor,
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
+
+// GetThread returns a thread root with a page of its replies
+func (h *MessageHandler) GetThread(c *fiber.Ctx) error {
+	rootID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+
+	if limit > 100 {
+		limit = 100
+	}
+	if limit < 1 {
+		limit = 50
+	}
+
+	// Anonymous callers keep uuid.Nil and only see public rooms
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	root, replies, err := h.chatService.GetThread(ctx, rootID, userID, limit, offset)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	if replies == nil {
+		replies = []model.MessageWithUser{}
+	}
+
+	return c.JSON(fiber.Map{
+		"root":    root,
+		"replies": replies,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// Search finds messages across the rooms the caller can read.
+// Filters: room_id, user_id, from, to (RFC 3339), type.
+func (h *MessageHandler) Search(c *fiber.Ctx) error {
+	params := model.SearchParams{
+		Query: c.Query("q"),
+	}
+
+	params.Limit, _ = strconv.Atoi(c.Query("limit", "20"))
+	params.Offset, _ = strconv.Atoi(c.Query("offset", "0"))
+	if params.Limit > 100 {
+		params.Limit = 100
+	}
+	if params.Limit < 1 {
+		params.Limit = 20
+	}
+	if params.Offset < 0 {
+		params.Offset = 0
+	}
+
+	if v := c.Query("room_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid room_id",
+			})
+		}
+		params.RoomID = &id
+	}
+
+	if v := c.Query("user_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user_id",
+			})
+		}
+		params.UserID = &id
+	}
+
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid from date, expected RFC 3339",
+			})
+		}
+		params.From = &t
+	}
+
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid to date, expected RFC 3339",
+			})
+		}
+		params.To = &t
+	}
+
+	if v := c.Query("type"); v != "" {
+		msgType := model.MessageType(v)
+		params.MessageType = &msgType
+	}
+
+	// Anonymous callers keep uuid.Nil and only search public rooms
+	viewerID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	results, err := h.chatService.SearchMessages(ctx, &params, viewerID)
+	if err != nil {
+		if errors.Is(err, service.ErrQueryTooShort) || errors.Is(err, service.ErrQueryTooLong) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("❌ Error searching messages: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to search messages",
+		})
+	}
+
+	if results == nil {
+		results = []model.SearchResult{}
+	}
+
+	return c.JSON(fiber.Map{
+		"results": results,
+		"limit":   params.Limit,
+		"offset":  params.Offset,
+	})
+}
+
+// Edit changes the content of the caller's own message
+func (h *MessageHandler) Edit(c *fiber.Ctx) error {
+	roomID, messageID, ok := parseMessageParams(c)
+	if !ok {
+		return nil
+	}
+
+	var req model.EditMessageRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	msg, err := h.chatService.EditMessage(ctx, roomID, messageID, userID, req.Content)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	h.hub.BroadcastToRoom(roomID.String(), model.WSMessage{
+		Type:    model.WSTypeEdit,
+		Payload: msg,
+	})
+	h.hub.UnfurlLinks(&msg.Message)
+
+	return c.JSON(msg)
+}
+
+// Delete retracts a message (own messages, or anyone's for moderators)
+func (h *MessageHandler) Delete(c *fiber.Ctx) error {
+	roomID, messageID, ok := parseMessageParams(c)
+	if !ok {
+		return nil
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	msg, err := h.chatService.DeleteMessage(ctx, roomID, messageID, userID)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	h.hub.BroadcastToRoom(roomID.String(), model.WSMessage{
+		Type: model.WSTypeDelete,
+		Payload: model.MessageDeletedPayload{
+			MessageID: msg.ID.String(),
+			RoomID:    roomID.String(),
+			DeletedBy: userID.String(),
+			DeletedAt: *msg.DeletedAt,
+		},
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "Message deleted",
+	})
+}
+
+// AddReaction reacts to a message with an emoji
+func (h *MessageHandler) AddReaction(c *fiber.Ctx) error {
+	roomID, messageID, ok := parseMessageParams(c)
+	if !ok {
+		return nil
+	}
+
+	var req model.ReactionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	payload, err := h.chatService.AddReaction(ctx, roomID, messageID, userID, req.Emoji)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	h.hub.BroadcastToRoom(roomID.String(), model.WSMessage{
+		Type:    model.WSTypeReactionAdd,
+		Payload: payload,
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(payload)
+}
+
+// RemoveReaction withdraws the caller's reaction. The emoji is path-escaped.
+func (h *MessageHandler) RemoveReaction(c *fiber.Ctx) error {
+	roomID, messageID, ok := parseMessageParams(c)
+	if !ok {
+		return nil
+	}
+
+	emoji, err := url.PathUnescape(c.Params("emoji"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid emoji",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	payload, err := h.chatService.RemoveReaction(ctx, roomID, messageID, userID, emoji)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	h.hub.BroadcastToRoom(roomID.String(), model.WSMessage{
+		Type:    model.WSTypeReactionDel,
+		Payload: payload,
+	})
+
+	return c.JSON(payload)
+}
+
+// MarkRead advances the caller's read marker to message_id from the body,
+// or to the newest message when the body is empty
+func (h *MessageHandler) MarkRead(c *fiber.Ctx) error {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	var req model.MarkReadRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	receipt, err := h.chatService.MarkRead(ctx, roomID, userID, req.MessageID)
+	if err != nil {
+		return messageError(c, err)
+	}
+	h.hub.SyncUnread(roomID, userID)
+
+	if receipt != nil {
+		h.hub.BroadcastToRoom(roomID.String(), model.WSMessage{
+			Type:    model.WSTypeReadReceipt,
+			Payload: receipt,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Marked as read",
+	})
+}
+
+// GetReaders lists who has read a message
+func (h *MessageHandler) GetReaders(c *fiber.Ctx) error {
+	roomID, messageID, ok := parseMessageParams(c)
+	if !ok {
+		return nil
+	}
+
+	ctx := context.Background()
+	readers, err := h.chatService.GetReaders(ctx, roomID, messageID)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message_id": messageID,
+		"readers":    readers,
+		"count":      len(readers),
+	})
+}
+
+// parseMessageParams reads :id and :msgId. When it returns false the error
+// response is already written.
+func parseMessageParams(c *fiber.Ctx) (uuid.UUID, uuid.UUID, bool) {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	messageID, err := uuid.Parse(c.Params("msgId"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return roomID, messageID, true
+}
+
+// messageError maps ChatService errors to HTTP responses
+func messageError(c *fiber.Ctx, err error) error {
+	var invalid *service.ValidationError
+	if errors.As(err, &invalid) {
+		return validationError(c, invalid)
+	}
+
+	var restricted *service.RestrictedError
+	if errors.As(err, &restricted) {
+		return restrictedError(c, restricted)
+	}
+
+	var blocked *service.AutomodError
+	if errors.As(err, &blocked) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": blocked.Error(),
+			"code":  model.ErrCodeAutomod,
+		})
+	}
+
+	switch {
+	case errors.Is(err, service.ErrMessageNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Message not found",
+		})
+	case errors.Is(err, service.ErrInvalidEmoji):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid emoji",
+		})
+	case errors.Is(err, service.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You don't have permission to do that in this room",
+		})
+	}
+	log.Printf("❌ Error handling message: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to process message",
 	})
 }
+
+// validationError answers 400 with the error text and what was wrong, e.g.
+// {"error": "...", "code": "message_too_long", "field": "content", "limit": 4000}
+func validationError(c *fiber.Ctx, invalid *service.ValidationError) error {
+	body := fiber.Map{
+		"error": invalid.Error(),
+		"code":  invalid.Code,
+		"field": invalid.Field,
+	}
+	if invalid.Limit > 0 {
+		body["limit"] = invalid.Limit
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(body)
+}
+
+// restrictedError answers 403 for a banned or muted user, e.g.
+// {"error": "...", "code": "muted", "expires_at": "..."}
+func restrictedError(c *fiber.Ctx, restricted *service.RestrictedError) error {
+	detail := restricted.Detail()
+	body := fiber.Map{
+		"error": restricted.Error(),
+		"code":  detail.Code,
+	}
+	if detail.ExpiresAt != nil {
+		body["expires_at"] = detail.ExpiresAt
+	}
+	return c.Status(fiber.StatusForbidden).JSON(body)
+}
@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/middleware"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+	"github.com/khonE3/chat-backend/internal/service"
+	ws "github.com/khonE3/chat-backend/internal/websocket"
+)
+
+type DMHandler struct {
+	dmService *service.DMService
+	hub       *ws.Hub
+}
+
+func NewDMHandler(dmService *service.DMService, hub *ws.Hub) *DMHandler {
+	return &DMHandler{dmService: dmService, hub: hub}
+}
+
+// Open opens (or fetches) the direct conversation with :userId
+func (h *DMHandler) Open(c *fiber.Ctx) error {
+	peerID, err := uuid.Parse(c.Params("userId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	dm, created, err := h.dmService.OpenDM(ctx, userID, peerID)
+	if err != nil {
+		return dmError(c, err)
+	}
+
+	if created {
+		return c.Status(fiber.StatusCreated).JSON(dm)
+	}
+	return c.JSON(dm)
+}
+
+// Inbox lists the caller's direct conversations by last activity
+func (h *DMHandler) Inbox(c *fiber.Ctx) error {
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	conversations, err := h.dmService.Inbox(ctx, userID)
+	if err != nil {
+		return dmError(c, err)
+	}
+
+	return c.JSON(conversations)
+}
+
+// CreateGroup starts a group conversation with the given participants
+func (h *DMHandler) CreateGroup(c *fiber.Ctx) error {
+	var req model.CreateGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	room, change, err := h.dmService.CreateGroup(ctx, userID, &req)
+	if err != nil {
+		return dmError(c, err)
+	}
+
+	// Nobody is connected to a brand new room yet, but the system message
+	// still goes through the hub so the stream cache and global hub see it
+	h.hub.BroadcastMessage(change.SystemMessage)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"room":    room,
+		"members": change.Event.Users,
+	})
+}
+
+// ListGroups lists the caller's group conversations by last activity
+func (h *DMHandler) ListGroups(c *fiber.Ctx) error {
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	groups, err := h.dmService.Groups(ctx, userID)
+	if err != nil {
+		return dmError(c, err)
+	}
+
+	return c.JSON(groups)
+}
+
+// AddGroupMembers adds users to a group the caller belongs to
+func (h *DMHandler) AddGroupMembers(c *fiber.Ctx) error {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	var req model.AddMembersRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	change, err := h.dmService.AddMembers(ctx, roomID, userID, req.UserIDs)
+	if err != nil {
+		return dmError(c, err)
+	}
+
+	h.broadcastChange(change)
+
+	return c.JSON(change.Event)
+}
+
+// RemoveGroupMember removes :userId from a group; removing yourself leaves it
+func (h *DMHandler) RemoveGroupMember(c *fiber.Ctx) error {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	targetID, err := uuid.Parse(c.Params("userId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	change, err := h.dmService.RemoveMember(ctx, roomID, userID, targetID)
+	if err != nil {
+		return dmError(c, err)
+	}
+
+	h.broadcastChange(change)
+
+	return c.JSON(change.Event)
+}
+
+// broadcastChange sends the system message, then the membership event that
+// disconnects anyone removed
+func (h *DMHandler) broadcastChange(change *service.MembershipChange) {
+	h.hub.BroadcastMessage(change.SystemMessage)
+	h.hub.BroadcastToRoom(change.Event.RoomID.String(), model.WSMessage{
+		Type:    model.WSTypeMembers,
+		Payload: change.Event,
+	})
+}
+
+// dmError maps DMService errors to HTTP responses
+func dmError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	case errors.Is(err, service.ErrSelfDM):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot start a conversation with yourself",
+		})
+	case errors.Is(err, service.ErrNotGroup):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Group not found",
+		})
+	case errors.Is(err, service.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You are not a member of this group",
+		})
+	case errors.Is(err, repository.ErrNotMember):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User is not a member of this group",
+		})
+	case errors.Is(err, service.ErrGroupFull):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Group conversation is full",
+		})
+	case errors.Is(err, service.ErrNoParticipants):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "At least one other participant is required",
+		})
+	}
+	log.Printf("❌ Error handling direct message: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to process conversation",
+	})
+}
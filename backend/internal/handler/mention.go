@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/middleware"
+	"github.com/khonE3/chat-backend/internal/service"
+)
+
+type MentionHandler struct {
+	mentionService *service.MentionService
+}
+
+func NewMentionHandler(mentionService *service.MentionService) *MentionHandler {
+	return &MentionHandler{mentionService: mentionService}
+}
+
+// List returns the caller's mentions, newest first.
+// Query: limit (default 50, max 100), offset.
+func (h *MentionHandler) List(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	// Mentions are private to the user mentioned
+	if callerID, _ := middleware.UserID(c); callerID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You can only view your own mentions",
+		})
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+
+	if limit > 100 {
+		limit = 100
+	}
+	if limit < 1 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	ctx := context.Background()
+	mentions, err := h.mentionService.ListForUser(ctx, userID, limit, offset)
+	if err != nil {
+		log.Printf("❌ Error listing mentions: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get mentions",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"mentions": mentions,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/khonE3/chat-backend/internal/middleware"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/service"
+	ws "github.com/khonE3/chat-backend/internal/websocket"
+)
+
+type ModerationHandler struct {
+	moderationService *service.ModerationService
+	hub               *ws.Hub
+}
+
+func NewModerationHandler(moderationService *service.ModerationService, hub *ws.Hub) *ModerationHandler {
+	return &ModerationHandler{moderationService: moderationService, hub: hub}
+}
+
+// Kick removes :userId from the room and closes their connections to it
+func (h *ModerationHandler) Kick(c *fiber.Ctx) error {
+	return h.act(c, func(ctx context.Context, roomID, actorID, targetID uuid.UUID, req *model.ModerationRequest) (*service.ModerationResult, error) {
+		return h.moderationService.Kick(ctx, roomID, actorID, targetID, req)
+	})
+}
+
+// Ban keeps :userId out of the room, for duration_seconds or for good
+func (h *ModerationHandler) Ban(c *fiber.Ctx) error {
+	return h.restrict(c, model.RestrictionBan)
+}
+
+// Mute stops :userId posting, for duration_seconds or until unmuted
+func (h *ModerationHandler) Mute(c *fiber.Ctx) error {
+	return h.restrict(c, model.RestrictionMute)
+}
+
+// Timeout mutes :userId for duration_seconds, which is required
+func (h *ModerationHandler) Timeout(c *fiber.Ctx) error {
+	return h.restrict(c, model.RestrictionTimeout)
+}
+
+// Unban lifts the ban on :userId
+func (h *ModerationHandler) Unban(c *fiber.Ctx) error {
+	return h.lift(c, model.RestrictionBan)
+}
+
+// Unmute lifts the mute or timeout on :userId
+func (h *ModerationHandler) Unmute(c *fiber.Ctx) error {
+	return h.lift(c, model.RestrictionMute)
+}
+
+// List returns the bans, mutes and timeouts in force in the room
+func (h *ModerationHandler) List(c *fiber.Ctx) error {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	restrictions, err := h.moderationService.List(ctx, roomID, userID)
+	if err != nil {
+		return moderationError(c, err)
+	}
+
+	return c.JSON(restrictions)
+}
+
+func (h *ModerationHandler) restrict(c *fiber.Ctx, kind model.RestrictionKind) error {
+	return h.act(c, func(ctx context.Context, roomID, actorID, targetID uuid.UUID, req *model.ModerationRequest) (*service.ModerationResult, error) {
+		return h.moderationService.Restrict(ctx, roomID, actorID, targetID, kind, req)
+	})
+}
+
+func (h *ModerationHandler) lift(c *fiber.Ctx, kind model.RestrictionKind) error {
+	return h.act(c, func(ctx context.Context, roomID, actorID, targetID uuid.UUID, _ *model.ModerationRequest) (*service.ModerationResult, error) {
+		return h.moderationService.Lift(ctx, roomID, actorID, targetID, kind)
+	})
+}
+
+type moderationFunc func(ctx context.Context, roomID, actorID, targetID uuid.UUID, req *model.ModerationRequest) (*service.ModerationResult, error)
+
+// act parses the room, target and optional body shared by every action,
+// runs it and announces the result
+func (h *ModerationHandler) act(c *fiber.Ctx, do moderationFunc) error {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	targetID, err := uuid.Parse(c.Params("userId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	// The body is optional; a bare POST kicks or bans with no reason
+	var req model.ModerationRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+
+	actorID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	result, err := do(ctx, roomID, actorID, targetID, &req)
+	if err != nil {
+		return moderationError(c, err)
+	}
+
+	h.hub.AnnounceModeration(result)
+
+	return c.JSON(result.Event)
+}
+
+// moderationError maps ModerationService errors to HTTP responses
+func moderationError(c *fiber.Ctx, err error) error {
+	var invalid *service.ValidationError
+	if errors.As(err, &invalid) {
+		return validationError(c, invalid)
+	}
+
+	switch {
+	case errors.Is(err, service.ErrSelfModeration):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot moderate yourself",
+		})
+	case errors.Is(err, service.ErrInvalidDuration):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid duration",
+		})
+	case errors.Is(err, service.ErrNotRestricted):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User has no such restriction in this room",
+		})
+	case errors.Is(err, service.ErrUserNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	case errors.Is(err, service.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You don't have permission to moderate this user",
+		})
+	case errors.Is(err, pgx.ErrNoRows):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Room not found",
+		})
+	}
+	log.Printf("❌ Error moderating room: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to moderate user",
+	})
+}
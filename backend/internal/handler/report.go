@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/khonE3/chat-backend/internal/middleware"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+	"github.com/khonE3/chat-backend/internal/service"
+	ws "github.com/khonE3/chat-backend/internal/websocket"
+)
+
+type ReportHandler struct {
+	reportService *service.ReportService
+	hub           *ws.Hub
+}
+
+func NewReportHandler(reportService *service.ReportService, hub *ws.Hub) *ReportHandler {
+	return &ReportHandler{reportService: reportService, hub: hub}
+}
+
+// Report flags a message for the room's moderators
+func (h *ReportHandler) Report(c *fiber.Ctx) error {
+	messageID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	var req model.ReportRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	report, err := h.reportService.Report(ctx, messageID, userID, &req)
+	if err != nil {
+		return reportError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(report)
+}
+
+// ListRoom returns the room's open reports, oldest first (moderator and above).
+// Query: limit (default 50, max 100), offset.
+func (h *ReportHandler) ListRoom(c *fiber.Ctx) error {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+	return h.list(c, &roomID)
+}
+
+// ListAll returns open reports from every room (site admins)
+func (h *ReportHandler) ListAll(c *fiber.Ctx) error {
+	return h.list(c, nil)
+}
+
+func (h *ReportHandler) list(c *fiber.Ctx, roomID *uuid.UUID) error {
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+
+	if limit > 100 {
+		limit = 100
+	}
+	if limit < 1 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	reports, err := h.reportService.ListOpen(ctx, userID, roomID, limit, offset)
+	if err != nil {
+		return reportError(c, err)
+	}
+
+	return c.JSON(reports)
+}
+
+// Get returns a report with the messages around the reported one and its
+// audit trail
+func (h *ReportHandler) Get(c *fiber.Ctx) error {
+	reportID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid report ID",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	detail, err := h.reportService.Get(ctx, reportID, userID)
+	if err != nil {
+		return reportError(c, err)
+	}
+
+	return c.JSON(detail)
+}
+
+// Resolve dismisses a report, deletes the message or bans its author, and
+// tells the room about whatever changed
+func (h *ReportHandler) Resolve(c *fiber.Ctx) error {
+	reportID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid report ID",
+		})
+	}
+
+	var req model.ResolveReportRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	result, err := h.reportService.Resolve(ctx, reportID, userID, &req)
+	// A ban that went through is announced even if the rest failed
+	if result != nil && result.Moderation != nil {
+		h.hub.AnnounceModeration(result.Moderation)
+	}
+	if err != nil {
+		return reportError(c, err)
+	}
+	if result.Deleted != nil {
+		h.hub.BroadcastToRoom(result.Deleted.RoomID.String(), model.WSMessage{
+			Type: model.WSTypeDelete,
+			Payload: model.MessageDeletedPayload{
+				MessageID: result.Deleted.ID.String(),
+				RoomID:    result.Deleted.RoomID.String(),
+				DeletedBy: userID.String(),
+				DeletedAt: *result.Deleted.DeletedAt,
+			},
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"report":   result.Report,
+		"resolved": result.Resolved,
+	})
+}
+
+// reportError maps ReportService errors to HTTP responses
+func reportError(c *fiber.Ctx, err error) error {
+	var invalid *service.ValidationError
+	if errors.As(err, &invalid) {
+		return validationError(c, invalid)
+	}
+
+	switch {
+	case errors.Is(err, service.ErrInvalidReport):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid reason or action",
+		})
+	case errors.Is(err, service.ErrInvalidDuration):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid duration",
+		})
+	case errors.Is(err, service.ErrCannotReport):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot report this message",
+		})
+	case errors.Is(err, repository.ErrAlreadyReported):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "You have already reported this message",
+		})
+	case errors.Is(err, repository.ErrReportNotOpen):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Report was already resolved",
+		})
+	case errors.Is(err, service.ErrSelfModeration):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot ban yourself",
+		})
+	case errors.Is(err, service.ErrMessageNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Message not found",
+		})
+	case errors.Is(err, service.ErrReportNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Report not found",
+		})
+	case errors.Is(err, service.ErrUserNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	case errors.Is(err, service.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You don't have permission to review reports here",
+		})
+	case errors.Is(err, pgx.ErrNoRows):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Room not found",
+		})
+	}
+	log.Printf("❌ Error handling report: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to process report",
+	})
+}
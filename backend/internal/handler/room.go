@@ -2,47 +2,55 @@ package handler
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/middleware"
 	"github.com/khonE3/chat-backend/internal/model"
 	"github.com/khonE3/chat-backend/internal/repository"
+	"github.com/khonE3/chat-backend/internal/service"
+	ws "github.com/khonE3/chat-backend/internal/websocket"
 )
 
 type RoomHandler struct {
-	roomRepo *repository.RoomRepository
-	userRepo *repository.UserRepository
+	roomRepo    *repository.RoomRepository
+	userRepo    *repository.UserRepository
+	roomService *service.RoomService
+	hub         *ws.Hub
+	maxSlowMode time.Duration
 }
 
-func NewRoomHandler(roomRepo *repository.RoomRepository, userRepo *repository.UserRepository) *RoomHandler {
+func NewRoomHandler(roomRepo *repository.RoomRepository, userRepo *repository.UserRepository, roomService *service.RoomService, hub *ws.Hub, maxSlowMode time.Duration) *RoomHandler {
 	return &RoomHandler{
-		roomRepo: roomRepo,
-		userRepo: userRepo,
+		roomRepo:    roomRepo,
+		userRepo:    userRepo,
+		roomService: roomService,
+		hub:         hub,
+		maxSlowMode: maxSlowMode,
 	}
 }
 
-// List returns all public rooms
+// List returns all public rooms, plus the caller's private rooms when authenticated
 func (h *RoomHandler) List(c *fiber.Ctx) error {
 	ctx := context.Background()
 
-	// Check if userId is provided for unread counts
-	userIDStr := c.Query("userId")
-	if userIDStr != "" {
-		userID, err := uuid.Parse(userIDStr)
-		if err == nil {
-			rooms, err := h.roomRepo.ListWithUnread(ctx, userID, false)
-			if err != nil {
-				log.Printf("❌ Error fetching rooms with unread: %v", err)
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": "Failed to fetch rooms",
-				})
-			}
-			if rooms == nil {
-				rooms = []model.RoomWithMembers{}
-			}
-			return c.JSON(rooms)
+	// Include unread counts for authenticated users
+	if userID, ok := middleware.UserID(c); ok {
+		rooms, err := h.roomRepo.ListWithUnread(ctx, userID)
+		if err != nil {
+			log.Printf("❌ Error fetching rooms with unread: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch rooms",
+			})
+		}
+		if rooms == nil {
+			rooms = []model.RoomWithMembers{}
 		}
+		return c.JSON(rooms)
 	}
 
 	rooms, err := h.roomRepo.List(ctx, false)
@@ -75,27 +83,26 @@ func (h *RoomHandler) Create(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get creator ID from query (simplified auth)
-	creatorID := c.Query("userId")
-	var createdBy *uuid.UUID
-	if creatorID != "" {
-		if id, err := uuid.Parse(creatorID); err == nil {
-			createdBy = &id
-		}
-	}
+	createdBy, _ := middleware.UserID(c)
 
 	ctx := context.Background()
-	room, err := h.roomRepo.Create(ctx, &req, createdBy)
+	room, err := h.roomRepo.Create(ctx, &req, &createdBy)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create room",
 		})
 	}
 
+	// The creator owns their room, which also lets them into private rooms
+	if err := h.roomRepo.AddMemberWithRole(ctx, room.ID, createdBy, model.RoleOwner); err != nil {
+		log.Printf("❌ Error adding creator to room: %v", err)
+	}
+
 	return c.Status(fiber.StatusCreated).JSON(room)
 }
 
-// GetByID gets a room by ID
+// GetByID gets a room by ID. Private rooms the caller can't see are
+// reported as not found, so their existence isn't given away.
 func (h *RoomHandler) GetByID(c *fiber.Ctx) error {
 	idStr := c.Params("id")
 	id, err := uuid.Parse(idStr)
@@ -105,7 +112,23 @@ func (h *RoomHandler) GetByID(c *fiber.Ctx) error {
 		})
 	}
 
+	// Anonymous callers keep uuid.Nil, which is never a member
+	userID, _ := middleware.UserID(c)
+
 	ctx := context.Background()
+	allowed, err := h.roomRepo.CanAccess(ctx, id, userID)
+	if err != nil {
+		log.Printf("❌ Error checking room access: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get room",
+		})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Room not found",
+		})
+	}
+
 	room, err := h.roomRepo.GetByID(ctx, id)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -116,7 +139,8 @@ func (h *RoomHandler) GetByID(c *fiber.Ctx) error {
 	return c.JSON(room)
 }
 
-// Join adds a user to a room
+// Join adds a user to a room. Private rooms need an invitation; without one a
+// join request is filed for the owner to approve.
 func (h *RoomHandler) Join(c *fiber.Ctx) error {
 	roomIDStr := c.Params("id")
 	roomID, err := uuid.Parse(roomIDStr)
@@ -126,14 +150,83 @@ func (h *RoomHandler) Join(c *fiber.Ctx) error {
 		})
 	}
 
-	var req model.JoinRoomRequest
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	room, err := h.roomRepo.GetByID(ctx, roomID)
+	// Direct conversations are joined only by being added to them
+	if err != nil || room.Kind != model.RoomKindRoom {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Room not found",
+		})
+	}
+
+	// Banned users can't come back in, even with an invitation
+	ban, _, err := h.roomService.Restrictions(ctx, roomID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to join room",
+		})
+	}
+	if ban != nil {
+		return restrictedError(c, &service.RestrictedError{Restriction: ban})
+	}
+
+	if room.IsPrivate {
+		isMember, err := h.roomRepo.IsMember(ctx, roomID, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to join room",
+			})
+		}
+
+		if !isMember {
+			invited, err := h.roomRepo.ConsumeInvitation(ctx, roomID, userID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to join room",
+				})
+			}
+
+			if !invited {
+				if err := h.roomRepo.CreateJoinRequest(ctx, roomID, userID); err != nil {
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+						"error": "Failed to request to join room",
+					})
+				}
+				return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+					"message": "Join request sent to the room owner",
+				})
+			}
+		}
+	}
+
+	if err := h.roomRepo.AddMember(ctx, roomID, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to join room",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Successfully joined room",
+	})
+}
+
+// Invite lets room admins invite a user to a private room
+func (h *RoomHandler) Invite(c *fiber.Ctx) error {
+	room, ok := h.authorizeRoom(c, model.PermInvite)
+	if !ok {
+		return nil
+	}
+
+	var req model.InviteRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request body",
 		})
 	}
 
-	userID, err := uuid.Parse(req.UserID)
+	inviteeID, err := uuid.Parse(req.UserID)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid user ID",
@@ -141,60 +234,127 @@ func (h *RoomHandler) Join(c *fiber.Ctx) error {
 	}
 
 	ctx := context.Background()
-	if err := h.roomRepo.AddMember(ctx, roomID, userID); err != nil {
+	if _, err := h.userRepo.GetByID(ctx, inviteeID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	inviterID, _ := middleware.UserID(c)
+	if err := h.roomRepo.CreateInvitation(ctx, room.ID, inviteeID, inviterID); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to join room",
+			"error": "Failed to invite user",
 		})
 	}
 
-	return c.JSON(fiber.Map{
-		"message": "Successfully joined room",
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Invitation sent",
 	})
 }
 
-// GetMembers gets all members of a room
-func (h *RoomHandler) GetMembers(c *fiber.Ctx) error {
-	roomIDStr := c.Params("id")
-	roomID, err := uuid.Parse(roomIDStr)
+// ListInvitations returns the rooms the current user has been invited to
+func (h *RoomHandler) ListInvitations(c *fiber.Ctx) error {
+	userID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	invitations, err := h.roomRepo.GetInvitationsForUser(ctx, userID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid room ID",
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch invitations",
 		})
 	}
 
+	if invitations == nil {
+		invitations = []model.RoomInvitation{}
+	}
+
+	return c.JSON(invitations)
+}
+
+// ListJoinRequests returns pending join requests for a room
+func (h *RoomHandler) ListJoinRequests(c *fiber.Ctx) error {
+	room, ok := h.authorizeRoom(c, model.PermInvite)
+	if !ok {
+		return nil
+	}
+
 	ctx := context.Background()
-	members, err := h.roomRepo.GetMembers(ctx, roomID)
+	requests, err := h.roomRepo.GetJoinRequests(ctx, room.ID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch members",
+			"error": "Failed to fetch join requests",
 		})
 	}
 
-	if members == nil {
-		members = []model.User{}
+	if requests == nil {
+		requests = []model.RoomJoinRequest{}
 	}
 
-	return c.JSON(members)
+	return c.JSON(requests)
 }
 
-// MarkAsRead marks all messages in a room as read for a user
-func (h *RoomHandler) MarkAsRead(c *fiber.Ctx) error {
-	roomIDStr := c.Params("id")
-	roomID, err := uuid.Parse(roomIDStr)
+// ApproveJoinRequest admits a user who asked to join a room, unless they
+// have been banned from it
+func (h *RoomHandler) ApproveJoinRequest(c *fiber.Ctx) error {
+	room, ok := h.authorizeRoom(c, model.PermInvite)
+	if !ok {
+		return nil
+	}
+
+	userID, err := uuid.Parse(c.Params("userId"))
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid room ID",
+			"error": "Invalid user ID",
 		})
 	}
 
-	userIDStr := c.Query("userId")
-	if userIDStr == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "userId is required",
+	ctx := context.Background()
+
+	// A ban drops the user's pending requests, but one filed while the ban
+	// was being recorded can slip past that
+	ban, _, err := h.roomService.Restrictions(ctx, room.ID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to approve join request",
+		})
+	}
+	if ban != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "User is banned from this room",
 		})
 	}
 
-	userID, err := uuid.Parse(userIDStr)
+	found, err := h.roomRepo.DeleteJoinRequest(ctx, room.ID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to approve join request",
+		})
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Join request not found",
+		})
+	}
+
+	if err := h.roomRepo.AddMember(ctx, room.ID, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to add member",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Join request approved",
+	})
+}
+
+// RejectJoinRequest discards a pending join request
+func (h *RoomHandler) RejectJoinRequest(c *fiber.Ctx) error {
+	room, ok := h.authorizeRoom(c, model.PermInvite)
+	if !ok {
+		return nil
+	}
+
+	userID, err := uuid.Parse(c.Params("userId"))
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid user ID",
@@ -202,28 +362,173 @@ func (h *RoomHandler) MarkAsRead(c *fiber.Ctx) error {
 	}
 
 	ctx := context.Background()
+	found, err := h.roomRepo.DeleteJoinRequest(ctx, room.ID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to reject join request",
+		})
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Join request not found",
+		})
+	}
 
-	// Ensure user is a member first (add if not)
-	if err := h.roomRepo.AddMember(ctx, roomID, userID); err != nil {
+	return c.JSON(fiber.Map{
+		"message": "Join request rejected",
+	})
+}
+
+// Update renames a room or changes its description or privacy
+func (h *RoomHandler) Update(c *fiber.Ctx) error {
+	room, ok := h.authorizeRoom(c, model.PermManageRoom)
+	if !ok {
+		return nil
+	}
+	if room.Kind != model.RoomKindRoom {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Direct conversations can't be changed",
+		})
+	}
+
+	var req model.UpdateRoomRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.Name != nil && *req.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Room name cannot be empty",
+		})
+	}
+
+	if req.SlowModeSeconds != nil {
+		seconds := *req.SlowModeSeconds
+		if seconds < 0 || time.Duration(seconds)*time.Second > h.maxSlowMode {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("slow_mode_seconds must be between 0 and %d", int(h.maxSlowMode.Seconds())),
+			})
+		}
+	}
+
+	ctx := context.Background()
+	updated, err := h.roomRepo.Update(ctx, room.ID, &req)
+	if err != nil {
+		log.Printf("❌ Error updating room: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to add member",
+			"error": "Failed to update room",
 		})
 	}
 
-	// Update last_read_at
-	if err := h.roomRepo.UpdateLastRead(ctx, roomID, userID); err != nil {
+	return c.JSON(updated)
+}
+
+// UpdateMemberRole promotes or demotes a member and notifies the room
+func (h *RoomHandler) UpdateMemberRole(c *fiber.Ctx) error {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	targetID, err := uuid.Parse(c.Params("userId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req model.UpdateRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	actorID, _ := middleware.UserID(c)
+
+	ctx := context.Background()
+	if err := h.roomService.ChangeRole(ctx, roomID, actorID, targetID, req.Role); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRole):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid role",
+			})
+		case errors.Is(err, service.ErrForbidden):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You cannot assign that role",
+			})
+		case errors.Is(err, repository.ErrNotMember):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User is not a member of this room",
+			})
+		}
+		log.Printf("❌ Error changing role: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to mark as read",
+			"error": "Failed to change role",
 		})
 	}
 
+	h.hub.BroadcastToRoom(roomID.String(), model.WSMessage{
+		Type: model.WSTypeRoleChanged,
+		Payload: model.RoleChangedPayload{
+			RoomID:    roomID.String(),
+			UserID:    targetID.String(),
+			Role:      req.Role,
+			ChangedBy: actorID.String(),
+		},
+	})
+
 	return c.JSON(fiber.Map{
-		"message": "Marked as read",
+		"message": "Role updated",
+		"role":    req.Role,
 	})
 }
 
-// GetUnreadCount gets unread message count for a user in a room
-func (h *RoomHandler) GetUnreadCount(c *fiber.Ctx) error {
+// authorizeRoom loads the room from the :id param and checks that the current
+// user's role grants perm. When it returns false the error response is
+// already written.
+func (h *RoomHandler) authorizeRoom(c *fiber.Ctx, perm model.Permission) (*model.Room, bool) {
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+		return nil, false
+	}
+
+	ctx := context.Background()
+	room, err := h.roomRepo.GetByID(ctx, roomID)
+	if err != nil {
+		c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Room not found",
+		})
+		return nil, false
+	}
+
+	userID, _ := middleware.UserID(c)
+	if _, err := h.roomService.Authorize(ctx, roomID, userID, perm); err != nil {
+		if errors.Is(err, service.ErrForbidden) {
+			c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You don't have permission to do that in this room",
+			})
+		} else {
+			log.Printf("❌ Error checking room permission: %v", err)
+			c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check permissions",
+			})
+		}
+		return nil, false
+	}
+
+	return room, true
+}
+
+// GetMembers gets all members of a room
+func (h *RoomHandler) GetMembers(c *fiber.Ctx) error {
 	roomIDStr := c.Params("id")
 	roomID, err := uuid.Parse(roomIDStr)
 	if err != nil {
@@ -232,20 +537,33 @@ func (h *RoomHandler) GetUnreadCount(c *fiber.Ctx) error {
 		})
 	}
 
-	userIDStr := c.Query("userId")
-	if userIDStr == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "userId is required",
+	ctx := context.Background()
+	members, err := h.roomRepo.GetMembers(ctx, roomID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch members",
 		})
 	}
 
-	userID, err := uuid.Parse(userIDStr)
+	if members == nil {
+		members = []model.MemberWithUser{}
+	}
+
+	return c.JSON(members)
+}
+
+// GetUnreadCount gets unread message count for a user in a room
+func (h *RoomHandler) GetUnreadCount(c *fiber.Ctx) error {
+	roomIDStr := c.Params("id")
+	roomID, err := uuid.Parse(roomIDStr)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid user ID",
+			"error": "Invalid room ID",
 		})
 	}
 
+	userID, _ := middleware.UserID(c)
+
 	ctx := context.Background()
 	count, err := h.roomRepo.GetUnreadCount(ctx, roomID, userID)
 	if err != nil {
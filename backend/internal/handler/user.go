@@ -2,24 +2,39 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"log"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/middleware"
 	"github.com/khonE3/chat-backend/internal/model"
 	"github.com/khonE3/chat-backend/internal/repository"
+	"github.com/khonE3/chat-backend/internal/service"
 )
 
 type UserHandler struct {
-	userRepo *repository.UserRepository
+	userRepo    *repository.UserRepository
+	sessionRepo *repository.SessionRepository
+	authService *service.AuthService
 }
 
-func NewUserHandler(userRepo *repository.UserRepository) *UserHandler {
-	return &UserHandler{userRepo: userRepo}
+func NewUserHandler(userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, authService *service.AuthService) *UserHandler {
+	return &UserHandler{
+		userRepo:    userRepo,
+		sessionRepo: sessionRepo,
+		authService: authService,
+	}
 }
 
-// Create creates a new user or returns existing one
+// Create creates a new user or returns existing one (nickname-only demo flow)
 func (h *UserHandler) Create(c *fiber.Ctx) error {
+	if !h.authService.NicknameLoginEnabled() {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Nickname-only accounts are disabled, use /api/auth/register",
+		})
+	}
+
 	var req model.CreateUserRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -34,7 +49,12 @@ func (h *UserHandler) Create(c *fiber.Ctx) error {
 	}
 
 	ctx := context.Background()
-	user, err := h.userRepo.GetOrCreate(ctx, &req)
+	user, err := h.authService.NicknameLogin(ctx, &req)
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Username is registered, log in with password",
+		})
+	}
 	if err != nil {
 		log.Printf("❌ Error creating user: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -85,3 +105,232 @@ func (h *UserHandler) GetByUsername(c *fiber.Ctx) error {
 
 	return c.JSON(user)
 }
+
+// Register creates a password-protected account and issues a session token
+func (h *UserHandler) Register(c *fiber.Ctx) error {
+	var req model.RegisterRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.Username == "" || req.DisplayName == "" || req.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Username, display_name and password are required",
+		})
+	}
+
+	ctx := context.Background()
+	user, err := h.authService.Register(ctx, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUsernameTaken):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Username already taken",
+			})
+		case errors.Is(err, service.ErrPasswordTooShort), errors.Is(err, service.ErrPasswordTooLong):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("❌ Error registering user: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to register",
+		})
+	}
+
+	c.Status(fiber.StatusCreated)
+	return h.issueSession(c, user)
+}
+
+// Login signs a user in with username and password and issues a session token.
+// When nickname login is enabled, password-less accounts may omit the password.
+func (h *UserHandler) Login(c *fiber.Ctx) error {
+	var req model.LoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.Username == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Username is required",
+		})
+	}
+
+	ctx := context.Background()
+
+	var user *model.User
+	var err error
+	if req.Password == "" && h.authService.NicknameLoginEnabled() {
+		if req.DisplayName == "" {
+			req.DisplayName = req.Username
+		}
+		user, err = h.authService.NicknameLogin(ctx, &model.CreateUserRequest{
+			Username:    req.Username,
+			DisplayName: req.DisplayName,
+		})
+	} else {
+		user, err = h.authService.Login(ctx, req.Username, req.Password)
+	}
+
+	if err != nil {
+		var lockedErr *service.AccountLockedError
+		switch {
+		case errors.As(err, &lockedErr):
+			return c.Status(fiber.StatusLocked).JSON(fiber.Map{
+				"error":        "Account temporarily locked after too many failed attempts",
+				"locked_until": lockedErr.Until,
+			})
+		case errors.Is(err, service.ErrInvalidCredentials):
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid username or password",
+			})
+		}
+		log.Printf("❌ Error logging in user: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to log in",
+		})
+	}
+
+	return h.issueSession(c, user)
+}
+
+// ChangePassword updates the authenticated user's password and signs out
+// their other sessions
+func (h *UserHandler) ChangePassword(c *fiber.Ctx) error {
+	var req model.ChangePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	session := middleware.CurrentSession(c)
+
+	ctx := context.Background()
+	if err := h.authService.ChangePassword(ctx, session.UserID, &req); err != nil {
+		var lockedErr *service.AccountLockedError
+		switch {
+		case errors.As(err, &lockedErr):
+			return c.Status(fiber.StatusLocked).JSON(fiber.Map{
+				"error":        "Account temporarily locked after too many failed attempts",
+				"locked_until": lockedErr.Until,
+			})
+		case errors.Is(err, service.ErrInvalidCredentials):
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Current password is incorrect",
+			})
+		case errors.Is(err, service.ErrPasswordTooShort), errors.Is(err, service.ErrPasswordTooLong):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("❌ Error changing password: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to change password",
+		})
+	}
+
+	// A password change is often meant to lock someone out, so every other
+	// session ends; the caller stays signed in
+	if err := h.sessionRepo.DeleteOthers(ctx, session.UserID, session.Token); err != nil {
+		log.Printf("❌ Error revoking sessions: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Password changed, but other sessions could not be signed out",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Password changed",
+	})
+}
+
+// Logout revokes the current session token
+func (h *UserHandler) Logout(c *fiber.Ctx) error {
+	session := middleware.CurrentSession(c)
+
+	ctx := context.Background()
+	if err := h.sessionRepo.Delete(ctx, session.Token); err != nil {
+		log.Printf("❌ Error deleting session: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to log out",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Logged out",
+	})
+}
+
+// Me returns the authenticated user
+func (h *UserHandler) Me(c *fiber.Ctx) error {
+	session := middleware.CurrentSession(c)
+
+	ctx := context.Background()
+	user, err := h.userRepo.GetByID(ctx, session.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	return c.JSON(user)
+}
+
+// GetSettings returns the caller's preferences
+func (h *UserHandler) GetSettings(c *fiber.Ctx) error {
+	session := middleware.CurrentSession(c)
+
+	ctx := context.Background()
+	settings, err := h.userRepo.GetSettings(ctx, session.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	return c.JSON(settings)
+}
+
+// UpdateSettings changes the caller's preferences; omitted fields are kept
+func (h *UserHandler) UpdateSettings(c *fiber.Ctx) error {
+	session := middleware.CurrentSession(c)
+
+	var req model.UpdateSettingsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	ctx := context.Background()
+	settings, err := h.userRepo.UpdateSettings(ctx, session.UserID, &req)
+	if err != nil {
+		log.Printf("❌ Error updating settings: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update settings",
+		})
+	}
+
+	return c.JSON(settings)
+}
+
+func (h *UserHandler) issueSession(c *fiber.Ctx, user *model.User) error {
+	ctx := context.Background()
+	session, err := h.sessionRepo.Create(ctx, user)
+	if err != nil {
+		log.Printf("❌ Error creating session: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create session",
+		})
+	}
+
+	return c.JSON(model.LoginResponse{
+		Token:     session.Token,
+		ExpiresAt: session.ExpiresAt,
+		User:      user,
+	})
+}
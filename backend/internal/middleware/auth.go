@@ -1,25 +1,99 @@
 package middleware
 
 import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
 )
 
-// SimpleAuth is a basic middleware for nickname-based auth
-// In production, replace with JWT authentication
-func SimpleAuth() fiber.Handler {
+// LocalsSession is the c.Locals key holding the authenticated *model.Session.
+// It is also visible through websocket.Conn.Locals after an upgrade.
+const LocalsSession = "session"
+
+// RequireAuth rejects requests without a valid session token
+func RequireAuth(sessionRepo *repository.SessionRepository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		userID := c.Get("X-User-ID")
-		username := c.Get("X-Username")
+		err := authenticate(c, sessionRepo)
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Authentication required",
+			})
+		}
+		if err != nil {
+			return sessionUnavailable(c, err)
+		}
+		return c.Next()
+	}
+}
 
-		if userID == "" || username == "" {
-			// Allow request to continue but without auth context
-			return c.Next()
+// OptionalAuth attaches the session when a valid token is present but lets
+// anonymous requests through
+func OptionalAuth(sessionRepo *repository.SessionRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := authenticate(c, sessionRepo)
+		if err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+			return sessionUnavailable(c, err)
 		}
+		return c.Next()
+	}
+}
 
-		// Store in locals for handlers to access
-		c.Locals("userID", userID)
-		c.Locals("username", username)
+// CurrentSession returns the session stored by the auth middleware, or nil
+func CurrentSession(c *fiber.Ctx) *model.Session {
+	session, _ := c.Locals(LocalsSession).(*model.Session)
+	return session
+}
 
-		return c.Next()
+// UserID returns the authenticated user's ID
+func UserID(c *fiber.Ctx) (uuid.UUID, bool) {
+	session := CurrentSession(c)
+	if session == nil {
+		return uuid.Nil, false
+	}
+	return session.UserID, true
+}
+
+// authenticate attaches the session for the request's token. It returns
+// repository.ErrSessionNotFound when there is no token or the session has
+// expired, and other errors when the session store can't be reached.
+func authenticate(c *fiber.Ctx, sessionRepo *repository.SessionRepository) error {
+	token := extractToken(c)
+	if token == "" {
+		return repository.ErrSessionNotFound
+	}
+
+	session, err := sessionRepo.Get(context.Background(), token)
+	if err != nil {
+		return err
+	}
+
+	c.Locals(LocalsSession, session)
+	return nil
+}
+
+// sessionUnavailable answers 503 when a token couldn't be checked, so
+// clients retry instead of treating themselves as logged out
+func sessionUnavailable(c *fiber.Ctx, err error) error {
+	log.Printf("❌ Error looking up session: %v", err)
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"error": "Could not check your session, try again shortly",
+	})
+}
+
+// extractToken reads the bearer token from the Authorization header, falling
+// back to the token query parameter since browsers cannot set headers on
+// WebSocket upgrades
+func extractToken(c *fiber.Ctx) string {
+	if header := c.Get(fiber.HeaderAuthorization); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
 	}
+	return c.Query("token")
 }
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// BodyLimit answers 413 when a request body is larger than limit. The
+// server-wide limit has to fit the largest upload, so this holds every other
+// route to a smaller one; requests for which skip returns true are let
+// through.
+func BodyLimit(limit int, skip func(c *fiber.Ctx) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if skip != nil && skip(c) {
+			return c.Next()
+		}
+
+		// Request().Body() rather than Body(), which would inflate a
+		// compressed body first
+		if len(c.Request().Body()) > limit {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error": "Request body too large",
+			})
+		}
+		return c.Next()
+	}
+}
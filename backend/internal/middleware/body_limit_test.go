@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestBodyLimit(t *testing.T) {
+	app := fiber.New()
+	app.Use(BodyLimit(8, func(c *fiber.Ctx) bool {
+		return c.Path() == "/upload"
+	}))
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) }
+	app.Post("/upload", ok)
+	app.Post("/other", ok)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"within the limit", "/other", "12345678", fiber.StatusNoContent},
+		{"over the limit", "/other", "123456789", fiber.StatusRequestEntityTooLarge},
+		{"skipped route", "/upload", "123456789", fiber.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, tt.path, strings.NewReader(tt.body)))
+			if err != nil {
+				t.Fatalf("app.Test: %v", err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("POST %s with %d bytes = %d, want %d", tt.path, len(tt.body), resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/khonE3/chat-backend/internal/service"
+)
+
+// RateLimitKey picks the subject a request is counted against
+type RateLimitKey func(c *fiber.Ctx) string
+
+// ByUser counts requests per authenticated user. Mount after RequireAuth.
+func ByUser(c *fiber.Ctx) string {
+	userID, _ := UserID(c)
+	return userID.String()
+}
+
+// ByIP counts requests per client address
+func ByIP(c *fiber.Ctx) string {
+	return c.IP()
+}
+
+// RateLimit answers 429 with Retry-After once the caller has used up their
+// bucket for action
+func RateLimit(limiter *service.RateLimitService, action service.RateLimitAction, key RateLimitKey) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := limiter.Allow(context.Background(), action, key(c))
+
+		var limited *service.RateLimitError
+		if errors.As(err, &limited) {
+			return TooManyRequests(c, limited)
+		}
+		return c.Next()
+	}
+}
+
+// TooManyRequests writes the 429 response for a rate limit error
+func TooManyRequests(c *fiber.Ctx, limited *service.RateLimitError) error {
+	// Retry-After is in whole seconds, rounded up so clients never retry early
+	seconds := (limited.RetryAfter.Milliseconds() + 999) / 1000
+	c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(seconds, 10))
+
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":          "Too many requests, slow down",
+		"retry_after_ms": limited.RetryAfter.Milliseconds(),
+		"slow_mode":      limited.SlowMode,
+	})
+}
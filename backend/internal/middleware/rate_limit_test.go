@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/khonE3/chat-backend/internal/service"
+)
+
+func TestTooManyRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter time.Duration
+		slowMode   bool
+		header     string
+	}{
+		{"whole seconds", 2 * time.Second, false, "2"},
+		{"rounded up", 1500 * time.Millisecond, false, "2"},
+		{"under a second", time.Millisecond, true, "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error {
+				return TooManyRequests(c, &service.RateLimitError{
+					Action:     service.RateLimitMessage,
+					RetryAfter: tt.retryAfter,
+					SlowMode:   tt.slowMode,
+				})
+			})
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+			if err != nil {
+				t.Fatalf("app.Test: %v", err)
+			}
+			if resp.StatusCode != fiber.StatusTooManyRequests {
+				t.Errorf("status = %d, want 429", resp.StatusCode)
+			}
+			if got := resp.Header.Get(fiber.HeaderRetryAfter); got != tt.header {
+				t.Errorf("Retry-After = %q, want %q", got, tt.header)
+			}
+
+			var body struct {
+				RetryAfterMs int64 `json:"retry_after_ms"`
+				SlowMode     bool  `json:"slow_mode"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if body.RetryAfterMs != tt.retryAfter.Milliseconds() || body.SlowMode != tt.slowMode {
+				t.Errorf("body = %+v, want retry_after_ms %d slow_mode %v", body, tt.retryAfter.Milliseconds(), tt.slowMode)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/repository"
+)
+
+// RequireRoomAccess rejects requests for private rooms the current user is
+// not a member of. param names the route parameter holding the room ID.
+// Mount after RequireAuth or OptionalAuth.
+func RequireRoomAccess(roomRepo *repository.RoomRepository, param string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		roomID, err := uuid.Parse(c.Params(param))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid room ID",
+			})
+		}
+
+		// Anonymous callers keep uuid.Nil, which is never a member
+		userID, _ := UserID(c)
+
+		allowed, err := roomRepo.CanAccess(context.Background(), roomID, userID)
+		if err != nil {
+			log.Printf("❌ Error checking room access: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check room access",
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You are not a member of this room",
+			})
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Attachment is a file uploaded with a message. The blob itself lives in
+// the storage backend; clients download it through URL.
+type Attachment struct {
+	ID           uuid.UUID  `json:"id"`
+	MessageID    uuid.UUID  `json:"message_id"`
+	RoomID       uuid.UUID  `json:"room_id"`
+	UserID       *uuid.UUID `json:"user_id,omitempty"`
+	FileName     string     `json:"file_name"`
+	ContentType  string     `json:"content_type"`
+	SizeBytes    int64      `json:"size_bytes"`
+	StorageKey   string     `json:"-"`
+	ThumbnailKey *string    `json:"-"`
+	Width        *int       `json:"width,omitempty"`
+	Height       *int       `json:"height,omitempty"`
+	URL          string     `json:"url"`
+	ThumbnailURL *string    `json:"thumbnail_url,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// AttachmentURL is where clients download an attachment
+func AttachmentURL(id uuid.UUID) string {
+	return "/api/attachments/" + id.String()
+}
+
+// AttachmentThumbnailURL is where clients download an image thumbnail
+func AttachmentThumbnailURL(id uuid.UUID) string {
+	return AttachmentURL(id) + "/thumbnail"
+}
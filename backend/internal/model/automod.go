@@ -0,0 +1,131 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AutomodRuleKind is what an automod rule looks for in a message
+type AutomodRuleKind string
+
+const (
+	AutomodBlocklist AutomodRuleKind = "blocklist" // words and phrases
+	AutomodRegex     AutomodRuleKind = "regex"
+	AutomodLinks     AutomodRuleKind = "links"  // links to allowed or denied domains
+	AutomodCaps      AutomodRuleKind = "caps"   // shouting
+	AutomodSpam      AutomodRuleKind = "spam"   // mass mentions, long character runs, many lines
+	AutomodRepeat    AutomodRuleKind = "repeat" // the same message over and over
+)
+
+// AutomodAction is what happens to a message that trips a rule. When
+// several rules trip, the most severe action wins: mask, hold, reject, mute.
+type AutomodAction string
+
+const (
+	AutomodMask   AutomodAction = "mask"   // post with the matched text starred out
+	AutomodHold   AutomodAction = "hold"   // keep back until a moderator approves it
+	AutomodReject AutomodAction = "reject" // refuse the message
+	AutomodMute   AutomodAction = "mute"   // refuse it and time the sender out
+)
+
+// Severity orders actions from least to most severe
+func (a AutomodAction) Severity() int {
+	switch a {
+	case AutomodMask:
+		return 1
+	case AutomodHold:
+		return 2
+	case AutomodReject:
+		return 3
+	case AutomodMute:
+		return 4
+	}
+	return 0
+}
+
+// LinkMode says whether a links rule lists the only domains allowed or the
+// domains that are not
+type LinkMode string
+
+const (
+	LinkAllow LinkMode = "allow"
+	LinkDeny  LinkMode = "deny"
+)
+
+// AutomodRule is one automod rule, global or for a single room. Only the
+// settings of its kind apply.
+type AutomodRule struct {
+	ID      uuid.UUID       `json:"id"`
+	RoomID  *uuid.UUID      `json:"room_id,omitempty"` // nil for rules that apply everywhere
+	Name    string          `json:"name"`
+	Kind    AutomodRuleKind `json:"kind"`
+	Action  AutomodAction   `json:"action"`
+	Enabled bool            `json:"enabled"`
+
+	// MuteSeconds is how long the mute action times the sender out
+	MuteSeconds int `json:"mute_seconds,omitempty"`
+
+	Words         []string `json:"words,omitempty"`          // blocklist
+	Pattern       string   `json:"pattern,omitempty"`        // regex, RE2 syntax
+	LinkMode      LinkMode `json:"link_mode,omitempty"`      // links
+	Domains       []string `json:"domains,omitempty"`        // links; subdomains match too
+	MinLetters    int      `json:"min_letters,omitempty"`    // caps: shorter messages are left alone
+	MaxCapsRatio  float64  `json:"max_caps_ratio,omitempty"` // caps: share of upper-case letters, 0–1
+	MaxMentions   int      `json:"max_mentions,omitempty"`   // spam
+	MaxRunLength  int      `json:"max_run_length,omitempty"` // spam: the same character in a row
+	MaxLines      int      `json:"max_lines,omitempty"`      // spam
+	MaxRepeats    int      `json:"max_repeats,omitempty"`    // repeat: identical messages allowed
+	WindowSeconds int      `json:"window_seconds,omitempty"` // repeat
+
+	CreatedBy uuid.UUID `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AutomodRuleRequest creates or replaces a rule. Enabled defaults to true.
+type AutomodRuleRequest struct {
+	Name          string          `json:"name"`
+	Kind          AutomodRuleKind `json:"kind"`
+	Action        AutomodAction   `json:"action"`
+	Enabled       *bool           `json:"enabled,omitempty"`
+	MuteSeconds   int             `json:"mute_seconds,omitempty"`
+	Words         []string        `json:"words,omitempty"`
+	Pattern       string          `json:"pattern,omitempty"`
+	LinkMode      LinkMode        `json:"link_mode,omitempty"`
+	Domains       []string        `json:"domains,omitempty"`
+	MinLetters    int             `json:"min_letters,omitempty"`
+	MaxCapsRatio  float64         `json:"max_caps_ratio,omitempty"`
+	MaxMentions   int             `json:"max_mentions,omitempty"`
+	MaxRunLength  int             `json:"max_run_length,omitempty"`
+	MaxLines      int             `json:"max_lines,omitempty"`
+	MaxRepeats    int             `json:"max_repeats,omitempty"`
+	WindowSeconds int             `json:"window_seconds,omitempty"`
+}
+
+type HeldStatus string
+
+const (
+	HeldPending  HeldStatus = "pending"
+	HeldApproved HeldStatus = "approved"
+	HeldRejected HeldStatus = "rejected"
+)
+
+// HeldMessage is a message automod kept back for a moderator to review.
+// Approving it posts it to the room as the original sender.
+type HeldMessage struct {
+	ID          uuid.UUID  `json:"id"`
+	RoomID      uuid.UUID  `json:"room_id"`
+	UserID      uuid.UUID  `json:"user_id"`
+	Username    string     `json:"username,omitempty"`
+	DisplayName string     `json:"display_name,omitempty"`
+	Content     string     `json:"content"`
+	ReplyToID   *uuid.UUID `json:"reply_to_id,omitempty"`
+	Nonce       *string    `json:"-"` // the sender's, carried over to the message when approved
+	RuleID      uuid.UUID  `json:"rule_id"`
+	RuleName    string     `json:"rule_name"`
+	Status      HeldStatus `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ReviewedBy  *uuid.UUID `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserCredential struct {
+	UserID         uuid.UUID  `json:"user_id"`
+	PasswordHash   string     `json:"-"`
+	FailedAttempts int        `json:"failed_attempts"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type RegisterRequest struct {
+	Username    string `json:"username" validate:"required,min=3,max=50"`
+	DisplayName string `json:"display_name" validate:"required,min=1,max=100"`
+	Password    string `json:"password" validate:"required,min=8,max=72"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=72"`
+}
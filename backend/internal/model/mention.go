@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MentionKind string
+
+const (
+	MentionUser MentionKind = "user" // @username
+	MentionHere MentionKind = "here" // @here: members online in the room
+	MentionRoom MentionKind = "room" // @room: every member
+)
+
+// Mention is a message that mentioned a user
+type Mention struct {
+	ID        uuid.UUID        `json:"id"`
+	Kind      MentionKind      `json:"kind"`
+	RoomID    uuid.UUID        `json:"room_id"`
+	RoomName  string           `json:"room_name"`
+	Message   *MessageWithUser `json:"message"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// MentionPayload is pushed to a mentioned user on the global WebSocket
+type MentionPayload struct {
+	Kind     MentionKind      `json:"kind"`
+	RoomID   string           `json:"room_id"`
+	RoomName string           `json:"room_name"`
+	Message  *MessageWithUser `json:"message"`
+}
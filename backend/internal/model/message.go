@@ -13,6 +13,10 @@ const (
 	MessageTypeSystem   MessageType = "system"
 	MessageTypeTyping   MessageType = "typing"
 	MessageTypePresence MessageType = "presence"
+
+	// MessageTypeAttachment messages carry one uploaded file; Content holds
+	// the optional caption
+	MessageTypeAttachment MessageType = "attachment"
 )
 
 type Message struct {
@@ -22,6 +26,21 @@ type Message struct {
 	Content     string      `json:"content"`
 	MessageType MessageType `json:"message_type"`
 	CreatedAt   time.Time   `json:"created_at"`
+	EditedAt    *time.Time  `json:"edited_at,omitempty"`
+	DeletedAt   *time.Time  `json:"deleted_at,omitempty"`
+
+	// Threading: replies are hidden from the room timeline and listed
+	// under their thread root
+	ReplyToID    *uuid.UUID `json:"reply_to_id,omitempty"`
+	ThreadRootID *uuid.UUID `json:"thread_root_id,omitempty"`
+
+	// Seq is the message's position in the room timeline (1, 2, 3, ...);
+	// zero for thread replies
+	Seq int64 `json:"seq,omitempty"`
+
+	// Nonce is the sender's client-generated ID. It is never serialized, so
+	// other members can't see it; the sender gets it back in their ack.
+	Nonce *string `json:"-"`
 }
 
 type MessageWithUser struct {
@@ -29,10 +48,134 @@ type MessageWithUser struct {
 	Username    string  `json:"username,omitempty"`
 	DisplayName string  `json:"display_name,omitempty"`
 	AvatarURL   *string `json:"avatar_url,omitempty"`
+
+	// Quoted message this one replies to
+	ReplyTo *MessagePreview `json:"reply_to,omitempty"`
+
+	// Thread summary, set on root messages only
+	ReplyCount  int        `json:"reply_count,omitempty"`
+	LastReplyAt *time.Time `json:"last_reply_at,omitempty"`
+
+	// Reactions as seen by the requesting user
+	Reactions []ReactionSummary `json:"reactions,omitempty"`
+
+	// Uploaded files; empty once the message is deleted
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// Previews of links in the content, filled in shortly after sending
+	LinkPreviews []LinkPreview `json:"link_previews,omitempty"`
+}
+
+// LinkPreview is the OpenGraph / Twitter card summary of a linked page
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
 }
 
-type SendMessageRequest struct {
-	Content string `json:"content" validate:"required,min=1,max=4000"`
+// MessagePreview is a short quote of another message
+type MessagePreview struct {
+	ID          uuid.UUID  `json:"id"`
+	UserID      *uuid.UUID `json:"user_id,omitempty"`
+	Username    string     `json:"username"`
+	DisplayName string     `json:"display_name"`
+	Content     string     `json:"content"`
+	Deleted     bool       `json:"deleted,omitempty"`
+}
+
+type ThreadReplyPayload struct {
+	RootID      string           `json:"root_id"`
+	Message     *MessageWithUser `json:"message"`
+	ReplyCount  int              `json:"reply_count"`
+	LastReplyAt time.Time        `json:"last_reply_at"`
+}
+
+// ReplayPayload carries the timeline messages a reconnecting client missed.
+// When Truncated is set the gap was too long to replay in full and the
+// client should page forward from the last message over REST.
+type ReplayPayload struct {
+	Since     int64             `json:"since"`
+	Messages  []MessageWithUser `json:"messages"`
+	Truncated bool              `json:"truncated"`
+}
+
+// AckPayload confirms to the sender that a message was stored. Duplicate is
+// set when the nonce had been seen before and no new message was created.
+type AckPayload struct {
+	Nonce     string    `json:"nonce,omitempty"`
+	MessageID uuid.UUID `json:"message_id"`
+	Seq       int64     `json:"seq,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Duplicate bool      `json:"duplicate,omitempty"`
+}
+
+// SentMessage is a message as returned to its sender over REST, the only
+// place besides the ack where its nonce is shown
+type SentMessage struct {
+	*MessageWithUser
+	Nonce *string `json:"nonce,omitempty"`
+}
+
+// NackReason is a machine-readable reason for a rejected send
+type NackReason string
+
+const (
+	NackForbidden     NackReason = "forbidden"
+	NackEmptyMessage  NackReason = "empty_message"
+	NackTooLong       NackReason = "message_too_long"
+	NackMuted         NackReason = "muted"
+	NackAutomod       NackReason = "automod"         // blocked by an automod rule
+	NackHeld          NackReason = "held_for_review" // kept back by automod until a moderator approves it
+	NackInvalid       NackReason = "invalid_request"
+	NackReplyNotFound NackReason = "reply_not_found"
+	NackNonceReused   NackReason = "nonce_reused"
+	NackInternal      NackReason = "internal_error"
+)
+
+// NackPayload tells the sender a message was not stored. Internal errors
+// are worth retrying with the same nonce; the others are not.
+type NackPayload struct {
+	Nonce   string     `json:"nonce,omitempty"`
+	Code    NackReason `json:"code"`
+	Message string     `json:"message"`
+	Limit   int        `json:"limit,omitempty"` // maximum length, for message_too_long
+
+	// ExpiresAt is when the sender's mute ends, for muted
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// EditMessageRequest carries new message content, which is cleaned and
+// checked by service.ContentValidator like any other message
+type EditMessageRequest struct {
+	Content string `json:"content"`
+}
+
+// ErrorCode is a machine-readable reason for an error frame or response
+type ErrorCode string
+
+const (
+	ErrCodeInvalidRequest ErrorCode = "invalid_request"
+	ErrCodeUnauthorized   ErrorCode = "unauthorized"
+	ErrCodeUnknownType    ErrorCode = "unknown_type"
+	ErrCodeEmptyMessage   ErrorCode = "empty_message"
+	ErrCodeMessageTooLong ErrorCode = "message_too_long"
+	ErrCodeForbidden      ErrorCode = "forbidden"
+	ErrCodeBanned         ErrorCode = "banned"
+	ErrCodeMuted          ErrorCode = "muted"
+	ErrCodeAutomod        ErrorCode = "automod"
+	ErrCodeNotFound       ErrorCode = "not_found"
+	ErrCodeInternal       ErrorCode = "internal_error"
+)
+
+// ErrorDetail says what was wrong with a request, alongside its
+// human-readable error text
+type ErrorDetail struct {
+	Code      ErrorCode  `json:"code"`
+	Field     string     `json:"field,omitempty"`
+	Limit     int        `json:"limit,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // when a ban or mute ends
 }
 
 // WebSocket message types
@@ -48,17 +191,59 @@ const (
 	WSTypeError       WSMessageType = "error"
 	WSTypeJoin        WSMessageType = "join"
 	WSTypeLeave       WSMessageType = "leave"
+	WSTypeRoleChanged WSMessageType = "role_changed"
+	WSTypeEdit        WSMessageType = "message_edit"
+	WSTypeDelete      WSMessageType = "message_delete"
+	WSTypeThreadReply WSMessageType = "thread_reply"
+	WSTypeReactionAdd WSMessageType = "reaction_add"
+	WSTypeReactionDel WSMessageType = "reaction_remove"
+	WSTypeReplay      WSMessageType = "replay"
+	WSTypeAck         WSMessageType = "ack"
+	WSTypeNack        WSMessageType = "nack"
+	WSTypeRead        WSMessageType = "read"
+	WSTypeReadReceipt WSMessageType = "read_receipt"
+	WSTypeMembers     WSMessageType = "members_changed"
+	WSTypeUpdate      WSMessageType = "message_update"
+	WSTypeRateLimited WSMessageType = "rate_limited"
+	WSTypeModeration  WSMessageType = "moderation"
 )
 
 type WSMessage struct {
 	Type    WSMessageType `json:"type"`
 	Payload interface{}   `json:"payload"`
+
+	// Seq is set on timeline frames (message, history, replay) to the room
+	// sequence number of the newest message they carry. A jump of more than
+	// one between frames means messages were missed.
+	Seq int64 `json:"seq,omitempty"`
+
+	// Error is set on error frames, whose payload stays the plain text
+	Error *ErrorDetail `json:"error,omitempty"`
 }
 
 type WSIncomingMessage struct {
-	Type    WSMessageType `json:"type"`
-	Content string        `json:"content,omitempty"`
-	UserID  string        `json:"user_id,omitempty"`
+	Type      WSMessageType `json:"type"`
+	Content   string        `json:"content,omitempty"`
+	UserID    string        `json:"user_id,omitempty"`
+	MessageID string        `json:"message_id,omitempty"`
+	ReplyToID string        `json:"reply_to_id,omitempty"`
+	Emoji     string        `json:"emoji,omitempty"`
+	Nonce     string        `json:"nonce,omitempty"`
+}
+
+// MessageUpdatePayload carries fields of a message that were filled in
+// after it was delivered
+type MessageUpdatePayload struct {
+	MessageID    string        `json:"message_id"`
+	RoomID       string        `json:"room_id"`
+	LinkPreviews []LinkPreview `json:"link_previews"`
+}
+
+type MessageDeletedPayload struct {
+	MessageID string    `json:"message_id"`
+	RoomID    string    `json:"room_id"`
+	DeletedBy string    `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
 }
 
 type TypingPayload struct {
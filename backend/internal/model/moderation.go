@@ -0,0 +1,73 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RestrictionKind is what a moderator has barred a user from doing in a room
+type RestrictionKind string
+
+const (
+	RestrictionBan     RestrictionKind = "ban"     // removed and kept out of the room
+	RestrictionMute    RestrictionKind = "mute"    // may read but not post
+	RestrictionTimeout RestrictionKind = "timeout" // a mute that always expires
+)
+
+// Silences reports whether the restriction stops the user posting. Bans do
+// too, but banned users are not let in at all.
+func (k RestrictionKind) Silences() bool {
+	return k == RestrictionMute || k == RestrictionTimeout
+}
+
+// RoomRestriction is a ban, mute or timeout of one user in one room. It
+// ends at ExpiresAt, or never when that is nil, unless lifted sooner.
+type RoomRestriction struct {
+	ID          uuid.UUID       `json:"id"`
+	RoomID      uuid.UUID       `json:"room_id"`
+	UserID      uuid.UUID       `json:"user_id"`
+	Username    string          `json:"username,omitempty"`
+	DisplayName string          `json:"display_name,omitempty"`
+	Kind        RestrictionKind `json:"kind"`
+	Reason      *string         `json:"reason,omitempty"`
+	CreatedBy   *uuid.UUID      `json:"created_by,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ExpiresAt   *time.Time      `json:"expires_at,omitempty"`
+}
+
+// ModerationRequest is the body of kick, ban, mute and timeout requests.
+// DurationSeconds of 0 makes a ban or mute permanent; timeouts need one.
+type ModerationRequest struct {
+	DurationSeconds int    `json:"duration_seconds,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+}
+
+type ModerationAction string
+
+const (
+	ModerationKick    ModerationAction = "kick"
+	ModerationBan     ModerationAction = "ban"
+	ModerationUnban   ModerationAction = "unban"
+	ModerationMute    ModerationAction = "mute"
+	ModerationTimeout ModerationAction = "timeout"
+	ModerationUnmute  ModerationAction = "unmute"
+)
+
+// Disconnects reports whether the target's connections to the room close
+func (a ModerationAction) Disconnects() bool {
+	return a == ModerationKick || a == ModerationBan
+}
+
+// ModerationPayload tells a room that a moderator, or automod, acted on a
+// user. Clients of a kicked or banned user receive it and are then
+// disconnected.
+type ModerationPayload struct {
+	RoomID    uuid.UUID        `json:"room_id"`
+	UserID    uuid.UUID        `json:"user_id"`
+	Action    ModerationAction `json:"action"`
+	ActorID   uuid.UUID        `json:"actor_id,omitzero"` // unset when Automod
+	Automod   bool             `json:"automod,omitempty"`
+	Reason    *string          `json:"reason,omitempty"`
+	ExpiresAt *time.Time       `json:"expires_at,omitempty"`
+}
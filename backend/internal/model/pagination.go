@@ -0,0 +1,74 @@
+package model
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// MessageCursor is a position in a room timeline. Messages are ordered by
+// (created_at, id) so that messages sharing a timestamp still sort stably.
+type MessageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// CursorFor returns the cursor pointing at msg
+func CursorFor(msg *Message) *MessageCursor {
+	return &MessageCursor{CreatedAt: msg.CreatedAt, ID: msg.ID}
+}
+
+// Encode returns the opaque string form handed to clients
+func (c *MessageCursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeMessageCursor(s string) (*MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	msgID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &MessageCursor{CreatedAt: createdAt, ID: msgID}, nil
+}
+
+// HistoryQuery selects a page of a room timeline. At most one of Before,
+// After and Around is set; with none the newest page is returned. Offset is
+// the legacy pagination mode and only applies when no cursor is given.
+type HistoryQuery struct {
+	Limit  int
+	Before *MessageCursor
+	After  *MessageCursor
+	Around *uuid.UUID
+	Offset int
+}
+
+// MessagePage is a page of history in chronological order. NextCursor goes
+// back in time (pass as before), PrevCursor forward (pass as after); each is
+// nil when there is nothing further in that direction.
+type MessagePage struct {
+	Messages   []MessageWithUser `json:"messages"`
+	NextCursor *string           `json:"next_cursor"`
+	PrevCursor *string           `json:"prev_cursor"`
+}
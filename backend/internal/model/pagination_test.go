@@ -0,0 +1,70 @@
+package model
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMessageCursorRoundTrip(t *testing.T) {
+	id := uuid.MustParse("4f1b6a3e-9d2c-4c7e-8a55-0b7e2f1c9d10")
+	bangkok := time.FixedZone("ICT", 7*60*60)
+
+	tests := []struct {
+		name      string
+		createdAt time.Time
+	}{
+		{"utc", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"nanoseconds kept", time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC)},
+		{"other zone", time.Date(2024, 3, 1, 19, 0, 0, 500, bangkok)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := &MessageCursor{CreatedAt: tt.createdAt, ID: id}
+			got, err := DecodeMessageCursor(cursor.Encode())
+			if err != nil {
+				t.Fatalf("DecodeMessageCursor: %v", err)
+			}
+			if !got.CreatedAt.Equal(tt.createdAt) || got.ID != id {
+				t.Errorf("round trip = %v %s, want %v %s", got.CreatedAt, got.ID, tt.createdAt, id)
+			}
+		})
+	}
+}
+
+func TestDecodeMessageCursorInvalid(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"no separator", encode("2024-03-01T12:00:00Z")},
+		{"bad time", encode("yesterday|4f1b6a3e-9d2c-4c7e-8a55-0b7e2f1c9d10")},
+		{"bad id", encode("2024-03-01T12:00:00Z|42")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeMessageCursor(tt.in); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("DecodeMessageCursor(%q) = %v, want ErrInvalidCursor", tt.in, err)
+			}
+		})
+	}
+}
+
+func TestCursorFor(t *testing.T) {
+	msg := &Message{ID: uuid.New(), CreatedAt: time.Now()}
+	cursor := CursorFor(msg)
+	if cursor.ID != msg.ID || !cursor.CreatedAt.Equal(msg.CreatedAt) {
+		t.Errorf("CursorFor = %+v, want the message's id and time", cursor)
+	}
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit is a token bucket: Burst actions at once, refilled evenly over
+// Period. A zero Burst means no limit.
+type RateLimit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// ParseRateLimit reads a limit written as "<burst>/<period>", e.g. "10/10s".
+// "0" disables the limit.
+func ParseRateLimit(s string) (RateLimit, error) {
+	s = strings.TrimSpace(s)
+	if s == "0" {
+		return RateLimit{}, nil
+	}
+
+	burst, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q: want <count>/<period>", s)
+	}
+	n, err := strconv.Atoi(burst)
+	if err != nil || n < 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: invalid count", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: invalid period", s)
+	}
+	return RateLimit{Burst: n, Period: d}, nil
+}
+
+// Enabled reports whether the limit restricts anything
+func (l RateLimit) Enabled() bool {
+	return l.Burst > 0
+}
+
+// RateLimitedPayload tells a client an action was dropped for going over a
+// rate limit or the room's slow mode. Nonce echoes a rejected send, which
+// was not stored and may be retried after RetryAfterMs.
+type RateLimitedPayload struct {
+	Action       string `json:"action"`
+	Nonce        string `json:"nonce,omitempty"`
+	RetryAfterMs int64  `json:"retry_after_ms"`
+	SlowMode     bool   `json:"slow_mode,omitempty"`
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    RateLimit
+		wantErr bool
+	}{
+		{"10/10s", RateLimit{Burst: 10, Period: 10 * time.Second}, false},
+		{" 5/1h ", RateLimit{Burst: 5, Period: time.Hour}, false},
+		{"0", RateLimit{}, false},
+		{"0/1m", RateLimit{Burst: 0, Period: time.Minute}, false},
+		{"10", RateLimit{}, true},
+		{"ten/10s", RateLimit{}, true},
+		{"-1/10s", RateLimit{}, true},
+		{"10/soon", RateLimit{}, true},
+		{"10/0s", RateLimit{}, true},
+		{"10/-1s", RateLimit{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseRateLimit(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRateLimit(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRateLimit(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitEnabled(t *testing.T) {
+	if (RateLimit{}).Enabled() {
+		t.Error("zero limit is enabled")
+	}
+	if !(RateLimit{Burst: 1, Period: time.Second}).Enabled() {
+		t.Error("1/1s limit is disabled")
+	}
+}
@@ -0,0 +1,22 @@
+package model
+
+// ReactionSummary aggregates one emoji's reactions on a message
+type ReactionSummary struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji" validate:"required,max=32"`
+}
+
+// ReactionPayload is broadcast on reaction_add and reaction_remove. Clients
+// derive "reacted by me" by comparing UserID with their own.
+type ReactionPayload struct {
+	MessageID string `json:"message_id"`
+	RoomID    string `json:"room_id"`
+	UserID    string `json:"user_id"`
+	Emoji     string `json:"emoji"`
+	Count     int    `json:"count"`
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReadReceiptPayload announces that a user has read a room up to MessageID
+type ReadReceiptPayload struct {
+	RoomID      uuid.UUID `json:"room_id"`
+	MessageID   uuid.UUID `json:"message_id"`
+	Seq         int64     `json:"seq,omitempty"`
+	UserID      uuid.UUID `json:"user_id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   *string   `json:"avatar_url,omitempty"`
+	ReadAt      time.Time `json:"read_at"`
+}
+
+type MarkReadRequest struct {
+	MessageID *uuid.UUID `json:"message_id,omitempty"`
+}
+
+// UserSettings are per-user preferences
+type UserSettings struct {
+	// ReadReceipts shares the user's read position with other members
+	ReadReceipts bool `json:"read_receipts"`
+}
+
+type UpdateSettingsRequest struct {
+	ReadReceipts *bool `json:"read_receipts,omitempty"`
+}
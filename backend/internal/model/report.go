@@ -0,0 +1,126 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReportReason is why a user flagged a message
+type ReportReason string
+
+const (
+	ReportSpam       ReportReason = "spam"
+	ReportHarassment ReportReason = "harassment"
+	ReportHate       ReportReason = "hate"
+	ReportSexual     ReportReason = "sexual"
+	ReportViolence   ReportReason = "violence"
+	ReportOther      ReportReason = "other"
+)
+
+// Valid reports whether r is a known reason
+func (r ReportReason) Valid() bool {
+	switch r {
+	case ReportSpam, ReportHarassment, ReportHate, ReportSexual, ReportViolence, ReportOther:
+		return true
+	}
+	return false
+}
+
+type ReportStatus string
+
+const (
+	ReportOpen      ReportStatus = "open"
+	ReportDismissed ReportStatus = "dismissed" // nothing wrong with the message
+	ReportActioned  ReportStatus = "actioned"  // the message was deleted or its author banned
+)
+
+// ReportAction is what a reviewer did about a report. Audit entries use the
+// same values, plus ReportFiled for the report itself and ReportReopened for
+// an action that failed partway.
+type ReportAction string
+
+const (
+	ReportDismiss       ReportAction = "dismiss"
+	ReportDeleteMessage ReportAction = "delete_message"
+	ReportBanAuthor     ReportAction = "ban_author" // also deletes the message
+	ReportFiled         ReportAction = "report"
+	ReportReopened      ReportAction = "reopened"
+)
+
+// Valid reports whether a is an action a reviewer can take
+func (a ReportAction) Valid() bool {
+	switch a {
+	case ReportDismiss, ReportDeleteMessage, ReportBanAuthor:
+		return true
+	}
+	return false
+}
+
+// Status returns the status a report is left in once a is taken
+func (a ReportAction) Status() ReportStatus {
+	if a == ReportDismiss {
+		return ReportDismissed
+	}
+	return ReportActioned
+}
+
+// Report is one user's flag on a message. Content is a copy of the message
+// as it was reported, so the evidence survives edits and deletion.
+type Report struct {
+	ID                uuid.UUID     `json:"id"`
+	MessageID         uuid.UUID     `json:"message_id"`
+	RoomID            uuid.UUID     `json:"room_id"`
+	ReporterID        uuid.UUID     `json:"reporter_id"`
+	ReporterUsername  string        `json:"reporter_username,omitempty"`
+	AuthorID          *uuid.UUID    `json:"author_id,omitempty"`
+	AuthorUsername    *string       `json:"author_username,omitempty"`
+	AuthorDisplayName *string       `json:"author_display_name,omitempty"`
+	Content           string        `json:"content"`
+	Reason            ReportReason  `json:"reason"`
+	Details           *string       `json:"details,omitempty"`
+	Status            ReportStatus  `json:"status"`
+	Action            *ReportAction `json:"action,omitempty"`
+	ResolvedBy        *uuid.UUID    `json:"resolved_by,omitempty"`
+	ResolvedAt        *time.Time    `json:"resolved_at,omitempty"`
+	Note              *string       `json:"note,omitempty"`
+	CreatedAt         time.Time     `json:"created_at"`
+	OpenReports       int           `json:"open_reports"` // open reports on the same message
+}
+
+// ReportRequest is the body of POST /api/messages/:id/report
+type ReportRequest struct {
+	Reason  ReportReason `json:"reason"`
+	Details string       `json:"details,omitempty"`
+}
+
+// ResolveReportRequest settles a report. Reason is the reviewer's note;
+// DurationSeconds applies to ban_author, where 0 bans for good.
+type ResolveReportRequest struct {
+	Action          ReportAction `json:"action"`
+	Reason          string       `json:"reason,omitempty"`
+	DurationSeconds int          `json:"duration_seconds,omitempty"`
+}
+
+// ReportAuditEntry records one thing done to a report and by whom
+type ReportAuditEntry struct {
+	ID            uuid.UUID    `json:"id"`
+	ReportID      uuid.UUID    `json:"report_id"`
+	ActorID       *uuid.UUID   `json:"actor_id,omitempty"`
+	ActorUsername *string      `json:"actor_username,omitempty"`
+	Action        ReportAction `json:"action"`
+	Note          *string      `json:"note,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+// ReportDetail is a report as a reviewer sees it: the message as it is now
+// with the timeline around it, and the report's audit trail. Context for a
+// thread reply is taken around its root.
+type ReportDetail struct {
+	Report     *Report            `json:"report"`
+	Message    *MessageWithUser   `json:"message"`
+	ThreadRoot *MessageWithUser   `json:"thread_root,omitempty"`
+	Before     []MessageWithUser  `json:"before"`
+	After      []MessageWithUser  `json:"after"`
+	Audit      []ReportAuditEntry `json:"audit"`
+}
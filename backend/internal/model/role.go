@@ -0,0 +1,74 @@
+package model
+
+// RoomRole is a member's role within a single room
+type RoomRole string
+
+const (
+	RoleOwner     RoomRole = "owner"
+	RoleAdmin     RoomRole = "admin"
+	RoleModerator RoomRole = "moderator"
+	RoleMember    RoomRole = "member"
+	RoleReadOnly  RoomRole = "read_only"
+)
+
+// Permission is an action a role may be allowed to take in a room
+type Permission string
+
+const (
+	PermPost           Permission = "post"
+	PermDeleteMessages Permission = "delete_messages" // delete other users' messages
+	PermKick           Permission = "kick"
+	PermBan            Permission = "ban"
+	PermMute           Permission = "mute"        // mute users and time them out
+	PermManageRoom     Permission = "manage_room" // rename, change description or privacy
+	PermManageRoles    Permission = "manage_roles"
+	PermInvite         Permission = "invite" // invite users and approve join requests
+)
+
+var rolePermissions = map[RoomRole][]Permission{
+	RoleOwner:     {PermPost, PermDeleteMessages, PermKick, PermBan, PermMute, PermManageRoom, PermManageRoles, PermInvite},
+	RoleAdmin:     {PermPost, PermDeleteMessages, PermKick, PermBan, PermMute, PermManageRoom, PermManageRoles, PermInvite},
+	RoleModerator: {PermPost, PermDeleteMessages, PermKick, PermBan, PermMute},
+	RoleMember:    {PermPost},
+	RoleReadOnly:  {},
+}
+
+var roleRanks = map[RoomRole]int{
+	RoleOwner:     4,
+	RoleAdmin:     3,
+	RoleModerator: 2,
+	RoleMember:    1,
+	RoleReadOnly:  0,
+}
+
+// Valid reports whether r is a known role
+func (r RoomRole) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Can reports whether the role grants the permission
+func (r RoomRole) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Outranks reports whether r sits strictly above other in the hierarchy
+func (r RoomRole) Outranks(other RoomRole) bool {
+	return roleRanks[r] > roleRanks[other]
+}
+
+type UpdateRoleRequest struct {
+	Role RoomRole `json:"role" validate:"required"`
+}
+
+type RoleChangedPayload struct {
+	RoomID    string   `json:"room_id"`
+	UserID    string   `json:"user_id"`
+	Role      RoomRole `json:"role"`
+	ChangedBy string   `json:"changed_by"`
+}
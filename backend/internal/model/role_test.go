@@ -0,0 +1,74 @@
+package model
+
+import "testing"
+
+func TestRoomRoleValid(t *testing.T) {
+	tests := []struct {
+		role RoomRole
+		want bool
+	}{
+		{RoleOwner, true},
+		{RoleAdmin, true},
+		{RoleModerator, true},
+		{RoleMember, true},
+		{RoleReadOnly, true},
+		{"", false},
+		{"superuser", false},
+		{"Owner", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Valid(); got != tt.want {
+			t.Errorf("RoomRole(%q).Valid() = %v, want %v", tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestRoomRoleCan(t *testing.T) {
+	tests := []struct {
+		role RoomRole
+		perm Permission
+		want bool
+	}{
+		{RoleOwner, PermManageRoles, true},
+		{RoleAdmin, PermManageRoles, true},
+		{RoleAdmin, PermInvite, true},
+		{RoleModerator, PermBan, true},
+		{RoleModerator, PermDeleteMessages, true},
+		{RoleModerator, PermManageRoom, false},
+		{RoleModerator, PermManageRoles, false},
+		{RoleMember, PermPost, true},
+		{RoleMember, PermDeleteMessages, false},
+		{RoleMember, PermInvite, false},
+		{RoleReadOnly, PermPost, false},
+		{"", PermPost, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Can(tt.perm); got != tt.want {
+			t.Errorf("RoomRole(%q).Can(%q) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestRoomRoleOutranks(t *testing.T) {
+	tests := []struct {
+		role, other RoomRole
+		want        bool
+	}{
+		{RoleOwner, RoleAdmin, true},
+		{RoleAdmin, RoleModerator, true},
+		{RoleModerator, RoleMember, true},
+		{RoleMember, RoleReadOnly, true},
+		{RoleOwner, RoleReadOnly, true},
+		{RoleAdmin, RoleAdmin, false},
+		{RoleMember, RoleModerator, false},
+		{RoleReadOnly, RoleOwner, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Outranks(tt.other); got != tt.want {
+			t.Errorf("%q.Outranks(%q) = %v, want %v", tt.role, tt.other, got, tt.want)
+		}
+	}
+}
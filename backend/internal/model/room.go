@@ -6,22 +6,42 @@ import (
 	"github.com/google/uuid"
 )
 
+// RoomKind tells listed chat rooms apart from direct conversations
+type RoomKind string
+
+const (
+	RoomKindRoom  RoomKind = "room"
+	RoomKindDM    RoomKind = "dm"
+	RoomKindGroup RoomKind = "group"
+)
+
 type Room struct {
 	ID          uuid.UUID  `json:"id"`
 	Name        string     `json:"name"`
 	Description *string    `json:"description,omitempty"`
 	IsPrivate   bool       `json:"is_private"`
+	Kind        RoomKind   `json:"kind"`
 	CreatedBy   *uuid.UUID `json:"created_by,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
+
+	// SlowModeSeconds is the minimum gap between one member's messages
+	SlowModeSeconds int `json:"slow_mode_seconds"`
 }
 
 type RoomMember struct {
 	RoomID     uuid.UUID `json:"room_id"`
 	UserID     uuid.UUID `json:"user_id"`
+	Role       RoomRole  `json:"role"`
 	JoinedAt   time.Time `json:"joined_at"`
 	LastReadAt time.Time `json:"last_read_at"`
 }
 
+type MemberWithUser struct {
+	User
+	Role     RoomRole  `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
 type RoomWithMembers struct {
 	Room
 	MemberCount int `json:"member_count"`
@@ -35,6 +55,76 @@ type CreateRoomRequest struct {
 	IsPrivate   bool   `json:"is_private"`
 }
 
-type JoinRoomRequest struct {
+type RoomInvitation struct {
+	RoomID    uuid.UUID  `json:"room_id"`
+	RoomName  string     `json:"room_name"`
+	UserID    uuid.UUID  `json:"user_id"`
+	InvitedBy *uuid.UUID `json:"invited_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type RoomJoinRequest struct {
+	RoomID      uuid.UUID `json:"room_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   *string   `json:"avatar_url,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type UpdateRoomRequest struct {
+	Name        *string `json:"name,omitempty" validate:"omitempty,min=1,max=100"`
+	Description *string `json:"description,omitempty"`
+	IsPrivate   *bool   `json:"is_private,omitempty"`
+
+	// SlowModeSeconds sets slow mode; 0 turns it off
+	SlowModeSeconds *int `json:"slow_mode_seconds,omitempty"`
+}
+
+type InviteRequest struct {
 	UserID string `json:"user_id" validate:"required,uuid"`
 }
+
+// DMConversation is a direct message room as seen from one participant's
+// inbox
+type DMConversation struct {
+	Room           Room      `json:"room"`
+	Peer           User      `json:"peer"`
+	LastMessage    *Message  `json:"last_message,omitempty"`
+	LastActivityAt time.Time `json:"last_activity_at"`
+	UnreadCount    int       `json:"unread_count"`
+}
+
+// GroupConversation is a group DM as seen from one member's inbox
+type GroupConversation struct {
+	Room           Room      `json:"room"`
+	MemberCount    int       `json:"member_count"`
+	LastMessage    *Message  `json:"last_message,omitempty"`
+	LastActivityAt time.Time `json:"last_activity_at"`
+	UnreadCount    int       `json:"unread_count"`
+}
+
+type CreateGroupRequest struct {
+	Name    string      `json:"name,omitempty"`
+	UserIDs []uuid.UUID `json:"user_ids"`
+}
+
+type AddMembersRequest struct {
+	UserIDs []uuid.UUID `json:"user_ids"`
+}
+
+type MembershipAction string
+
+const (
+	MembershipAdded   MembershipAction = "added"
+	MembershipRemoved MembershipAction = "removed"
+	MembershipLeft    MembershipAction = "left"
+)
+
+// MembersChangedPayload tells a room who joined or left it and who did it
+type MembersChangedPayload struct {
+	RoomID  uuid.UUID        `json:"room_id"`
+	Action  MembershipAction `json:"action"`
+	ActorID uuid.UUID        `json:"actor_id"`
+	Users   []User           `json:"users"`
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SearchParams are the filters accepted by GET /api/search
+type SearchParams struct {
+	Query       string
+	RoomID      *uuid.UUID
+	UserID      *uuid.UUID
+	From        *time.Time
+	To          *time.Time
+	MessageType *MessageType
+	Limit       int
+	Offset      int
+}
+
+// HighlightRange marks a match in the content, as rune offsets [Start, End)
+type HighlightRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type SearchResult struct {
+	MessageWithUser
+	RoomName   string           `json:"room_name"`
+	Rank       float64          `json:"rank"`
+	Highlights []HighlightRange `json:"highlights"`
+}
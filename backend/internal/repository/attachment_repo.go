@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/pkg/database"
+)
+
+type AttachmentRepository struct {
+	db *database.Postgres
+}
+
+func NewAttachmentRepository(db *database.Postgres) *AttachmentRepository {
+	return &AttachmentRepository{db: db}
+}
+
+// GetByID returns an attachment and when its message was deleted, if it was
+func (r *AttachmentRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.Attachment, *time.Time, error) {
+	query := `
+		SELECT a.id, a.message_id, a.room_id, a.user_id, a.file_name, a.content_type, a.size_bytes,
+			   a.storage_key, a.thumbnail_key, a.width, a.height, a.created_at, m.deleted_at
+		FROM attachments a
+		INNER JOIN messages m ON m.id = a.message_id
+		WHERE a.id = $1
+	`
+
+	att := &model.Attachment{}
+	var deletedAt *time.Time
+	err := r.db.Pool.QueryRow(ctx, query, id).Scan(
+		&att.ID, &att.MessageID, &att.RoomID, &att.UserID, &att.FileName, &att.ContentType, &att.SizeBytes,
+		&att.StorageKey, &att.ThumbnailKey, &att.Width, &att.Height, &att.CreatedAt, &deletedAt,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	att.URL = model.AttachmentURL(att.ID)
+	if att.ThumbnailKey != nil {
+		url := model.AttachmentThumbnailURL(att.ID)
+		att.ThumbnailURL = &url
+	}
+
+	return att, deletedAt, nil
+}
@@ -0,0 +1,368 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/pkg/database"
+	redisclient "github.com/khonE3/chat-backend/pkg/redis"
+	"github.com/redis/go-redis/v9"
+)
+
+// automodChangedChannel carries the ID of a rule that was saved or deleted,
+// so every instance reloads
+const automodChangedChannel = "chat:automod:changed"
+
+var (
+	ErrHeldNotPending = errors.New("held message already reviewed")
+	ErrRuleNotFound   = errors.New("automod rule not found")
+	ErrTooManyRules   = errors.New("too many automod rules")
+)
+
+// AutomodRepository keeps automod rules and held messages in PostgreSQL.
+// Rule changes are announced over Redis so every instance reloads at once.
+type AutomodRepository struct {
+	db    *database.Postgres
+	redis *redisclient.Redis
+}
+
+func NewAutomodRepository(db *database.Postgres, redis *redisclient.Redis) *AutomodRepository {
+	return &AutomodRepository{db: db, redis: redis}
+}
+
+func automodRepeatKey(roomID, userID uuid.UUID, digest string) string {
+	return fmt.Sprintf("chat:automod:repeat:%s:%s:%s", roomID, userID, digest)
+}
+
+const ruleColumns = `
+	id, room_id, name, kind, action, enabled, mute_seconds, words, pattern, link_mode, domains,
+	min_letters, max_caps_ratio, max_mentions, max_run_length, max_lines, max_repeats, window_seconds,
+	created_by, created_at, updated_at
+`
+
+func scanRules(rows pgx.Rows) ([]model.AutomodRule, error) {
+	defer rows.Close()
+
+	rules := []model.AutomodRule{}
+	for rows.Next() {
+		var rule model.AutomodRule
+		err := rows.Scan(
+			&rule.ID, &rule.RoomID, &rule.Name, &rule.Kind, &rule.Action, &rule.Enabled, &rule.MuteSeconds,
+			&rule.Words, &rule.Pattern, &rule.LinkMode, &rule.Domains,
+			&rule.MinLetters, &rule.MaxCapsRatio, &rule.MaxMentions, &rule.MaxRunLength, &rule.MaxLines,
+			&rule.MaxRepeats, &rule.WindowSeconds,
+			&rule.CreatedBy, &rule.CreatedAt, &rule.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// ListRules returns every rule, global and per room
+func (r *AutomodRepository) ListRules(ctx context.Context) ([]model.AutomodRule, error) {
+	rows, err := r.db.Pool.Query(ctx, `SELECT `+ruleColumns+` FROM automod_rules ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	return scanRules(rows)
+}
+
+// ListScopeRules returns the rules of a room, or the global rules when
+// roomID is nil
+func (r *AutomodRepository) ListScopeRules(ctx context.Context, roomID *uuid.UUID) ([]model.AutomodRule, error) {
+	query := `
+		SELECT ` + ruleColumns + `
+		FROM automod_rules
+		WHERE room_id IS NOT DISTINCT FROM $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRules(rows)
+}
+
+// GetRule returns one rule, or ErrRuleNotFound
+func (r *AutomodRepository) GetRule(ctx context.Context, id uuid.UUID) (*model.AutomodRule, error) {
+	rows, err := r.db.Pool.Query(ctx, `SELECT `+ruleColumns+` FROM automod_rules WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := scanRules(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, ErrRuleNotFound
+	}
+	return &rules[0], nil
+}
+
+// CreateRule stores a new rule and tells every instance about it. The scope
+// is locked while its rules are counted, so concurrent creates can't take it
+// past maxPerScope; if they would, ErrTooManyRules is returned.
+func (r *AutomodRepository) CreateRule(ctx context.Context, rule *model.AutomodRule, maxPerScope int) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Global rules have no row to lock, so both scopes take an advisory lock
+	lock := `SELECT pg_advisory_xact_lock(hashtextextended('automod_rules:' || COALESCE($1::text, 'global'), 0))`
+	if _, err := tx.Exec(ctx, lock, rule.RoomID); err != nil {
+		return err
+	}
+
+	var count int
+	err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM automod_rules WHERE room_id IS NOT DISTINCT FROM $1`, rule.RoomID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count >= maxPerScope {
+		return ErrTooManyRules
+	}
+
+	query := `
+		INSERT INTO automod_rules (` + ruleColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+	`
+	_, err = tx.Exec(ctx, query,
+		rule.ID, rule.RoomID, rule.Name, rule.Kind, rule.Action, rule.Enabled, rule.MuteSeconds,
+		nonNil(rule.Words), rule.Pattern, rule.LinkMode, nonNil(rule.Domains),
+		rule.MinLetters, rule.MaxCapsRatio, rule.MaxMentions, rule.MaxRunLength, rule.MaxLines,
+		rule.MaxRepeats, rule.WindowSeconds,
+		rule.CreatedBy, rule.CreatedAt, rule.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	r.publishChange(ctx, rule.ID)
+	return nil
+}
+
+// UpdateRule replaces the settings of a rule, keeping its scope and author,
+// and tells every instance about it. It returns ErrRuleNotFound when the
+// rule is gone.
+func (r *AutomodRepository) UpdateRule(ctx context.Context, rule *model.AutomodRule) error {
+	query := `
+		UPDATE automod_rules SET
+			name = $2, kind = $3, action = $4, enabled = $5, mute_seconds = $6, words = $7, pattern = $8,
+			link_mode = $9, domains = $10, min_letters = $11, max_caps_ratio = $12, max_mentions = $13,
+			max_run_length = $14, max_lines = $15, max_repeats = $16, window_seconds = $17, updated_at = $18
+		WHERE id = $1
+	`
+	tag, err := r.db.Pool.Exec(ctx, query,
+		rule.ID, rule.Name, rule.Kind, rule.Action, rule.Enabled, rule.MuteSeconds,
+		nonNil(rule.Words), rule.Pattern, rule.LinkMode, nonNil(rule.Domains),
+		rule.MinLetters, rule.MaxCapsRatio, rule.MaxMentions, rule.MaxRunLength, rule.MaxLines,
+		rule.MaxRepeats, rule.WindowSeconds, rule.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRuleNotFound
+	}
+
+	r.publishChange(ctx, rule.ID)
+	return nil
+}
+
+// DeleteRule removes a rule, reporting whether it existed
+func (r *AutomodRepository) DeleteRule(ctx context.Context, id uuid.UUID) (bool, error) {
+	tag, err := r.db.Pool.Exec(ctx, `DELETE FROM automod_rules WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	r.publishChange(ctx, id)
+	return true, nil
+}
+
+// publishChange tells every instance to reload. The change is already
+// committed, so a failed publish is only logged; the periodic reload picks
+// it up.
+func (r *AutomodRepository) publishChange(ctx context.Context, id uuid.UUID) {
+	if err := r.redis.Client.Publish(ctx, automodChangedChannel, id.String()).Err(); err != nil {
+		log.Printf("⚠️ Failed to announce automod rule change %s: %v", id, err)
+	}
+}
+
+// SubscribeChanges subscribes to rule changes made on any instance
+func (r *AutomodRepository) SubscribeChanges(ctx context.Context) *redis.PubSub {
+	return r.redis.Client.Subscribe(ctx, automodChangedChannel)
+}
+
+// nonNil stores an unset list as an empty array rather than NULL
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// CountRepeat counts a message with the given digest from the user in the
+// room, returning how many were sent within window including this one
+func (r *AutomodRepository) CountRepeat(ctx context.Context, roomID, userID uuid.UUID, digest string, window time.Duration) (int64, error) {
+	key := automodRepeatKey(roomID, userID, digest)
+
+	pipe := r.redis.Client.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// Hold stores a message kept back for review
+func (r *AutomodRepository) Hold(ctx context.Context, held *model.HeldMessage) error {
+	query := `
+		INSERT INTO held_messages (id, room_id, user_id, content, reply_to_id, client_nonce, rule_id, rule_name, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	_, err := r.db.Pool.Exec(ctx, query,
+		held.ID, held.RoomID, held.UserID, held.Content, held.ReplyToID, held.Nonce,
+		held.RuleID, held.RuleName, held.Status, held.CreatedAt,
+	)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "idx_held_messages_user_nonce" {
+		return ErrDuplicateNonce
+	}
+	return err
+}
+
+// GetHeldByNonce returns the message userID sent with nonce that was held,
+// or pgx.ErrNoRows
+func (r *AutomodRepository) GetHeldByNonce(ctx context.Context, userID uuid.UUID, nonce string) (*model.HeldMessage, error) {
+	query := `
+		SELECT ` + heldColumns + `
+		FROM held_messages h
+		INNER JOIN users u ON u.id = h.user_id
+		WHERE h.user_id = $1 AND h.client_nonce = $2
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, userID, nonce)
+	if err != nil {
+		return nil, err
+	}
+	held, err := scanHeld(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(held) == 0 {
+		return nil, pgx.ErrNoRows
+	}
+	return &held[0], nil
+}
+
+// GetHeld returns a held message with its sender
+func (r *AutomodRepository) GetHeld(ctx context.Context, id uuid.UUID) (*model.HeldMessage, error) {
+	query := `
+		SELECT ` + heldColumns + `
+		FROM held_messages h
+		INNER JOIN users u ON u.id = h.user_id
+		WHERE h.id = $1
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	held, err := scanHeld(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(held) == 0 {
+		return nil, pgx.ErrNoRows
+	}
+	return &held[0], nil
+}
+
+// ListPending returns the messages awaiting review in a room, oldest first
+func (r *AutomodRepository) ListPending(ctx context.Context, roomID uuid.UUID, limit, offset int) ([]model.HeldMessage, error) {
+	query := `
+		SELECT ` + heldColumns + `
+		FROM held_messages h
+		INNER JOIN users u ON u.id = h.user_id
+		WHERE h.room_id = $1 AND h.status = 'pending'
+		ORDER BY h.created_at ASC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, roomID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanHeld(rows)
+}
+
+// Review settles a pending held message. It fails with ErrHeldNotPending
+// when another moderator got there first.
+func (r *AutomodRepository) Review(ctx context.Context, id, reviewerID uuid.UUID, status model.HeldStatus) error {
+	query := `
+		UPDATE held_messages SET status = $3, reviewed_by = $2, reviewed_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`
+
+	tag, err := r.db.Pool.Exec(ctx, query, id, reviewerID, status)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrHeldNotPending
+	}
+	return nil
+}
+
+// Reopen puts a held message back in the queue, for when posting it failed
+// after it was approved
+func (r *AutomodRepository) Reopen(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE held_messages SET status = 'pending', reviewed_by = NULL, reviewed_at = NULL WHERE id = $1`
+	_, err := r.db.Pool.Exec(ctx, query, id)
+	return err
+}
+
+const heldColumns = `
+	h.id, h.room_id, h.user_id, u.username, u.display_name, h.content, h.reply_to_id, h.client_nonce,
+	h.rule_id, h.rule_name, h.status, h.created_at, h.reviewed_by, h.reviewed_at
+`
+
+func scanHeld(rows pgx.Rows) ([]model.HeldMessage, error) {
+	defer rows.Close()
+
+	held := []model.HeldMessage{}
+	for rows.Next() {
+		var h model.HeldMessage
+		err := rows.Scan(
+			&h.ID, &h.RoomID, &h.UserID, &h.Username, &h.DisplayName, &h.Content, &h.ReplyToID, &h.Nonce,
+			&h.RuleID, &h.RuleName, &h.Status, &h.CreatedAt, &h.ReviewedBy, &h.ReviewedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		held = append(held, h)
+	}
+	return held, rows.Err()
+}
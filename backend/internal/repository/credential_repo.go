@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/pkg/database"
+)
+
+var (
+	ErrUsernameTaken      = errors.New("username already taken")
+	ErrCredentialNotFound = errors.New("credential not found")
+)
+
+type CredentialRepository struct {
+	db *database.Postgres
+}
+
+func NewCredentialRepository(db *database.Postgres) *CredentialRepository {
+	return &CredentialRepository{db: db}
+}
+
+// Register creates a user together with its password hash in one transaction
+func (r *CredentialRepository) Register(ctx context.Context, req *model.CreateUserRequest, passwordHash string) (*model.User, error) {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	user := &model.User{
+		ID:          uuid.New(),
+		Username:    req.Username,
+		DisplayName: req.DisplayName,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if req.AvatarURL != "" {
+		user.AvatarURL = &req.AvatarURL
+	}
+
+	userQuery := `
+		INSERT INTO users (id, username, display_name, avatar_url, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err = tx.Exec(ctx, userQuery,
+		user.ID, user.Username, user.DisplayName, user.AvatarURL, user.CreatedAt, user.UpdatedAt,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
+	}
+
+	credQuery := `
+		INSERT INTO user_credentials (user_id, password_hash, created_at, updated_at)
+		VALUES ($1, $2, $3, $3)
+	`
+	if _, err := tx.Exec(ctx, credQuery, user.ID, passwordHash, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *CredentialRepository) GetByUserID(ctx context.Context, userID uuid.UUID) (*model.UserCredential, error) {
+	cred := &model.UserCredential{}
+
+	query := `
+		SELECT user_id, password_hash, failed_attempts, locked_until, created_at, updated_at
+		FROM user_credentials WHERE user_id = $1
+	`
+
+	err := r.db.Pool.QueryRow(ctx, query, userID).Scan(
+		&cred.UserID, &cred.PasswordHash, &cred.FailedAttempts, &cred.LockedUntil, &cred.CreatedAt, &cred.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCredentialNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return cred, nil
+}
+
+// HasCredential reports whether the user has set a password
+func (r *CredentialRepository) HasCredential(ctx context.Context, userID uuid.UUID) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM user_credentials WHERE user_id = $1)`
+
+	var exists bool
+	err := r.db.Pool.QueryRow(ctx, query, userID).Scan(&exists)
+	return exists, err
+}
+
+func (r *CredentialRepository) UpdatePassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	query := `
+		UPDATE user_credentials
+		SET password_hash = $2, failed_attempts = 0, locked_until = NULL, updated_at = $3
+		WHERE user_id = $1
+	`
+	_, err := r.db.Pool.Exec(ctx, query, userID, passwordHash, time.Now())
+	return err
+}
+
+// RecordFailure counts a failed login and locks the account once maxAttempts
+// is reached. The counter restarts after each lockout.
+func (r *CredentialRepository) RecordFailure(ctx context.Context, userID uuid.UUID, maxAttempts int, lockout time.Duration) (*time.Time, error) {
+	query := `
+		UPDATE user_credentials
+		SET failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END,
+			locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN $3 ELSE locked_until END,
+			updated_at = NOW()
+		WHERE user_id = $1
+		RETURNING locked_until
+	`
+
+	var lockedUntil *time.Time
+	err := r.db.Pool.QueryRow(ctx, query, userID, maxAttempts, time.Now().Add(lockout)).Scan(&lockedUntil)
+	return lockedUntil, err
+}
+
+// ResetFailures clears the failed attempt counter after a successful login
+func (r *CredentialRepository) ResetFailures(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		UPDATE user_credentials SET failed_attempts = 0, locked_until = NULL
+		WHERE user_id = $1 AND (failed_attempts > 0 OR locked_until IS NOT NULL)
+	`
+	_, err := r.db.Pool.Exec(ctx, query, userID)
+	return err
+}
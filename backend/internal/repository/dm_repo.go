@@ -0,0 +1,350 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/pkg/database"
+)
+
+var ErrGroupFull = errors.New("group conversation is full")
+
+// dmNamespace seeds the name-based UUIDs of direct message rooms
+var dmNamespace = uuid.MustParse("6f1c2d7e-4b8a-5c3e-9d21-0a7b3c4d5e6f")
+
+// inboxColumns and inboxJoins add the last message, last activity and
+// unread count of room r for member me. Unread counts leave out the member's
+// own messages, thread replies and deleted messages, like GetUnreadCount.
+const inboxColumns = `
+	lm.id, lm.user_id, lm.content, COALESCE(lm.message_type, 'text'), lm.created_at, lm.deleted_at,
+	COALESCE(lm.created_at, r.created_at) AS last_activity_at,
+	(SELECT COUNT(*) FROM messages m
+	 WHERE m.room_id = r.id AND m.thread_root_id IS NULL AND m.deleted_at IS NULL
+	   AND m.created_at > me.last_read_at
+	   AND m.user_id IS DISTINCT FROM me.user_id) AS unread_count
+`
+
+const inboxJoins = `
+	LEFT JOIN LATERAL (
+		SELECT id, user_id, content, message_type, created_at, deleted_at
+		FROM messages
+		WHERE room_id = r.id AND thread_root_id IS NULL
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	) lm ON true
+`
+
+// lastMessage scans the nullable last message columns of inboxColumns
+type lastMessage struct {
+	id          *uuid.UUID
+	userID      *uuid.UUID
+	content     *string
+	messageType *model.MessageType
+	createdAt   *time.Time
+	deletedAt   *time.Time
+}
+
+func (l *lastMessage) message(roomID uuid.UUID) *model.Message {
+	if l.id == nil {
+		return nil
+	}
+	return &model.Message{
+		ID:          *l.id,
+		RoomID:      roomID,
+		UserID:      l.userID,
+		Content:     *l.content,
+		MessageType: *l.messageType,
+		CreatedAt:   *l.createdAt,
+		DeletedAt:   l.deletedAt,
+	}
+}
+
+type DMRepository struct {
+	db *database.Postgres
+}
+
+func NewDMRepository(db *database.Postgres) *DMRepository {
+	return &DMRepository{db: db}
+}
+
+// DMRoomID returns the room ID for the conversation between two users. It is
+// the same whichever way round the users are given, so concurrent opens of
+// the same conversation converge on one room.
+func DMRoomID(a, b uuid.UUID) uuid.UUID {
+	if a.String() > b.String() {
+		a, b = b, a
+	}
+	return uuid.NewSHA1(dmNamespace, []byte(a.String()+":"+b.String()))
+}
+
+// GetOrCreate returns the DM room between two users, creating it with both
+// as members on first use. created reports whether it was new.
+func (r *DMRepository) GetOrCreate(ctx context.Context, userID, peerID uuid.UUID) (*model.Room, bool, error) {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	roomID := DMRoomID(userID, peerID)
+	now := time.Now()
+
+	insertRoom := `
+		INSERT INTO rooms (id, name, is_private, kind, created_by, created_at)
+		VALUES ($1, '', true, $2, $3, $4)
+		ON CONFLICT (id) DO NOTHING
+	`
+	tag, err := tx.Exec(ctx, insertRoom, roomID, model.RoomKindDM, userID, now)
+	if err != nil {
+		return nil, false, err
+	}
+	created := tag.RowsAffected() == 1
+
+	// Nobody owns a DM: both sides are plain members
+	insertMembers := `
+		INSERT INTO room_members (room_id, user_id, role, joined_at, last_read_at)
+		VALUES ($1, $2, $4, $5, $5), ($1, $3, $4, $5, $5)
+		ON CONFLICT (room_id, user_id) DO NOTHING
+	`
+	if _, err := tx.Exec(ctx, insertMembers, roomID, userID, peerID, model.RoleMember, now); err != nil {
+		return nil, false, err
+	}
+
+	room := &model.Room{}
+	query := `
+		SELECT id, name, description, is_private, kind, created_by, created_at
+		FROM rooms WHERE id = $1
+	`
+	err = tx.QueryRow(ctx, query, roomID).Scan(
+		&room.ID, &room.Name, &room.Description, &room.IsPrivate, &room.Kind, &room.CreatedBy, &room.CreatedAt,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+
+	return room, created, nil
+}
+
+// ListForUser returns the user's DM inbox, most recently active first
+func (r *DMRepository) ListForUser(ctx context.Context, userID uuid.UUID) ([]model.DMConversation, error) {
+	query := `
+		SELECT r.id, r.name, r.description, r.is_private, r.kind, r.created_by, r.created_at,
+			   u.id, u.username, u.display_name, u.avatar_url, u.created_at, u.updated_at,
+			   ` + inboxColumns + `
+		FROM room_members me
+		INNER JOIN rooms r ON r.id = me.room_id AND r.kind = $2
+		INNER JOIN room_members pm ON pm.room_id = r.id AND pm.user_id <> me.user_id
+		INNER JOIN users u ON u.id = pm.user_id
+		` + inboxJoins + `
+		WHERE me.user_id = $1
+		ORDER BY last_activity_at DESC
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, userID, model.RoomKindDM)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []model.DMConversation{}
+	for rows.Next() {
+		var (
+			dm   model.DMConversation
+			last lastMessage
+		)
+
+		err := rows.Scan(
+			&dm.Room.ID, &dm.Room.Name, &dm.Room.Description, &dm.Room.IsPrivate, &dm.Room.Kind,
+			&dm.Room.CreatedBy, &dm.Room.CreatedAt,
+			&dm.Peer.ID, &dm.Peer.Username, &dm.Peer.DisplayName, &dm.Peer.AvatarURL,
+			&dm.Peer.CreatedAt, &dm.Peer.UpdatedAt,
+			&last.id, &last.userID, &last.content, &last.messageType, &last.createdAt, &last.deletedAt,
+			&dm.LastActivityAt, &dm.UnreadCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		dm.LastMessage = last.message(dm.Room.ID)
+		conversations = append(conversations, dm)
+	}
+
+	return conversations, rows.Err()
+}
+
+// CreateGroup creates a group DM with the creator and members
+func (r *DMRepository) CreateGroup(ctx context.Context, name string, creatorID uuid.UUID, memberIDs []uuid.UUID) (*model.Room, error) {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	room := &model.Room{
+		ID:        uuid.New(),
+		Name:      name,
+		IsPrivate: true,
+		Kind:      model.RoomKindGroup,
+		CreatedBy: &creatorID,
+		CreatedAt: time.Now(),
+	}
+
+	query := `
+		INSERT INTO rooms (id, name, is_private, kind, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	if _, err := tx.Exec(ctx, query, room.ID, room.Name, room.IsPrivate, room.Kind, room.CreatedBy, room.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := addGroupMembers(ctx, tx, room.ID, creatorID, append([]uuid.UUID{creatorID}, memberIDs...)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// AddGroupMembers adds users to a group DM on behalf of addedBy, returning
+// those who weren't members already. The room row is locked while members
+// are counted, so concurrent adds can't take the group past maxSize; if they
+// would, nothing is added and ErrGroupFull is returned.
+func (r *DMRepository) AddGroupMembers(ctx context.Context, roomID, addedBy uuid.UUID, userIDs []uuid.UUID, maxSize int) ([]uuid.UUID, error) {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM rooms WHERE id = $1 FOR UPDATE`, roomID); err != nil {
+		return nil, err
+	}
+
+	insert := `
+		INSERT INTO room_members (room_id, user_id, role, joined_at, last_read_at, added_by)
+		SELECT $1, u, $3, $4, $4, $5 FROM UNNEST($2::uuid[]) AS u
+		ON CONFLICT (room_id, user_id) DO NOTHING
+		RETURNING user_id
+	`
+	rows, err := tx.Query(ctx, insert, roomID, userIDs, model.RoleMember, time.Now(), addedBy)
+	if err != nil {
+		return nil, err
+	}
+	added, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM room_members WHERE room_id = $1`, roomID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count > maxSize {
+		return nil, ErrGroupFull
+	}
+
+	return added, tx.Commit(ctx)
+}
+
+// execer is satisfied by both the pool and a transaction
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+func addGroupMembers(ctx context.Context, db execer, roomID, addedBy uuid.UUID, userIDs []uuid.UUID) error {
+	query := `
+		INSERT INTO room_members (room_id, user_id, role, joined_at, last_read_at, added_by)
+		SELECT $1, u, $3, $4, $4, $5 FROM UNNEST($2::uuid[]) AS u
+		ON CONFLICT (room_id, user_id) DO NOTHING
+	`
+	_, err := db.Exec(ctx, query, roomID, userIDs, model.RoleMember, time.Now(), addedBy)
+	return err
+}
+
+// RemoveGroupMember removes a user from a group DM, or returns ErrNotMember
+func (r *DMRepository) RemoveGroupMember(ctx context.Context, roomID, userID uuid.UUID) error {
+	query := `DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`
+
+	tag, err := r.db.Pool.Exec(ctx, query, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// GroupMemberIDs returns the members of a room
+func (r *DMRepository) GroupMemberIDs(ctx context.Context, roomID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.db.Pool.Query(ctx, `SELECT user_id FROM room_members WHERE room_id = $1`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ListGroupsForUser returns the user's group DMs, most recently active first
+func (r *DMRepository) ListGroupsForUser(ctx context.Context, userID uuid.UUID) ([]model.GroupConversation, error) {
+	query := `
+		SELECT r.id, r.name, r.description, r.is_private, r.kind, r.created_by, r.created_at,
+			   (SELECT COUNT(*) FROM room_members c WHERE c.room_id = r.id) AS member_count,
+			   ` + inboxColumns + `
+		FROM room_members me
+		INNER JOIN rooms r ON r.id = me.room_id AND r.kind = $2
+		` + inboxJoins + `
+		WHERE me.user_id = $1
+		ORDER BY last_activity_at DESC
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, userID, model.RoomKindGroup)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []model.GroupConversation{}
+	for rows.Next() {
+		var (
+			g    model.GroupConversation
+			last lastMessage
+		)
+
+		err := rows.Scan(
+			&g.Room.ID, &g.Room.Name, &g.Room.Description, &g.Room.IsPrivate, &g.Room.Kind,
+			&g.Room.CreatedBy, &g.Room.CreatedAt, &g.MemberCount,
+			&last.id, &last.userID, &last.content, &last.messageType, &last.createdAt, &last.deletedAt,
+			&g.LastActivityAt, &g.UnreadCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		g.LastMessage = last.message(g.Room.ID)
+		groups = append(groups, g)
+	}
+
+	return groups, rows.Err()
+}
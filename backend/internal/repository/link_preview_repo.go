@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/khonE3/chat-backend/internal/model"
+	redisclient "github.com/khonE3/chat-backend/pkg/redis"
+	"github.com/redis/go-redis/v9"
+)
+
+// LinkPreviewRepository caches unfurled pages by URL so a link pasted into
+// many rooms is fetched once
+type LinkPreviewRepository struct {
+	redis *redisclient.Redis
+}
+
+func NewLinkPreviewRepository(redis *redisclient.Redis) *LinkPreviewRepository {
+	return &LinkPreviewRepository{redis: redis}
+}
+
+func linkPreviewKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return "chat:unfurl:" + hex.EncodeToString(sum[:])
+}
+
+// Get returns the cached preview for url. found is false on a cache miss;
+// a nil preview with found set means the page had no preview.
+func (r *LinkPreviewRepository) Get(ctx context.Context, url string) (*model.LinkPreview, bool, error) {
+	data, err := r.redis.Client.Get(ctx, linkPreviewKey(url)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) == 0 {
+		return nil, true, nil
+	}
+
+	var preview model.LinkPreview
+	if err := json.Unmarshal(data, &preview); err != nil {
+		return nil, false, err
+	}
+	return &preview, true, nil
+}
+
+// Set caches a preview, or the absence of one when preview is nil
+func (r *LinkPreviewRepository) Set(ctx context.Context, url string, preview *model.LinkPreview, ttl time.Duration) error {
+	var data []byte
+	if preview != nil {
+		var err error
+		if data, err = json.Marshal(preview); err != nil {
+			return err
+		}
+	}
+	return r.redis.Client.Set(ctx, linkPreviewKey(url), data, ttl).Err()
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/pkg/database"
+)
+
+type MentionRepository struct {
+	db *database.Postgres
+}
+
+func NewMentionRepository(db *database.Postgres) *MentionRepository {
+	return &MentionRepository{db: db}
+}
+
+// Add records mentions of room members in msg and returns who was newly
+// mentioned. Members are picked by lower-cased username, by user ID, or
+// all of them when both filters are nil. The author is never included,
+// and a member already mentioned in the message keeps their first kind.
+func (r *MentionRepository) Add(ctx context.Context, msg *model.Message, kind model.MentionKind, usernames []string, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	query := `
+		INSERT INTO mentions (message_id, room_id, user_id, mentioned_by, kind, created_at)
+		SELECT $1, $2, rm.user_id, $3, $4, $5
+		FROM room_members rm
+		INNER JOIN users u ON u.id = rm.user_id
+		WHERE rm.room_id = $2 AND rm.user_id <> $3
+		  AND ($6::text[] IS NULL OR LOWER(u.username) = ANY($6))
+		  AND ($7::uuid[] IS NULL OR rm.user_id = ANY($7))
+		ON CONFLICT (message_id, user_id) DO NOTHING
+		RETURNING user_id
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, msg.ID, msg.RoomID, msg.UserID, kind, time.Now(), usernames, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mentioned []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		mentioned = append(mentioned, id)
+	}
+
+	return mentioned, rows.Err()
+}
+
+// ListForUser returns the user's mentions, newest first. Mentions in deleted
+// messages and in rooms the user can no longer read are left out.
+func (r *MentionRepository) ListForUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]model.Mention, error) {
+	query := `
+		SELECT ` + messageColumns + `, mn.id, mn.kind, mn.created_at, rm.name
+		FROM mentions mn
+		INNER JOIN messages m ON m.id = mn.message_id
+		INNER JOIN rooms rm ON rm.id = mn.room_id
+		` + messageJoins + `
+		WHERE mn.user_id = $1
+		  AND m.deleted_at IS NULL
+		  AND (rm.is_private = false
+			   OR EXISTS(SELECT 1 FROM room_members mem WHERE mem.room_id = rm.id AND mem.user_id = $1))
+		ORDER BY mn.created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := []model.Mention{}
+	for rows.Next() {
+		var mention model.Mention
+		msg, err := scanMessageWithUser(rows, &mention.ID, &mention.Kind, &mention.CreatedAt, &mention.RoomName)
+		if err != nil {
+			return nil, err
+		}
+		mention.RoomID = msg.RoomID
+		mention.Message = msg
+		mentions = append(mentions, mention)
+	}
+
+	return mentions, rows.Err()
+}
@@ -3,28 +3,141 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/khonE3/chat-backend/internal/model"
 	"github.com/khonE3/chat-backend/pkg/database"
 	redisclient "github.com/khonE3/chat-backend/pkg/redis"
 	"github.com/redis/go-redis/v9"
 )
 
+var ErrDuplicateNonce = errors.New("message nonce already used")
+
 type MessageRepository struct {
 	db    *database.Postgres
 	redis *redisclient.Redis
+
+	// Stream cache counters, see CacheStats
+	streamHits   atomic.Uint64
+	streamMisses atomic.Uint64
 }
 
 func NewMessageRepository(db *database.Postgres, redis *redisclient.Redis) *MessageRepository {
 	return &MessageRepository{db: db, redis: redis}
 }
 
+// messageColumns and messageJoins read a message with its author, quoted
+// message, thread summary, attachments and link previews. Queries refer to the message as m.
+const messageColumns = `
+	m.id, m.room_id, m.user_id, m.content, m.message_type, m.created_at,
+	m.edited_at, m.deleted_at, m.reply_to_id, m.thread_root_id, COALESCE(m.seq, 0), m.client_nonce,
+	COALESCE(u.username, CASE WHEN m.message_type = 'system' THEN 'system' ELSE 'deleted' END) as username,
+	COALESCE(u.display_name, CASE WHEN m.message_type = 'system' THEN 'System' ELSE 'Deleted User' END) as display_name,
+	u.avatar_url,
+	q.id, q.user_id, q.content, q.deleted_at,
+	COALESCE(qu.username, 'deleted'), COALESCE(qu.display_name, 'Deleted User'),
+	t.reply_count, t.last_reply_at,
+	att.attachments, m.link_previews
+`
+
+const messageJoins = `
+	LEFT JOIN users u ON m.user_id = u.id
+	LEFT JOIN messages q ON q.id = m.reply_to_id
+	LEFT JOIN users qu ON qu.id = q.user_id
+	LEFT JOIN LATERAL (
+		SELECT COUNT(*) AS reply_count, MAX(r.created_at) AS last_reply_at
+		FROM messages r
+		WHERE r.thread_root_id = m.id AND r.deleted_at IS NULL
+	) t ON true
+	LEFT JOIN LATERAL (
+		SELECT json_agg(json_build_object(
+			'id', a.id, 'message_id', a.message_id, 'room_id', a.room_id, 'user_id', a.user_id,
+			'file_name', a.file_name, 'content_type', a.content_type, 'size_bytes', a.size_bytes,
+			'width', a.width, 'height', a.height, 'created_at', a.created_at,
+			'url', '/api/attachments/' || a.id,
+			'thumbnail_url', CASE WHEN a.thumbnail_key IS NOT NULL THEN '/api/attachments/' || a.id || '/thumbnail' END
+		) ORDER BY a.created_at) AS attachments
+		FROM attachments a
+		WHERE a.message_id = m.id AND m.deleted_at IS NULL
+	) att ON true
+`
+
+// messageSelect is the common prefix for queries returning MessageWithUser.
+// Callers append WHERE/ORDER BY clauses.
+const messageSelect = `SELECT ` + messageColumns + ` FROM messages m ` + messageJoins
+
+// scanMessageWithUser scans messageColumns, followed by any extra columns
+// the query selected after them
+func scanMessageWithUser(row pgx.Row, extra ...interface{}) (*model.MessageWithUser, error) {
+	msg := &model.MessageWithUser{}
+
+	var (
+		quoteID          *uuid.UUID
+		quoteUserID      *uuid.UUID
+		quoteContent     *string
+		quoteDeletedAt   *time.Time
+		quoteUsername    string
+		quoteDisplayName string
+		attachments      []byte
+		linkPreviews     []byte
+	)
+
+	dest := []interface{}{
+		&msg.ID, &msg.RoomID, &msg.UserID, &msg.Content, &msg.MessageType, &msg.CreatedAt,
+		&msg.EditedAt, &msg.DeletedAt, &msg.ReplyToID, &msg.ThreadRootID, &msg.Seq, &msg.Nonce,
+		&msg.Username, &msg.DisplayName, &msg.AvatarURL,
+		&quoteID, &quoteUserID, &quoteContent, &quoteDeletedAt,
+		&quoteUsername, &quoteDisplayName,
+		&msg.ReplyCount, &msg.LastReplyAt,
+		&attachments, &linkPreviews,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if quoteID != nil {
+		msg.ReplyTo = &model.MessagePreview{
+			ID:          *quoteID,
+			UserID:      quoteUserID,
+			Username:    quoteUsername,
+			DisplayName: quoteDisplayName,
+			Deleted:     quoteDeletedAt != nil,
+		}
+		if quoteContent != nil {
+			msg.ReplyTo.Content = *quoteContent
+		}
+	}
+
+	if attachments != nil {
+		if err := json.Unmarshal(attachments, &msg.Attachments); err != nil {
+			return nil, err
+		}
+	}
+	if linkPreviews != nil {
+		if err := json.Unmarshal(linkPreviews, &msg.LinkPreviews); err != nil {
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
+
 // PostgreSQL operations
 
-func (r *MessageRepository) Create(ctx context.Context, roomID, userID uuid.UUID, content string, msgType model.MessageType) (*model.Message, error) {
+// Create stores a timeline message and appends it, with author info, to the
+// room's recent messages stream
+func (r *MessageRepository) Create(ctx context.Context, roomID, userID uuid.UUID, content string, msgType model.MessageType, nonce *string) (*model.MessageWithUser, error) {
 	msg := &model.Message{
 		ID:          uuid.New(),
 		RoomID:      roomID,
@@ -32,37 +145,158 @@ func (r *MessageRepository) Create(ctx context.Context, roomID, userID uuid.UUID
 		Content:     content,
 		MessageType: msgType,
 		CreatedAt:   time.Now(),
+		Nonce:       nonce,
+	}
+
+	if err := r.insert(ctx, r.db.Pool, msg); err != nil {
+		return nil, err
+	}
+
+	return r.cacheCreated(ctx, msg.ID)
+}
+
+// CreateSystem stores a system message. It has no author, so nobody can
+// edit or delete it as their own.
+func (r *MessageRepository) CreateSystem(ctx context.Context, roomID uuid.UUID, content string) (*model.MessageWithUser, error) {
+	msg := &model.Message{
+		ID:          uuid.New(),
+		RoomID:      roomID,
+		Content:     content,
+		MessageType: model.MessageTypeSystem,
+		CreatedAt:   time.Now(),
+	}
+
+	if err := r.insert(ctx, r.db.Pool, msg); err != nil {
+		return nil, err
+	}
+
+	return r.cacheCreated(ctx, msg.ID)
+}
+
+// CreateWithAttachment stores an attachment message and its attachment row
+// in one transaction, so a message never shows up without its file
+func (r *MessageRepository) CreateWithAttachment(ctx context.Context, userID uuid.UUID, caption string, att *model.Attachment, nonce *string) (*model.MessageWithUser, error) {
+	msg := &model.Message{
+		ID:          att.MessageID,
+		RoomID:      att.RoomID,
+		UserID:      &userID,
+		Content:     caption,
+		MessageType: model.MessageTypeAttachment,
+		CreatedAt:   time.Now(),
+		Nonce:       nonce,
+	}
+
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := r.insert(ctx, tx, msg); err != nil {
+		return nil, err
 	}
 
 	query := `
-		INSERT INTO messages (id, room_id, user_id, content, message_type, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, room_id, user_id, content, message_type, created_at
+		INSERT INTO attachments (id, message_id, room_id, user_id, file_name, content_type, size_bytes, storage_key, thumbnail_key, width, height, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 	`
+	_, err = tx.Exec(ctx, query,
+		att.ID, att.MessageID, att.RoomID, att.UserID, att.FileName, att.ContentType, att.SizeBytes,
+		att.StorageKey, att.ThumbnailKey, att.Width, att.Height, msg.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	err := r.db.Pool.QueryRow(ctx, query,
-		msg.ID, msg.RoomID, msg.UserID, msg.Content, msg.MessageType, msg.CreatedAt,
-	).Scan(&msg.ID, &msg.RoomID, &msg.UserID, &msg.Content, &msg.MessageType, &msg.CreatedAt)
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return r.cacheCreated(ctx, msg.ID)
+}
 
+// cacheCreated reads back a new timeline message and appends it to the
+// room's stream. Appended synchronously so the stream keeps timeline order.
+func (r *MessageRepository) cacheCreated(ctx context.Context, id uuid.UUID) (*model.MessageWithUser, error) {
+	full, err := r.GetByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	// Also add to Redis Stream for recent messages cache
-	go r.addToStream(context.Background(), msg)
+	if err := r.addToStream(ctx, full); err != nil {
+		log.Printf("⚠️ Failed to cache message %s: %v", id, err)
+		r.InvalidateStream(ctx, full.RoomID)
+	}
 
-	return msg, nil
+	return full, nil
 }
 
-func (r *MessageRepository) GetByRoom(ctx context.Context, roomID uuid.UUID, limit, offset int) ([]model.MessageWithUser, error) {
+// CreateReply stores a threaded reply. Replies are not part of the room
+// timeline, so only the thread root's cached summary is refreshed.
+func (r *MessageRepository) CreateReply(ctx context.Context, roomID, userID uuid.UUID, content string, replyToID, threadRootID uuid.UUID, nonce *string) (*model.MessageWithUser, error) {
+	msg := &model.Message{
+		ID:           uuid.New(),
+		RoomID:       roomID,
+		UserID:       &userID,
+		Content:      content,
+		MessageType:  model.MessageTypeText,
+		CreatedAt:    time.Now(),
+		ReplyToID:    &replyToID,
+		ThreadRootID: &threadRootID,
+		Nonce:        nonce,
+	}
+
+	if err := r.insert(ctx, r.db.Pool, msg); err != nil {
+		return nil, err
+	}
+
+	go r.refreshInStream(context.Background(), roomID, threadRootID)
+
+	return r.GetByID(ctx, msg.ID)
+}
+
+// queryer is satisfied by both the pool and a transaction
+type queryer interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// insert stores msg. Timeline messages take the room's next sequence number;
+// the row lock on rooms serialises concurrent inserts into the same room. A
+// reused nonce fails the whole statement, so no sequence number is spent.
+func (r *MessageRepository) insert(ctx context.Context, db queryer, msg *model.Message) error {
 	query := `
-		SELECT m.id, m.room_id, m.user_id, m.content, m.message_type, m.created_at,
-			   COALESCE(u.username, 'deleted') as username,
-			   COALESCE(u.display_name, 'Deleted User') as display_name,
-			   u.avatar_url
-		FROM messages m
-		LEFT JOIN users u ON m.user_id = u.id
-		WHERE m.room_id = $1
+		WITH s AS (
+			UPDATE rooms SET last_seq = last_seq + 1
+			WHERE id = $2 AND $8::uuid IS NULL
+			RETURNING last_seq
+		)
+		INSERT INTO messages (id, room_id, user_id, content, message_type, created_at, reply_to_id, thread_root_id, client_nonce, seq)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, (SELECT last_seq FROM s))
+		RETURNING id, room_id, user_id, content, message_type, created_at, reply_to_id, thread_root_id, COALESCE(seq, 0)
+	`
+
+	err := db.QueryRow(ctx, query,
+		msg.ID, msg.RoomID, msg.UserID, msg.Content, msg.MessageType, msg.CreatedAt, msg.ReplyToID, msg.ThreadRootID, msg.Nonce,
+	).Scan(&msg.ID, &msg.RoomID, &msg.UserID, &msg.Content, &msg.MessageType, &msg.CreatedAt, &msg.ReplyToID, &msg.ThreadRootID, &msg.Seq)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "idx_messages_user_nonce" {
+		return ErrDuplicateNonce
+	}
+	return err
+}
+
+// GetByNonce returns the message userID sent with the given nonce
+func (r *MessageRepository) GetByNonce(ctx context.Context, userID uuid.UUID, nonce string) (*model.MessageWithUser, error) {
+	query := messageSelect + `WHERE m.user_id = $1 AND m.client_nonce = $2`
+	return scanMessageWithUser(r.db.Pool.QueryRow(ctx, query, userID, nonce))
+}
+
+// GetByRoom returns the room timeline (thread replies excluded) by offset.
+// Deprecated: kept for legacy offset pagination, use GetBefore/GetAfter.
+func (r *MessageRepository) GetByRoom(ctx context.Context, roomID uuid.UUID, limit, offset int) ([]model.MessageWithUser, error) {
+	query := messageSelect + `
+		WHERE m.room_id = $1 AND m.thread_root_id IS NULL
 		ORDER BY m.created_at DESC
 		LIMIT $2 OFFSET $3
 	`
@@ -75,15 +309,11 @@ func (r *MessageRepository) GetByRoom(ctx context.Context, roomID uuid.UUID, lim
 
 	var messages []model.MessageWithUser
 	for rows.Next() {
-		var msg model.MessageWithUser
-		err := rows.Scan(
-			&msg.ID, &msg.RoomID, &msg.UserID, &msg.Content, &msg.MessageType, &msg.CreatedAt,
-			&msg.Username, &msg.DisplayName, &msg.AvatarURL,
-		)
+		msg, err := scanMessageWithUser(rows)
 		if err != nil {
 			return nil, err
 		}
-		messages = append(messages, msg)
+		messages = append(messages, *msg)
 	}
 
 	// Reverse to get chronological order
@@ -94,83 +324,614 @@ func (r *MessageRepository) GetByRoom(ctx context.Context, roomID uuid.UUID, lim
 	return messages, nil
 }
 
+// GetBefore returns up to limit timeline messages older than cursor (or the
+// newest messages when cursor is nil) in chronological order, and whether
+// older messages remain
+func (r *MessageRepository) GetBefore(ctx context.Context, roomID uuid.UUID, cursor *model.MessageCursor, limit int) ([]model.MessageWithUser, bool, error) {
+	query := messageSelect + `
+		WHERE m.room_id = $1 AND m.thread_root_id IS NULL
+		  AND ($2::timestamptz IS NULL OR (m.created_at, m.id) < ($2, $3))
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $4
+	`
+
+	var createdAt *time.Time
+	var id *uuid.UUID
+	if cursor != nil {
+		createdAt, id = &cursor.CreatedAt, &cursor.ID
+	}
+
+	messages, err := r.queryMessages(ctx, query, roomID, createdAt, id, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	// Reverse to get chronological order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, hasMore, nil
+}
+
+// GetAfter returns up to limit timeline messages newer than cursor in
+// chronological order, and whether newer messages remain
+func (r *MessageRepository) GetAfter(ctx context.Context, roomID uuid.UUID, cursor *model.MessageCursor, limit int) ([]model.MessageWithUser, bool, error) {
+	query := messageSelect + `
+		WHERE m.room_id = $1 AND m.thread_root_id IS NULL
+		  AND (m.created_at, m.id) > ($2, $3)
+		ORDER BY m.created_at ASC, m.id ASC
+		LIMIT $4
+	`
+
+	messages, err := r.queryMessages(ctx, query, roomID, cursor.CreatedAt, cursor.ID, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	return messages, hasMore, nil
+}
+
+// GetSinceSeq returns up to limit timeline messages after sequence number
+// seq in sequence order, and whether more remain
+func (r *MessageRepository) GetSinceSeq(ctx context.Context, roomID uuid.UUID, seq int64, limit int) ([]model.MessageWithUser, bool, error) {
+	query := messageSelect + `
+		WHERE m.room_id = $1 AND m.seq > $2
+		ORDER BY m.seq ASC
+		LIMIT $3
+	`
+
+	messages, err := r.queryMessages(ctx, query, roomID, seq, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	return messages, hasMore, nil
+}
+
+func (r *MessageRepository) queryMessages(ctx context.Context, query string, args ...interface{}) ([]model.MessageWithUser, error) {
+	rows, err := r.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []model.MessageWithUser
+	for rows.Next() {
+		msg, err := scanMessageWithUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// GetThread returns replies to a thread root in chronological order
+func (r *MessageRepository) GetThread(ctx context.Context, rootID uuid.UUID, limit, offset int) ([]model.MessageWithUser, error) {
+	query := messageSelect + `
+		WHERE m.thread_root_id = $1
+		ORDER BY m.created_at ASC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, rootID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []model.MessageWithUser
+	for rows.Next() {
+		msg, err := scanMessageWithUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *msg)
+	}
+
+	return messages, nil
+}
+
 func (r *MessageRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.MessageWithUser, error) {
-	msg := &model.MessageWithUser{}
+	query := messageSelect + `WHERE m.id = $1`
+	return scanMessageWithUser(r.db.Pool.QueryRow(ctx, query, id))
+}
+
+// Update replaces the content of a message that has not been deleted. Its
+// link previews are dropped since they described the old content.
+func (r *MessageRepository) Update(ctx context.Context, id uuid.UUID, content string) (*model.Message, error) {
+	msg := &model.Message{}
 
 	query := `
-		SELECT m.id, m.room_id, m.user_id, m.content, m.message_type, m.created_at,
-			   COALESCE(u.username, 'deleted') as username,
-			   COALESCE(u.display_name, 'Deleted User') as display_name,
-			   u.avatar_url
-		FROM messages m
-		LEFT JOIN users u ON m.user_id = u.id
-		WHERE m.id = $1
+		UPDATE messages SET content = $2, edited_at = $3, link_previews = NULL
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id, room_id, user_id, content, message_type, created_at, edited_at, deleted_at, reply_to_id, thread_root_id, COALESCE(seq, 0)
 	`
 
-	err := r.db.Pool.QueryRow(ctx, query, id).Scan(
-		&msg.ID, &msg.RoomID, &msg.UserID, &msg.Content, &msg.MessageType, &msg.CreatedAt,
-		&msg.Username, &msg.DisplayName, &msg.AvatarURL,
+	err := r.db.Pool.QueryRow(ctx, query, id, content, time.Now()).Scan(
+		&msg.ID, &msg.RoomID, &msg.UserID, &msg.Content, &msg.MessageType, &msg.CreatedAt, &msg.EditedAt, &msg.DeletedAt,
+		&msg.ReplyToID, &msg.ThreadRootID, &msg.Seq,
 	)
+	if err != nil {
+		return nil, err
+	}
 
+	go r.refreshInStream(context.Background(), msg.RoomID, msg.ID)
+
+	return msg, nil
+}
+
+// Delete retracts a message: it stays in history as a tombstone with its
+// content cleared
+func (r *MessageRepository) Delete(ctx context.Context, id uuid.UUID) (*model.Message, error) {
+	msg := &model.Message{}
+
+	query := `
+		UPDATE messages SET content = '', link_previews = NULL, deleted_at = $2
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id, room_id, user_id, content, message_type, created_at, edited_at, deleted_at, reply_to_id, thread_root_id, COALESCE(seq, 0)
+	`
+
+	err := r.db.Pool.QueryRow(ctx, query, id, time.Now()).Scan(
+		&msg.ID, &msg.RoomID, &msg.UserID, &msg.Content, &msg.MessageType, &msg.CreatedAt, &msg.EditedAt, &msg.DeletedAt,
+		&msg.ReplyToID, &msg.ThreadRootID, &msg.Seq,
+	)
 	if err != nil {
 		return nil, err
 	}
 
+	// A deleted reply changes its root's thread summary
+	cachedID := msg.ID
+	if msg.ThreadRootID != nil {
+		cachedID = *msg.ThreadRootID
+	}
+	go r.refreshInStream(context.Background(), msg.RoomID, cachedID)
+
 	return msg, nil
 }
 
+// SetLinkPreviews stores the previews unfurled from content. It does nothing
+// (and returns false) if the message was edited or deleted in the meantime.
+func (r *MessageRepository) SetLinkPreviews(ctx context.Context, id uuid.UUID, content string, previews []model.LinkPreview) (bool, error) {
+	data, err := json.Marshal(previews)
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+		UPDATE messages SET link_previews = $3
+		WHERE id = $1 AND content = $2 AND deleted_at IS NULL
+		RETURNING room_id, thread_root_id
+	`
+
+	var roomID uuid.UUID
+	var threadRootID *uuid.UUID
+	err = r.db.Pool.QueryRow(ctx, query, id, content, data).Scan(&roomID, &threadRootID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Replies aren't cached; their previews don't change the root's entry
+	if threadRootID == nil {
+		go r.refreshInStream(context.Background(), roomID, id)
+	}
+
+	return true, nil
+}
+
+// Search finds messages matching params.Query in rooms the viewer can read.
+// Word matches come from the tsvector index; substring matches (needed for
+// Thai, which has no word spaces) come from the trigram index.
+func (r *MessageRepository) Search(ctx context.Context, params *model.SearchParams, viewerID uuid.UUID) ([]model.SearchResult, error) {
+	query := `
+		SELECT ` + messageColumns + `, rm.name,
+			   ts_rank(m.search_vector, plainto_tsquery('simple', $1)) + similarity(m.content, $1) AS rank
+		FROM messages m
+		INNER JOIN rooms rm ON rm.id = m.room_id
+		` + messageJoins + `
+		WHERE (m.search_vector @@ plainto_tsquery('simple', $1) OR m.content ILIKE '%' || $2 || '%')
+		  AND m.deleted_at IS NULL
+		  AND (rm.is_private = false
+			   OR EXISTS(SELECT 1 FROM room_members mem WHERE mem.room_id = rm.id AND mem.user_id = $3))
+		  AND ($4::uuid IS NULL OR m.room_id = $4)
+		  AND ($5::uuid IS NULL OR m.user_id = $5)
+		  AND ($6::timestamptz IS NULL OR m.created_at >= $6)
+		  AND ($7::timestamptz IS NULL OR m.created_at < $7)
+		  AND ($8::text IS NULL OR m.message_type = $8)
+		ORDER BY rank DESC, m.created_at DESC
+		LIMIT $9 OFFSET $10
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query,
+		params.Query, escapeLike(params.Query), viewerID,
+		params.RoomID, params.UserID, params.From, params.To, params.MessageType,
+		params.Limit, params.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []model.SearchResult
+	for rows.Next() {
+		var result model.SearchResult
+		msg, err := scanMessageWithUser(rows, &result.RoomName, &result.Rank)
+		if err != nil {
+			return nil, err
+		}
+		result.MessageWithUser = *msg
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
 // Redis Stream operations
+//
+// chat:stream:<roomID> caches the newest timeline messages of a room with
+// author info embedded, so join-time history needs no database round-trip.
+// The stream is only trusted while its warm marker is set: "complete" when
+// it holds the whole timeline, "partial" when it holds just the tail.
+
+const (
+	streamMaxLen       = 100
+	streamWarmComplete = "complete"
+	streamWarmPartial  = "partial"
+)
+
+func streamKey(roomID uuid.UUID) string {
+	return fmt.Sprintf("chat:stream:%s", roomID.String())
+}
+
+func streamWarmKey(roomID uuid.UUID) string {
+	return streamKey(roomID) + ":warm"
+}
+
+// StreamCacheStats counts join-time history reads served by this instance
+type StreamCacheStats struct {
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+func (r *MessageRepository) CacheStats() StreamCacheStats {
+	stats := StreamCacheStats{Hits: r.streamHits.Load(), Misses: r.streamMisses.Load()}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// GetRecent returns the newest timeline messages in chronological order,
+// from the stream when it is warm and from Postgres otherwise. A miss warms
+// the stream for the next reader.
+func (r *MessageRepository) GetRecent(ctx context.Context, roomID uuid.UUID, limit int) ([]model.MessageWithUser, error) {
+	if limit <= streamMaxLen {
+		messages, hit, err := r.GetRecentFromStream(ctx, roomID, int64(limit))
+		if err != nil {
+			log.Printf("⚠️ Stream cache read failed for room %s: %v", roomID, err)
+		}
+		if hit {
+			r.streamHits.Add(1)
+			return messages, nil
+		}
+	}
+	r.streamMisses.Add(1)
+
+	count := max(limit, streamMaxLen)
+	var messages []model.MessageWithUser
+	loaded := false
+
+	// WATCH spans the database read: a message appended meanwhile would be
+	// wiped by the rebuild, so the warm-up is abandoned instead
+	err := r.redis.Client.Watch(ctx, func(tx *redis.Tx) error {
+		var hasMore bool
+		var err error
+		messages, hasMore, err = r.GetBefore(ctx, roomID, nil, count)
+		if err != nil {
+			return err
+		}
+		loaded = true
+
+		tail := messages
+		if len(tail) > streamMaxLen {
+			tail = tail[len(tail)-streamMaxLen:]
+		}
+		complete := !hasMore && len(tail) == len(messages)
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return fillStream(ctx, pipe, roomID, tail, complete)
+		})
+		return err
+	}, streamKey(roomID))
+
+	if !loaded {
+		// Redis unavailable (or the read failed): serve from Postgres alone
+		messages, _, err = r.GetBefore(ctx, roomID, nil, limit)
+		return messages, err
+	}
+	if err != nil && err != redis.TxFailedErr {
+		log.Printf("⚠️ Failed to warm stream cache for room %s: %v", roomID, err)
+	}
+
+	if len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return messages, nil
+}
+
+// fillStream queues a rebuild of the stream from messages (oldest first).
+// Entry IDs are derived from created_at so they stay ordered with live
+// appends.
+func fillStream(ctx context.Context, pipe redis.Pipeliner, roomID uuid.UUID, messages []model.MessageWithUser, complete bool) error {
+	key := streamKey(roomID)
+	pipe.Del(ctx, key)
+
+	var lastMs, seq int64 = -1, 0
+	for i := range messages {
+		data, err := json.Marshal(&messages[i])
+		if err != nil {
+			return err
+		}
 
-func (r *MessageRepository) addToStream(ctx context.Context, msg *model.Message) error {
-	streamKey := fmt.Sprintf("chat:stream:%s", msg.RoomID.String())
+		ms := messages[i].CreatedAt.UnixMilli()
+		if ms <= lastMs {
+			ms, seq = lastMs, seq+1
+		} else {
+			seq = 0
+		}
+		lastMs = ms
+
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: key,
+			ID:     fmt.Sprintf("%d-%d", ms, seq),
+			Values: map[string]interface{}{"data": string(data)},
+		})
+	}
+
+	marker := streamWarmPartial
+	if complete {
+		marker = streamWarmComplete
+	}
+	pipe.Set(ctx, streamWarmKey(roomID), marker, 0)
+	return nil
+}
+
+// InvalidateStream drops the warm marker so the next read rebuilds the
+// stream from Postgres
+func (r *MessageRepository) InvalidateStream(ctx context.Context, roomID uuid.UUID) error {
+	return r.redis.Client.Del(ctx, streamWarmKey(roomID)).Err()
+}
 
+func (r *MessageRepository) addToStream(ctx context.Context, msg *model.MessageWithUser) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
 
-	_, err = r.redis.Client.XAdd(ctx, &redis.XAddArgs{
-		Stream: streamKey,
-		MaxLen: 100, // Keep only last 100 messages in stream
+	return r.redis.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey(msg.RoomID),
+		MaxLen: streamMaxLen, // Keep only the newest messages in stream
 		Approx: true,
 		Values: map[string]interface{}{
 			"data": string(data),
 		},
-	}).Result()
-
-	return err
+	}).Err()
 }
 
-func (r *MessageRepository) GetRecentFromStream(ctx context.Context, roomID uuid.UUID, count int64) ([]model.Message, error) {
-	streamKey := fmt.Sprintf("chat:stream:%s", roomID.String())
+// GetRecentFromStream returns up to count cached messages in chronological
+// order. hit is false when the stream is cold or cannot answer on its own.
+func (r *MessageRepository) GetRecentFromStream(ctx context.Context, roomID uuid.UUID, count int64) ([]model.MessageWithUser, bool, error) {
+	marker, messages, ok, err := r.readStream(ctx, roomID, count)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	if int64(len(messages)) < count && marker != streamWarmComplete {
+		return nil, false, nil
+	}
+
+	return messages, true, nil
+}
 
-	result, err := r.redis.Client.XRevRange(ctx, streamKey, "+", "-").Result()
+// GetSince returns up to limit timeline messages after sequence number seq,
+// and whether more remain. The stream answers when it still holds the whole
+// gap; longer absences are read from Postgres.
+func (r *MessageRepository) GetSince(ctx context.Context, roomID uuid.UUID, seq int64, limit int) ([]model.MessageWithUser, bool, error) {
+	_, cached, ok, err := r.readStream(ctx, roomID, 0)
 	if err != nil {
-		return nil, err
+		log.Printf("⚠️ Stream cache read failed for room %s: %v", roomID, err)
+	}
+
+	if ok && streamCovers(cached, seq) {
+		messages := make([]model.MessageWithUser, 0, len(cached))
+		for _, msg := range cached {
+			if msg.Seq > seq {
+				messages = append(messages, msg)
+			}
+		}
+		if len(messages) <= limit {
+			r.streamHits.Add(1)
+			return messages, false, nil
+		}
 	}
+	r.streamMisses.Add(1)
 
-	var messages []model.Message
-	for i, stream := range result {
-		if int64(i) >= count {
-			break
+	return r.GetSinceSeq(ctx, roomID, seq, limit)
+}
+
+// streamCovers reports whether cached, in sequence order, holds every
+// timeline message after seq. The warm marker can't vouch for that: the
+// stream is trimmed as messages arrive, and racing appends can leave gaps.
+func streamCovers(cached []model.MessageWithUser, seq int64) bool {
+	if len(cached) == 0 || cached[0].Seq > seq+1 {
+		return false
+	}
+	for i := 1; i < len(cached); i++ {
+		if cached[i].Seq != cached[i-1].Seq+1 {
+			return false
 		}
+	}
+	return true
+}
 
-		data, ok := stream.Values["data"].(string)
+// readStream returns the warm marker and the newest count cached messages
+// (all of them when count is 0) in sequence order. ok is false when the
+// stream is cold, evicted or holds entries from before author info and
+// sequence numbers were cached.
+func (r *MessageRepository) readStream(ctx context.Context, roomID uuid.UUID, count int64) (string, []model.MessageWithUser, bool, error) {
+	pipe := r.redis.Client.Pipeline()
+	markerCmd := pipe.Get(ctx, streamWarmKey(roomID))
+	var entriesCmd *redis.XMessageSliceCmd
+	if count > 0 {
+		entriesCmd = pipe.XRevRangeN(ctx, streamKey(roomID), "+", "-", count)
+	} else {
+		entriesCmd = pipe.XRevRange(ctx, streamKey(roomID), "+", "-")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return "", nil, false, err
+	}
+
+	marker, err := markerCmd.Result()
+	if err == redis.Nil {
+		return "", nil, false, nil
+	}
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	entries, err := entriesCmd.Result()
+	if err != nil {
+		return "", nil, false, err
+	}
+	// An evicted stream must not pass for an empty room
+	if len(entries) == 0 {
+		return "", nil, false, nil
+	}
+
+	seen := make(map[uuid.UUID]bool, len(entries))
+	messages := make([]model.MessageWithUser, 0, len(entries))
+	for _, entry := range entries {
+		data, ok := entry.Values["data"].(string)
 		if !ok {
 			continue
 		}
 
-		var msg model.Message
+		var msg model.MessageWithUser
 		if err := json.Unmarshal([]byte(data), &msg); err != nil {
 			continue
 		}
+		if msg.Username == "" || msg.Seq == 0 {
+			return "", nil, false, nil
+		}
+		// A live append racing a warm-up can land twice
+		if seen[msg.ID] {
+			continue
+		}
+		seen[msg.ID] = true
 		messages = append(messages, msg)
 	}
 
-	// Reverse to get chronological order
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Seq < messages[j].Seq
+	})
+
+	return marker, messages, len(messages) > 0, nil
+}
+
+// refreshInStream re-reads a message and rewrites its cached entry. If that
+// fails the room's stream is invalidated rather than left stale.
+func (r *MessageRepository) refreshInStream(ctx context.Context, roomID, messageID uuid.UUID) {
+	msg, err := r.GetByID(ctx, messageID)
+	if err == nil {
+		err = r.updateInStream(ctx, msg)
+	}
+	if err != nil {
+		log.Printf("⚠️ Invalidating stream cache for room %s: %v", roomID, err)
+		r.InvalidateStream(ctx, roomID)
 	}
+}
 
-	return messages, nil
+// updateInStream rewrites the cached stream entry for a changed message.
+// Streams are append-only, so the stream is rebuilt with the original entry
+// IDs inside a WATCHed transaction; a concurrent append retries.
+func (r *MessageRepository) updateInStream(ctx context.Context, msg *model.MessageWithUser) error {
+	key := streamKey(msg.RoomID)
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	rewrite := func(tx *redis.Tx) error {
+		entries, err := tx.XRange(ctx, key, "-", "+").Result()
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for i, entry := range entries {
+			raw, ok := entry.Values["data"].(string)
+			if !ok {
+				continue
+			}
+			var cached model.MessageWithUser
+			if err := json.Unmarshal([]byte(raw), &cached); err != nil || cached.ID != msg.ID {
+				continue
+			}
+			entries[i].Values["data"] = string(data)
+			found = true
+		}
+
+		// Not cached (already trimmed or never added), nothing to update
+		if !found {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			for _, entry := range entries {
+				pipe.XAdd(ctx, &redis.XAddArgs{
+					Stream: key,
+					ID:     entry.ID,
+					Values: entry.Values,
+				})
+			}
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		err = r.redis.Client.Watch(ctx, rewrite, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return err
 }
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/khonE3/chat-backend/internal/model"
+)
+
+func cachedSeqs(seqs ...int64) []model.MessageWithUser {
+	messages := make([]model.MessageWithUser, len(seqs))
+	for i, seq := range seqs {
+		messages[i].Seq = seq
+	}
+	return messages
+}
+
+func TestStreamCovers(t *testing.T) {
+	tests := []struct {
+		name   string
+		cached []model.MessageWithUser
+		seq    int64
+		want   bool
+	}{
+		{"empty stream", nil, 0, false},
+		{"whole history", cachedSeqs(1, 2, 3), 0, true},
+		{"gap starts inside the stream", cachedSeqs(5, 6, 7), 5, true},
+		{"gap starts just before the stream", cachedSeqs(5, 6, 7), 4, true},
+		{"caught up", cachedSeqs(5, 6, 7), 7, true},
+		{"ahead of the stream", cachedSeqs(5, 6, 7), 9, true},
+		// A room that warmed complete and was then trimmed past its start
+		{"trimmed past the gap", cachedSeqs(101, 102, 103), 40, false},
+		{"trimmed from the first message", cachedSeqs(2, 3), 0, false},
+		// Racing appends can leave holes in the cached range
+		{"hole after seq", cachedSeqs(5, 6, 8, 9), 5, false},
+		{"hole before seq", cachedSeqs(5, 7, 8), 7, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := streamCovers(tt.cached, tt.seq); got != tt.want {
+				t.Errorf("streamCovers(%v, %d) = %v, want %v", tt.cached, tt.seq, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/pkg/database"
+)
+
+// activeRestriction matches room_restrictions rows still in force
+const activeRestriction = `rr.lifted_at IS NULL AND (rr.expires_at IS NULL OR rr.expires_at > NOW())`
+
+const restrictionColumns = `
+	rr.id, rr.room_id, rr.user_id, u.username, u.display_name,
+	rr.kind, rr.reason, rr.created_by, rr.created_at, rr.expires_at
+`
+
+type ModerationRepository struct {
+	db *database.Postgres
+}
+
+func NewModerationRepository(db *database.Postgres) *ModerationRepository {
+	return &ModerationRepository{db: db}
+}
+
+// restrictionGroup returns the kinds a new restriction of kind replaces: a
+// ban replaces a ban, and a mute or timeout replaces either
+func restrictionGroup(kind model.RestrictionKind) []string {
+	if kind.Silences() {
+		return []string{string(model.RestrictionMute), string(model.RestrictionTimeout)}
+	}
+	return []string{string(kind)}
+}
+
+// Restrict records a restriction, lifting the active one it replaces. A ban
+// also takes the user out of the room and drops their pending invitations
+// and join requests, so neither can let them back in.
+func (r *ModerationRepository) Restrict(ctx context.Context, res *model.RoomRestriction) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	lift := `
+		UPDATE room_restrictions rr SET lifted_at = $4, lifted_by = $5
+		WHERE rr.room_id = $1 AND rr.user_id = $2 AND rr.kind = ANY($3) AND ` + activeRestriction
+	if _, err := tx.Exec(ctx, lift, res.RoomID, res.UserID, restrictionGroup(res.Kind), res.CreatedAt, res.CreatedBy); err != nil {
+		return err
+	}
+
+	insert := `
+		INSERT INTO room_restrictions (id, room_id, user_id, kind, reason, created_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err = tx.Exec(ctx, insert,
+		res.ID, res.RoomID, res.UserID, res.Kind, res.Reason, res.CreatedBy, res.CreatedAt, res.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	if res.Kind == model.RestrictionBan {
+		for _, table := range []string{"room_members", "room_invitations", "room_join_requests"} {
+			remove := `DELETE FROM ` + table + ` WHERE room_id = $1 AND user_id = $2`
+			if _, err := tx.Exec(ctx, remove, res.RoomID, res.UserID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Lift ends the user's active restrictions of the given kind, and of kinds
+// it stands in for, reporting whether there were any
+func (r *ModerationRepository) Lift(ctx context.Context, roomID, userID, liftedBy uuid.UUID, kind model.RestrictionKind) (bool, error) {
+	query := `
+		UPDATE room_restrictions rr SET lifted_at = NOW(), lifted_by = $4
+		WHERE rr.room_id = $1 AND rr.user_id = $2 AND rr.kind = ANY($3) AND ` + activeRestriction
+
+	tag, err := r.db.Pool.Exec(ctx, query, roomID, userID, restrictionGroup(kind), liftedBy)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Active returns the user's restrictions in force in a room
+func (r *ModerationRepository) Active(ctx context.Context, roomID, userID uuid.UUID) ([]model.RoomRestriction, error) {
+	query := `
+		SELECT ` + restrictionColumns + `
+		FROM room_restrictions rr
+		INNER JOIN users u ON u.id = rr.user_id
+		WHERE rr.room_id = $1 AND rr.user_id = $2 AND ` + activeRestriction
+
+	rows, err := r.db.Pool.Query(ctx, query, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRestrictions(rows)
+}
+
+// ListActive returns the restrictions in force in a room, newest first
+func (r *ModerationRepository) ListActive(ctx context.Context, roomID uuid.UUID) ([]model.RoomRestriction, error) {
+	query := `
+		SELECT ` + restrictionColumns + `
+		FROM room_restrictions rr
+		INNER JOIN users u ON u.id = rr.user_id
+		WHERE rr.room_id = $1 AND ` + activeRestriction + `
+		ORDER BY rr.created_at DESC
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRestrictions(rows)
+}
+
+func scanRestrictions(rows pgx.Rows) ([]model.RoomRestriction, error) {
+	defer rows.Close()
+
+	restrictions := []model.RoomRestriction{}
+	for rows.Next() {
+		var res model.RoomRestriction
+		err := rows.Scan(
+			&res.ID, &res.RoomID, &res.UserID, &res.Username, &res.DisplayName,
+			&res.Kind, &res.Reason, &res.CreatedBy, &res.CreatedAt, &res.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		restrictions = append(restrictions, res)
+	}
+	return restrictions, rows.Err()
+}
@@ -5,17 +5,36 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/google/uuid"
 	"github.com/khonE3/chat-backend/internal/model"
 	redisclient "github.com/khonE3/chat-backend/pkg/redis"
 	"github.com/redis/go-redis/v9"
 )
 
 type PubSubRepository struct {
-	redis *redisclient.Redis
+	redis      *redisclient.Redis
+	instanceID string
+}
+
+// PubSubEnvelope wraps every published WebSocket message with the ID of the
+// instance that published it, so subscribers can skip their own events
+type PubSubEnvelope struct {
+	InstanceID string          `json:"instance_id"`
+	UserID     string          `json:"user_id,omitempty"` // recipient of a global user event
+	RoomID     string          `json:"room_id,omitempty"` // subject of a global room event
+	Message    json.RawMessage `json:"message"`
 }
 
 func NewPubSubRepository(redis *redisclient.Redis) *PubSubRepository {
-	return &PubSubRepository{redis: redis}
+	return &PubSubRepository{
+		redis:      redis,
+		instanceID: uuid.New().String(),
+	}
+}
+
+// InstanceID returns the unique ID this server instance stamps on its publications
+func (r *PubSubRepository) InstanceID() string {
+	return r.instanceID
 }
 
 // Channel name formats
@@ -31,6 +50,10 @@ func presenceChannel(roomID string) string {
 	return fmt.Sprintf("chat:presence:%s", roomID)
 }
 
+// globalEventsChannel carries global WebSocket events: those for a single
+// user and those about a room, which each instance filters for its clients
+const globalEventsChannel = "chat:global-events"
+
 // PublishMessage publishes a message to the room channel
 func (r *PubSubRepository) PublishMessage(ctx context.Context, roomID string, msg *model.MessageWithUser) error {
 	channel := roomChannel(roomID)
@@ -38,14 +61,10 @@ func (r *PubSubRepository) PublishMessage(ctx context.Context, roomID string, ms
 	wsMsg := model.WSMessage{
 		Type:    model.WSTypeMessage,
 		Payload: msg,
+		Seq:     msg.Seq,
 	}
 
-	data, err := json.Marshal(wsMsg)
-	if err != nil {
-		return err
-	}
-
-	return r.redis.Client.Publish(ctx, channel, string(data)).Err()
+	return r.publish(ctx, channel, wsMsg)
 }
 
 // PublishTyping publishes typing status to the room
@@ -62,12 +81,7 @@ func (r *PubSubRepository) PublishTyping(ctx context.Context, roomID string, pay
 		Payload: payload,
 	}
 
-	data, err := json.Marshal(wsMsg)
-	if err != nil {
-		return err
-	}
-
-	return r.redis.Client.Publish(ctx, channel, string(data)).Err()
+	return r.publish(ctx, channel, wsMsg)
 }
 
 // PublishPresence publishes user presence (online/offline) to the room
@@ -79,7 +93,24 @@ func (r *PubSubRepository) PublishPresence(ctx context.Context, roomID string, p
 		Payload: payload,
 	}
 
-	data, err := json.Marshal(wsMsg)
+	return r.publish(ctx, channel, wsMsg)
+}
+
+// Publish publishes an arbitrary WebSocket message to the room channel
+func (r *PubSubRepository) Publish(ctx context.Context, roomID string, wsMsg model.WSMessage) error {
+	return r.publish(ctx, roomChannel(roomID), wsMsg)
+}
+
+func (r *PubSubRepository) publish(ctx context.Context, channel string, wsMsg model.WSMessage) error {
+	msgData, err := json.Marshal(wsMsg)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(PubSubEnvelope{
+		InstanceID: r.instanceID,
+		Message:    msgData,
+	})
 	if err != nil {
 		return err
 	}
@@ -87,6 +118,54 @@ func (r *PubSubRepository) PublishPresence(ctx context.Context, roomID string, p
 	return r.redis.Client.Publish(ctx, channel, string(data)).Err()
 }
 
+// PublishUserEvent publishes a global WebSocket message meant for one user
+func (r *PubSubRepository) PublishUserEvent(ctx context.Context, userID string, msg json.RawMessage) error {
+	data, err := json.Marshal(PubSubEnvelope{
+		InstanceID: r.instanceID,
+		UserID:     userID,
+		Message:    msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.redis.Client.Publish(ctx, globalEventsChannel, string(data)).Err()
+}
+
+// PublishRoomEvent publishes a global WebSocket message about a room
+func (r *PubSubRepository) PublishRoomEvent(ctx context.Context, roomID string, msg json.RawMessage) error {
+	data, err := json.Marshal(PubSubEnvelope{
+		InstanceID: r.instanceID,
+		RoomID:     roomID,
+		Message:    msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.redis.Client.Publish(ctx, globalEventsChannel, string(data)).Err()
+}
+
+// SubscribeGlobalEvents subscribes to user and room events for the global
+// WebSocket
+func (r *PubSubRepository) SubscribeGlobalEvents(ctx context.Context) *redis.PubSub {
+	return r.redis.Client.Subscribe(ctx, globalEventsChannel)
+}
+
+// DecodeEnvelope parses a payload received from a room subscription
+func (r *PubSubRepository) DecodeEnvelope(payload string) (*PubSubEnvelope, error) {
+	var env PubSubEnvelope
+	if err := json.Unmarshal([]byte(payload), &env); err != nil {
+		return nil, err
+	}
+	return &env, nil
+}
+
+// IsOwn reports whether an envelope was published by this instance
+func (r *PubSubRepository) IsOwn(env *PubSubEnvelope) bool {
+	return env.InstanceID == r.instanceID
+}
+
 // Subscribe subscribes to all channels for a room
 func (r *PubSubRepository) Subscribe(ctx context.Context, roomID string) *redis.PubSub {
 	channels := []string{
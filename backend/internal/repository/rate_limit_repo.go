@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/model"
+	redisclient "github.com/khonE3/chat-backend/pkg/redis"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitRepository keeps token buckets and slow mode cooldowns in Redis
+// so every instance enforces the same limits
+type RateLimitRepository struct {
+	redis *redisclient.Redis
+}
+
+func NewRateLimitRepository(redis *redisclient.Redis) *RateLimitRepository {
+	return &RateLimitRepository{redis: redis}
+}
+
+func rateLimitKey(action, subject string) string {
+	return fmt.Sprintf("chat:ratelimit:%s:%s", action, subject)
+}
+
+func slowModeKey(roomID, userID uuid.UUID) string {
+	return fmt.Sprintf("chat:slowmode:%s:%s", roomID, userID)
+}
+
+// takeToken refills the bucket for the time since it was last touched, then
+// takes one token. It returns 0 when a token was taken, otherwise the
+// milliseconds until one is available. Redis' clock is used so instances
+// with skewed clocks share one timeline.
+var takeToken = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local refill = tonumber(ARGV[2])
+
+local now = redis.call('TIME')
+now = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + (now - ts) / refill)
+
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * refill)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * refill))
+return wait
+`)
+
+// Take takes a token from the bucket for action and subject, returning how
+// long to wait when the bucket is empty
+func (r *RateLimitRepository) Take(ctx context.Context, action, subject string, limit model.RateLimit) (time.Duration, error) {
+	refill := limit.Period.Milliseconds() / int64(limit.Burst)
+	if refill < 1 {
+		refill = 1
+	}
+
+	wait, err := takeToken.Run(ctx, r.redis.Client, []string{rateLimitKey(action, subject)}, limit.Burst, refill).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// StartCooldown starts the user's slow mode cooldown in a room unless one is
+// already running, in which case it returns the time left
+func (r *RateLimitRepository) StartCooldown(ctx context.Context, roomID, userID uuid.UUID, interval time.Duration) (time.Duration, error) {
+	key := slowModeKey(roomID, userID)
+
+	started, err := r.redis.Client.SetNX(ctx, key, 1, interval).Result()
+	if err != nil || started {
+		return 0, err
+	}
+
+	left, err := r.redis.Client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if left < 0 {
+		// Expired between the two calls
+		return 0, nil
+	}
+	return left, nil
+}
+
+// EndCooldown cancels the user's slow mode cooldown in a room
+func (r *RateLimitRepository) EndCooldown(ctx context.Context, roomID, userID uuid.UUID) error {
+	return r.redis.Client.Del(ctx, slowModeKey(roomID, userID)).Err()
+}
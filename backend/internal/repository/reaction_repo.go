@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/pkg/database"
+)
+
+type ReactionRepository struct {
+	db *database.Postgres
+}
+
+func NewReactionRepository(db *database.Postgres) *ReactionRepository {
+	return &ReactionRepository{db: db}
+}
+
+// Add records a reaction and returns the new count for that emoji
+func (r *ReactionRepository) Add(ctx context.Context, messageID, userID uuid.UUID, emoji string) (int, error) {
+	query := `
+		INSERT INTO message_reactions (message_id, user_id, emoji, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (message_id, user_id, emoji) DO NOTHING
+	`
+
+	if _, err := r.db.Pool.Exec(ctx, query, messageID, userID, emoji, time.Now()); err != nil {
+		return 0, err
+	}
+
+	return r.Count(ctx, messageID, emoji)
+}
+
+// Remove deletes a reaction and returns the new count for that emoji
+func (r *ReactionRepository) Remove(ctx context.Context, messageID, userID uuid.UUID, emoji string) (int, error) {
+	query := `DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`
+
+	if _, err := r.db.Pool.Exec(ctx, query, messageID, userID, emoji); err != nil {
+		return 0, err
+	}
+
+	return r.Count(ctx, messageID, emoji)
+}
+
+func (r *ReactionRepository) Count(ctx context.Context, messageID uuid.UUID, emoji string) (int, error) {
+	query := `SELECT COUNT(*) FROM message_reactions WHERE message_id = $1 AND emoji = $2`
+
+	var count int
+	err := r.db.Pool.QueryRow(ctx, query, messageID, emoji).Scan(&count)
+	return count, err
+}
+
+// GetForMessages returns reaction summaries keyed by message ID, flagging the
+// emojis viewerID has reacted with. Emojis are ordered by first use.
+func (r *ReactionRepository) GetForMessages(ctx context.Context, messageIDs []uuid.UUID, viewerID uuid.UUID) (map[uuid.UUID][]model.ReactionSummary, error) {
+	result := make(map[uuid.UUID][]model.ReactionSummary)
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	ids := make([]string, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = id.String()
+	}
+
+	query := `
+		SELECT message_id, emoji, COUNT(*), BOOL_OR(user_id = $2)
+		FROM message_reactions
+		WHERE message_id = ANY($1::uuid[])
+		GROUP BY message_id, emoji
+		ORDER BY MIN(created_at) ASC
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, ids, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID uuid.UUID
+		var summary model.ReactionSummary
+		if err := rows.Scan(&messageID, &summary.Emoji, &summary.Count, &summary.ReactedByMe); err != nil {
+			return nil, err
+		}
+		result[messageID] = append(result[messageID], summary)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/pkg/database"
+)
+
+type ReceiptRepository struct {
+	db *database.Postgres
+}
+
+func NewReceiptRepository(db *database.Postgres) *ReceiptRepository {
+	return &ReceiptRepository{db: db}
+}
+
+// Advance moves the user's read marker forward to msg. Only members have a
+// marker: reading never adds anyone to a room, so it can't undo a kick or
+// ban or skip the checks of joining. It returns the reader and whether they
+// share read receipts, or pgx.ErrNoRows when the user is not a member or the
+// marker was already past msg.
+func (r *ReceiptRepository) Advance(ctx context.Context, userID uuid.UUID, msg *model.Message) (*model.User, bool, error) {
+	query := `
+		WITH marker AS (
+			UPDATE room_members
+			SET last_read_at = $3, last_read_message_id = $4
+			WHERE room_id = $1 AND user_id = $2
+			  AND (last_read_at IS NULL OR last_read_at < $3)
+			RETURNING user_id
+		)
+		SELECT u.id, u.username, u.display_name, u.avatar_url, u.created_at, u.updated_at, u.read_receipts
+		FROM users u
+		INNER JOIN marker ON marker.user_id = u.id
+	`
+
+	user := &model.User{}
+	var shared bool
+	err := r.db.Pool.QueryRow(ctx, query,
+		msg.RoomID, userID, msg.CreatedAt, msg.ID,
+	).Scan(&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL, &user.CreatedAt, &user.UpdatedAt, &shared)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return user, shared, nil
+}
+
+// GetReaders lists the members who have read msg, leaving out its author
+// and anyone who opted out of read receipts
+func (r *ReceiptRepository) GetReaders(ctx context.Context, msg *model.Message) ([]model.User, error) {
+	query := `
+		SELECT u.id, u.username, u.display_name, u.avatar_url, u.created_at, u.updated_at
+		FROM room_members rm
+		INNER JOIN users u ON u.id = rm.user_id
+		WHERE rm.room_id = $1 AND rm.last_read_at >= $2
+		  AND u.read_receipts
+		  AND ($3::uuid IS NULL OR rm.user_id <> $3)
+		ORDER BY rm.last_read_at ASC
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, msg.RoomID, msg.CreatedAt, msg.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	readers := []model.User{}
+	for rows.Next() {
+		var u model.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		readers = append(readers, u)
+	}
+
+	return readers, rows.Err()
+}
@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/pkg/database"
+)
+
+var (
+	ErrAlreadyReported = errors.New("message already reported by this user")
+	ErrReportNotOpen   = errors.New("report already resolved")
+)
+
+const reportColumns = `
+	r.id, r.message_id, r.room_id, r.reporter_id, ru.username, r.author_id, au.username, au.display_name,
+	r.content, r.reason, r.details, r.status, r.action, r.resolved_by, r.resolved_at, r.note, r.created_at,
+	(SELECT COUNT(*) FROM reports o WHERE o.message_id = r.message_id AND o.status = 'open')
+`
+
+const reportFrom = `
+	FROM reports r
+	INNER JOIN users ru ON ru.id = r.reporter_id
+	LEFT JOIN users au ON au.id = r.author_id
+`
+
+type ReportRepository struct {
+	db *database.Postgres
+}
+
+func NewReportRepository(db *database.Postgres) *ReportRepository {
+	return &ReportRepository{db: db}
+}
+
+// Create stores a report along with the audit entry for filing it. It fails
+// with ErrAlreadyReported if the reporter has reported the message before.
+func (r *ReportRepository) Create(ctx context.Context, report *model.Report) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	insert := `
+		INSERT INTO reports (id, message_id, room_id, reporter_id, author_id, content, reason, details, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	_, err = tx.Exec(ctx, insert,
+		report.ID, report.MessageID, report.RoomID, report.ReporterID, report.AuthorID,
+		report.Content, report.Reason, report.Details, report.Status, report.CreatedAt,
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "idx_reports_message_reporter" {
+		return ErrAlreadyReported
+	}
+	if err != nil {
+		return err
+	}
+
+	audit := `INSERT INTO report_audit (report_id, actor_id, action, created_at) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.Exec(ctx, audit, report.ID, report.ReporterID, model.ReportFiled, report.CreatedAt); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Get returns a report, or pgx.ErrNoRows
+func (r *ReportRepository) Get(ctx context.Context, id uuid.UUID) (*model.Report, error) {
+	query := `SELECT ` + reportColumns + reportFrom + `WHERE r.id = $1`
+
+	rows, err := r.db.Pool.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	reports, err := scanReports(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, pgx.ErrNoRows
+	}
+	return &reports[0], nil
+}
+
+// ListOpen returns open reports, oldest first, in one room or in every room
+// when roomID is nil
+func (r *ReportRepository) ListOpen(ctx context.Context, roomID *uuid.UUID, limit, offset int) ([]model.Report, error) {
+	query := `SELECT ` + reportColumns + reportFrom + `
+		WHERE r.status = 'open' AND ($1::uuid IS NULL OR r.room_id = $1)
+		ORDER BY r.created_at ASC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, roomID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanReports(rows)
+}
+
+// Resolve closes every open report on a message with the same action and
+// writes an audit entry for each, returning their IDs. It fails with
+// ErrReportNotOpen when there are none left, e.g. because another reviewer
+// got there first.
+func (r *ReportRepository) Resolve(ctx context.Context, messageID, actorID uuid.UUID, action model.ReportAction, note *string, at time.Time) ([]uuid.UUID, error) {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	update := `
+		UPDATE reports SET status = $2, action = $3, resolved_by = $4, resolved_at = $5, note = $6
+		WHERE message_id = $1 AND status = 'open'
+		RETURNING id
+	`
+	rows, err := tx.Query(ctx, update, messageID, action.Status(), action, actorID, at, note)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, ErrReportNotOpen
+	}
+
+	audit := `
+		INSERT INTO report_audit (report_id, actor_id, action, note, created_at)
+		SELECT id, $2, $3, $4, $5 FROM unnest($1::uuid[]) AS id
+	`
+	if _, err := tx.Exec(ctx, audit, ids, actorID, action, note, at); err != nil {
+		return nil, err
+	}
+
+	return ids, tx.Commit(ctx)
+}
+
+// Reopen puts back in the queue the reports a Resolve at the given time
+// closed, when its action then failed. The audit trail keeps the action,
+// which may have been partly carried out (a ban without the deletion), and
+// gains a reopened entry after it.
+func (r *ReportRepository) Reopen(ctx context.Context, ids []uuid.UUID, actorID uuid.UUID, at time.Time) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	reopen := `
+		UPDATE reports SET status = 'open', action = NULL, resolved_by = NULL, resolved_at = NULL, note = NULL
+		WHERE id = ANY($1::uuid[]) AND resolved_at = $2
+	`
+	if _, err := tx.Exec(ctx, reopen, ids, at); err != nil {
+		return err
+	}
+
+	audit := `
+		INSERT INTO report_audit (report_id, actor_id, action, note)
+		SELECT id, $2, $3, $4 FROM unnest($1::uuid[]) AS id
+	`
+	if _, err := tx.Exec(ctx, audit, ids, actorID, model.ReportReopened, "action failed"); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Audit returns a report's audit trail, oldest first
+func (r *ReportRepository) Audit(ctx context.Context, reportID uuid.UUID) ([]model.ReportAuditEntry, error) {
+	query := `
+		SELECT a.id, a.report_id, a.actor_id, u.username, a.action, a.note, a.created_at
+		FROM report_audit a
+		LEFT JOIN users u ON u.id = a.actor_id
+		WHERE a.report_id = $1
+		ORDER BY a.created_at ASC
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, reportID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []model.ReportAuditEntry{}
+	for rows.Next() {
+		var e model.ReportAuditEntry
+		if err := rows.Scan(&e.ID, &e.ReportID, &e.ActorID, &e.ActorUsername, &e.Action, &e.Note, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func scanReports(rows pgx.Rows) ([]model.Report, error) {
+	defer rows.Close()
+
+	reports := []model.Report{}
+	for rows.Next() {
+		var rp model.Report
+		err := rows.Scan(
+			&rp.ID, &rp.MessageID, &rp.RoomID, &rp.ReporterID, &rp.ReporterUsername,
+			&rp.AuthorID, &rp.AuthorUsername, &rp.AuthorDisplayName,
+			&rp.Content, &rp.Reason, &rp.Details, &rp.Status, &rp.Action,
+			&rp.ResolvedBy, &rp.ResolvedAt, &rp.Note, &rp.CreatedAt, &rp.OpenReports,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, rp)
+	}
+	return reports, rows.Err()
+}
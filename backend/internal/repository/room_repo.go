@@ -2,13 +2,17 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/khonE3/chat-backend/internal/model"
 	"github.com/khonE3/chat-backend/pkg/database"
 )
 
+var ErrNotMember = errors.New("user is not a member of the room")
+
 type RoomRepository struct {
 	db *database.Postgres
 }
@@ -22,6 +26,7 @@ func (r *RoomRepository) Create(ctx context.Context, req *model.CreateRoomReques
 		ID:        uuid.New(),
 		Name:      req.Name,
 		IsPrivate: req.IsPrivate,
+		Kind:      model.RoomKindRoom,
 		CreatedBy: createdBy,
 		CreatedAt: time.Now(),
 	}
@@ -31,14 +36,14 @@ func (r *RoomRepository) Create(ctx context.Context, req *model.CreateRoomReques
 	}
 
 	query := `
-		INSERT INTO rooms (id, name, description, is_private, created_by, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, name, description, is_private, created_by, created_at
+		INSERT INTO rooms (id, name, description, is_private, kind, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, name, description, is_private, kind, created_by, created_at, slow_mode_seconds
 	`
 
 	err := r.db.Pool.QueryRow(ctx, query,
-		room.ID, room.Name, room.Description, room.IsPrivate, room.CreatedBy, room.CreatedAt,
-	).Scan(&room.ID, &room.Name, &room.Description, &room.IsPrivate, &room.CreatedBy, &room.CreatedAt)
+		room.ID, room.Name, room.Description, room.IsPrivate, room.Kind, room.CreatedBy, room.CreatedAt,
+	).Scan(&room.ID, &room.Name, &room.Description, &room.IsPrivate, &room.Kind, &room.CreatedBy, &room.CreatedAt, &room.SlowModeSeconds)
 
 	if err != nil {
 		return nil, err
@@ -51,12 +56,13 @@ func (r *RoomRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.Room
 	room := &model.Room{}
 
 	query := `
-		SELECT id, name, description, is_private, created_by, created_at
+		SELECT id, name, description, is_private, kind, created_by, created_at, slow_mode_seconds
 		FROM rooms WHERE id = $1
 	`
 
 	err := r.db.Pool.QueryRow(ctx, query, id).Scan(
-		&room.ID, &room.Name, &room.Description, &room.IsPrivate, &room.CreatedBy, &room.CreatedAt,
+		&room.ID, &room.Name, &room.Description, &room.IsPrivate, &room.Kind, &room.CreatedBy, &room.CreatedAt,
+		&room.SlowModeSeconds,
 	)
 
 	if err != nil {
@@ -66,21 +72,33 @@ func (r *RoomRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.Room
 	return room, nil
 }
 
+// List returns the listed rooms. Direct messages are never listed.
 func (r *RoomRepository) List(ctx context.Context, includePrivate bool) ([]model.RoomWithMembers, error) {
+	if includePrivate {
+		return r.listRooms(ctx, `WHERE r.kind = 'room'`)
+	}
+	return r.listRooms(ctx, `WHERE r.kind = 'room' AND r.is_private = false`)
+}
+
+// ListVisible returns public rooms plus the private rooms the user belongs to
+func (r *RoomRepository) ListVisible(ctx context.Context, userID uuid.UUID) ([]model.RoomWithMembers, error) {
+	return r.listRooms(ctx, `
+		WHERE r.kind = 'room' AND (
+			r.is_private = false
+			OR EXISTS(SELECT 1 FROM room_members m WHERE m.room_id = r.id AND m.user_id = $1)
+		)
+	`, userID)
+}
+
+func (r *RoomRepository) listRooms(ctx context.Context, where string, args ...interface{}) ([]model.RoomWithMembers, error) {
 	query := `
-		SELECT r.id, r.name, r.description, r.is_private, r.created_by, r.created_at,
-			   COALESCE(COUNT(rm.user_id), 0) as member_count
+		SELECT r.id, r.name, r.description, r.is_private, r.kind, r.created_by, r.created_at,
+			   r.slow_mode_seconds, COALESCE(COUNT(rm.user_id), 0) as member_count
 		FROM rooms r
 		LEFT JOIN room_members rm ON r.id = rm.room_id
-	`
+	` + where + ` GROUP BY r.id ORDER BY r.created_at ASC`
 
-	if !includePrivate {
-		query += ` WHERE r.is_private = false`
-	}
-
-	query += ` GROUP BY r.id ORDER BY r.created_at ASC`
-
-	rows, err := r.db.Pool.Query(ctx, query)
+	rows, err := r.db.Pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -90,8 +108,8 @@ func (r *RoomRepository) List(ctx context.Context, includePrivate bool) ([]model
 	for rows.Next() {
 		var room model.RoomWithMembers
 		err := rows.Scan(
-			&room.ID, &room.Name, &room.Description, &room.IsPrivate,
-			&room.CreatedBy, &room.CreatedAt, &room.MemberCount,
+			&room.ID, &room.Name, &room.Description, &room.IsPrivate, &room.Kind,
+			&room.CreatedBy, &room.CreatedAt, &room.SlowModeSeconds, &room.MemberCount,
 		)
 		if err != nil {
 			return nil, err
@@ -102,26 +120,83 @@ func (r *RoomRepository) List(ctx context.Context, includePrivate bool) ([]model
 	return rooms, nil
 }
 
+// Update applies the non-nil fields of req to the room
+func (r *RoomRepository) Update(ctx context.Context, id uuid.UUID, req *model.UpdateRoomRequest) (*model.Room, error) {
+	room := &model.Room{}
+
+	query := `
+		UPDATE rooms SET
+			name = COALESCE($2, name),
+			description = COALESCE($3, description),
+			is_private = COALESCE($4, is_private),
+			slow_mode_seconds = COALESCE($5, slow_mode_seconds)
+		WHERE id = $1
+		RETURNING id, name, description, is_private, kind, created_by, created_at, slow_mode_seconds
+	`
+
+	err := r.db.Pool.QueryRow(ctx, query, id, req.Name, req.Description, req.IsPrivate, req.SlowModeSeconds).Scan(
+		&room.ID, &room.Name, &room.Description, &room.IsPrivate, &room.Kind, &room.CreatedBy, &room.CreatedAt,
+		&room.SlowModeSeconds,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
 func (r *RoomRepository) AddMember(ctx context.Context, roomID, userID uuid.UUID) error {
+	return r.AddMemberWithRole(ctx, roomID, userID, model.RoleMember)
+}
+
+// AddMemberWithRole adds a member, leaving existing members' roles untouched
+func (r *RoomRepository) AddMemberWithRole(ctx context.Context, roomID, userID uuid.UUID, role model.RoomRole) error {
 	query := `
-		INSERT INTO room_members (room_id, user_id, joined_at, last_read_at)
-		VALUES ($1, $2, $3, $3)
+		INSERT INTO room_members (room_id, user_id, role, joined_at, last_read_at)
+		VALUES ($1, $2, $3, $4, $4)
 		ON CONFLICT (room_id, user_id) DO NOTHING
 	`
 
-	_, err := r.db.Pool.Exec(ctx, query, roomID, userID, time.Now())
+	_, err := r.db.Pool.Exec(ctx, query, roomID, userID, role, time.Now())
 	return err
 }
 
+// GetRole returns a member's role, or ErrNotMember
+func (r *RoomRepository) GetRole(ctx context.Context, roomID, userID uuid.UUID) (model.RoomRole, error) {
+	query := `SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2`
+
+	var role model.RoomRole
+	err := r.db.Pool.QueryRow(ctx, query, roomID, userID).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotMember
+	}
+	return role, err
+}
+
+// SetRole changes a member's role, returning ErrNotMember if they are not in the room
+func (r *RoomRepository) SetRole(ctx context.Context, roomID, userID uuid.UUID, role model.RoomRole) error {
+	query := `UPDATE room_members SET role = $3 WHERE room_id = $1 AND user_id = $2`
+
+	tag, err := r.db.Pool.Exec(ctx, query, roomID, userID, role)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotMember
+	}
+	return nil
+}
+
 func (r *RoomRepository) RemoveMember(ctx context.Context, roomID, userID uuid.UUID) error {
 	query := `DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`
 	_, err := r.db.Pool.Exec(ctx, query, roomID, userID)
 	return err
 }
 
-func (r *RoomRepository) GetMembers(ctx context.Context, roomID uuid.UUID) ([]model.User, error) {
+func (r *RoomRepository) GetMembers(ctx context.Context, roomID uuid.UUID) ([]model.MemberWithUser, error) {
 	query := `
-		SELECT u.id, u.username, u.display_name, u.avatar_url, u.created_at, u.updated_at
+		SELECT u.id, u.username, u.display_name, u.avatar_url, u.created_at, u.updated_at,
+			   rm.role, rm.joined_at
 		FROM users u
 		INNER JOIN room_members rm ON u.id = rm.user_id
 		WHERE rm.room_id = $1
@@ -134,17 +209,20 @@ func (r *RoomRepository) GetMembers(ctx context.Context, roomID uuid.UUID) ([]mo
 	}
 	defer rows.Close()
 
-	var users []model.User
+	var members []model.MemberWithUser
 	for rows.Next() {
-		var user model.User
-		err := rows.Scan(&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL, &user.CreatedAt, &user.UpdatedAt)
+		var m model.MemberWithUser
+		err := rows.Scan(
+			&m.ID, &m.Username, &m.DisplayName, &m.AvatarURL, &m.CreatedAt, &m.UpdatedAt,
+			&m.Role, &m.JoinedAt,
+		)
 		if err != nil {
 			return nil, err
 		}
-		users = append(users, user)
+		members = append(members, m)
 	}
 
-	return users, nil
+	return members, nil
 }
 
 func (r *RoomRepository) IsMember(ctx context.Context, roomID, userID uuid.UUID) (bool, error) {
@@ -155,13 +233,22 @@ func (r *RoomRepository) IsMember(ctx context.Context, roomID, userID uuid.UUID)
 	return exists, err
 }
 
-func (r *RoomRepository) UpdateLastRead(ctx context.Context, roomID, userID uuid.UUID) error {
+// CanAccess reports whether a user may read and join a room: public rooms are
+// open to everyone, private rooms only to their members
+func (r *RoomRepository) CanAccess(ctx context.Context, roomID, userID uuid.UUID) (bool, error) {
 	query := `
-		UPDATE room_members SET last_read_at = $3
-		WHERE room_id = $1 AND user_id = $2
+		SELECT EXISTS(
+			SELECT 1 FROM rooms r
+			WHERE r.id = $1 AND (
+				r.is_private = false
+				OR EXISTS(SELECT 1 FROM room_members WHERE room_id = r.id AND user_id = $2)
+			)
+		)
 	`
-	_, err := r.db.Pool.Exec(ctx, query, roomID, userID, time.Now())
-	return err
+
+	var allowed bool
+	err := r.db.Pool.QueryRow(ctx, query, roomID, userID).Scan(&allowed)
+	return allowed, err
 }
 
 func (r *RoomRepository) GetUnreadCount(ctx context.Context, roomID, userID uuid.UUID) (int, error) {
@@ -170,6 +257,8 @@ func (r *RoomRepository) GetUnreadCount(ctx context.Context, roomID, userID uuid
 		FROM messages m
 		INNER JOIN room_members rm ON m.room_id = rm.room_id AND rm.user_id = $2
 		WHERE m.room_id = $1 AND m.created_at > rm.last_read_at
+		  AND m.thread_root_id IS NULL AND m.deleted_at IS NULL
+		  AND m.user_id IS DISTINCT FROM rm.user_id
 	`
 
 	var count int
@@ -177,43 +266,176 @@ func (r *RoomRepository) GetUnreadCount(ctx context.Context, roomID, userID uuid
 	return count, err
 }
 
-// ListWithUnread returns rooms with unread count for a specific user
-func (r *RoomRepository) ListWithUnread(ctx context.Context, userID uuid.UUID, includePrivate bool) ([]model.RoomWithMembers, error) {
-	// First get basic room list
-	rooms, err := r.List(ctx, includePrivate)
+// UnreadCounts returns the unread counts in a room of those of userIDs who
+// are members, counted as GetUnreadCount does. Non-members are left out, so
+// the result doubles as a membership check.
+func (r *RoomRepository) UnreadCounts(ctx context.Context, roomID uuid.UUID, userIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+	query := `
+		SELECT rm.user_id, COUNT(m.id)
+		FROM room_members rm
+		LEFT JOIN messages m ON m.room_id = rm.room_id AND m.created_at > rm.last_read_at
+		  AND m.thread_root_id IS NULL AND m.deleted_at IS NULL
+		  AND m.user_id IS DISTINCT FROM rm.user_id
+		WHERE rm.room_id = $1 AND rm.user_id = ANY($2::uuid[])
+		GROUP BY rm.user_id
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, roomID, userIDs)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Then get unread count for each room
-	for i := range rooms {
-		count, err := r.getUnreadCountForRoom(ctx, rooms[i].ID, userID)
-		if err != nil {
-			rooms[i].UnreadCount = 0
-		} else {
-			rooms[i].UnreadCount = count
+	counts := make(map[uuid.UUID]int)
+	for rows.Next() {
+		var userID uuid.UUID
+		var count int
+		if err := rows.Scan(&userID, &count); err != nil {
+			return nil, err
 		}
+		counts[userID] = count
+	}
+	return counts, rows.Err()
+}
+
+// ListWithUnread returns the rooms visible to a user with their unread
+// counts, counted as GetUnreadCount does. Rooms the user hasn't joined have
+// no read marker, so they count 0.
+func (r *RoomRepository) ListWithUnread(ctx context.Context, userID uuid.UUID) ([]model.RoomWithMembers, error) {
+	rooms, err := r.ListVisible(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT rm.room_id, COUNT(m.id)
+		FROM room_members rm
+		LEFT JOIN messages m ON m.room_id = rm.room_id AND m.created_at > rm.last_read_at
+		  AND m.thread_root_id IS NULL AND m.deleted_at IS NULL
+		  AND m.user_id IS DISTINCT FROM rm.user_id
+		WHERE rm.user_id = $1
+		GROUP BY rm.room_id
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[uuid.UUID]int)
+	for rows.Next() {
+		var roomID uuid.UUID
+		var count int
+		if err := rows.Scan(&roomID, &count); err != nil {
+			return nil, err
+		}
+		counts[roomID] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range rooms {
+		rooms[i].UnreadCount = counts[rooms[i].ID]
 	}
 
 	return rooms, nil
 }
 
-// getUnreadCountForRoom gets unread count for a specific room and user
-func (r *RoomRepository) getUnreadCountForRoom(ctx context.Context, roomID, userID uuid.UUID) (int, error) {
+// Private room access
+
+func (r *RoomRepository) CreateInvitation(ctx context.Context, roomID, userID, invitedBy uuid.UUID) error {
 	query := `
-		SELECT COUNT(*)
-		FROM messages m
-		WHERE m.room_id = $1 
-		AND m.created_at > COALESCE(
-			(SELECT last_read_at FROM room_members WHERE room_id = $1 AND user_id = $2),
-			'1970-01-01'::timestamp
-		)
+		INSERT INTO room_invitations (room_id, user_id, invited_by, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (room_id, user_id) DO NOTHING
 	`
+	_, err := r.db.Pool.Exec(ctx, query, roomID, userID, invitedBy, time.Now())
+	return err
+}
 
-	var count int
-	err := r.db.Pool.QueryRow(ctx, query, roomID, userID).Scan(&count)
+// ConsumeInvitation deletes a pending invitation and reports whether one existed
+func (r *RoomRepository) ConsumeInvitation(ctx context.Context, roomID, userID uuid.UUID) (bool, error) {
+	query := `DELETE FROM room_invitations WHERE room_id = $1 AND user_id = $2`
+	tag, err := r.db.Pool.Exec(ctx, query, roomID, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetInvitationsForUser lists rooms the user has been invited to
+func (r *RoomRepository) GetInvitationsForUser(ctx context.Context, userID uuid.UUID) ([]model.RoomInvitation, error) {
+	query := `
+		SELECT i.room_id, r.name, i.user_id, i.invited_by, i.created_at
+		FROM room_invitations i
+		INNER JOIN rooms r ON r.id = i.room_id
+		WHERE i.user_id = $1
+		ORDER BY i.created_at DESC
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []model.RoomInvitation
+	for rows.Next() {
+		var inv model.RoomInvitation
+		if err := rows.Scan(&inv.RoomID, &inv.RoomName, &inv.UserID, &inv.InvitedBy, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+
+	return invitations, nil
+}
+
+func (r *RoomRepository) CreateJoinRequest(ctx context.Context, roomID, userID uuid.UUID) error {
+	query := `
+		INSERT INTO room_join_requests (room_id, user_id, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (room_id, user_id) DO NOTHING
+	`
+	_, err := r.db.Pool.Exec(ctx, query, roomID, userID, time.Now())
+	return err
+}
+
+func (r *RoomRepository) GetJoinRequests(ctx context.Context, roomID uuid.UUID) ([]model.RoomJoinRequest, error) {
+	query := `
+		SELECT jr.room_id, jr.user_id, u.username, u.display_name, u.avatar_url, jr.created_at
+		FROM room_join_requests jr
+		INNER JOIN users u ON u.id = jr.user_id
+		WHERE jr.room_id = $1
+		ORDER BY jr.created_at ASC
+	`
+
+	rows, err := r.db.Pool.Query(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []model.RoomJoinRequest
+	for rows.Next() {
+		var req model.RoomJoinRequest
+		if err := rows.Scan(&req.RoomID, &req.UserID, &req.Username, &req.DisplayName, &req.AvatarURL, &req.CreatedAt); err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+
+	return requests, nil
+}
+
+// DeleteJoinRequest removes a pending request and reports whether one existed
+func (r *RoomRepository) DeleteJoinRequest(ctx context.Context, roomID, userID uuid.UUID) (bool, error) {
+	query := `DELETE FROM room_join_requests WHERE room_id = $1 AND user_id = $2`
+	tag, err := r.db.Pool.Exec(ctx, query, roomID, userID)
 	if err != nil {
-		return 0, err
+		return false, err
 	}
-	return count, nil
+	return tag.RowsAffected() > 0, nil
 }
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/model"
+	redisclient "github.com/khonE3/chat-backend/pkg/redis"
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+type SessionRepository struct {
+	redis *redisclient.Redis
+	ttl   time.Duration
+}
+
+func NewSessionRepository(redis *redisclient.Redis, ttl time.Duration) *SessionRepository {
+	return &SessionRepository{redis: redis, ttl: ttl}
+}
+
+func sessionKey(token string) string {
+	return fmt.Sprintf("chat:session:%s", token)
+}
+
+// userSessionsKey indexes a user's session tokens so they can be revoked
+// together. It may hold tokens that have already expired or been revoked.
+func userSessionsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("chat:user:%s:sessions", userID)
+}
+
+// Create issues a new opaque session token for the user
+func (r *SessionRepository) Create(ctx context.Context, user *model.User) (*model.Session, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &model.Session{
+		Token:       token,
+		UserID:      user.ID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(r.ttl),
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+
+	// The index lives as long as the user's newest session
+	pipe := r.redis.Client.TxPipeline()
+	pipe.Set(ctx, sessionKey(token), data, r.ttl)
+	pipe.SAdd(ctx, userSessionsKey(user.ID), token)
+	pipe.Expire(ctx, userSessionsKey(user.ID), r.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Get looks up a session by token
+func (r *SessionRepository) Get(ctx context.Context, token string) (*model.Session, error) {
+	data, err := r.redis.Client.Get(ctx, sessionKey(token)).Result()
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session model.Session
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, err
+	}
+	session.Token = token
+
+	return &session, nil
+}
+
+// Delete revokes a session
+func (r *SessionRepository) Delete(ctx context.Context, token string) error {
+	return r.redis.Client.Del(ctx, sessionKey(token)).Err()
+}
+
+// DeleteOthers revokes every session of the user except the one with token
+// keep, e.g. after a password change
+func (r *SessionRepository) DeleteOthers(ctx context.Context, userID uuid.UUID, keep string) error {
+	key := userSessionsKey(userID)
+
+	tokens, err := r.redis.Client.SMembers(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := r.redis.Client.TxPipeline()
+	for _, token := range tokens {
+		if token == keep {
+			continue
+		}
+		pipe.Del(ctx, sessionKey(token))
+		pipe.SRem(ctx, key, token)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -96,6 +96,34 @@ func (r *UserRepository) Update(ctx context.Context, user *model.User) error {
 	return err
 }
 
+func (r *UserRepository) GetSettings(ctx context.Context, id uuid.UUID) (*model.UserSettings, error) {
+	settings := &model.UserSettings{}
+
+	query := `SELECT read_receipts FROM users WHERE id = $1`
+
+	if err := r.db.Pool.QueryRow(ctx, query, id).Scan(&settings.ReadReceipts); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateSettings applies the fields set in req and returns the result
+func (r *UserRepository) UpdateSettings(ctx context.Context, id uuid.UUID, req *model.UpdateSettingsRequest) (*model.UserSettings, error) {
+	settings := &model.UserSettings{}
+
+	query := `
+		UPDATE users
+		SET read_receipts = COALESCE($2, read_receipts), updated_at = $3
+		WHERE id = $1
+		RETURNING read_receipts
+	`
+
+	if err := r.db.Pool.QueryRow(ctx, query, id, req.ReadReceipts, time.Now()).Scan(&settings.ReadReceipts); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
 func (r *UserRepository) GetOrCreate(ctx context.Context, req *model.CreateUserRequest) (*model.User, error) {
 	// Try to get existing user
 	user, err := r.GetByUsername(ctx, req.Username)
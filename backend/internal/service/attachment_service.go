@@ -0,0 +1,351 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+	"github.com/khonE3/chat-backend/pkg/storage"
+)
+
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrEmptyFile          = errors.New("file is empty")
+	ErrFileTooLarge       = errors.New("file is too large")
+	ErrUnsupportedType    = errors.New("file type is not allowed")
+)
+
+const (
+	thumbnailSize     = 320
+	maxThumbnailInput = 24_000_000 // pixels; larger images are stored without a thumbnail
+	maxFileNameBytes  = 255
+)
+
+type AttachmentService struct {
+	attachmentRepo *repository.AttachmentRepository
+	messageRepo    *repository.MessageRepository
+	roomService    *RoomService
+	rateLimiter    *RateLimitService
+	validator      *ContentValidator
+	storage        storage.Storage
+	maxBytes       int64
+	allowedTypes   map[string]bool
+}
+
+func NewAttachmentService(
+	attachmentRepo *repository.AttachmentRepository,
+	messageRepo *repository.MessageRepository,
+	roomService *RoomService,
+	rateLimiter *RateLimitService,
+	validator *ContentValidator,
+	store storage.Storage,
+	maxBytes int64,
+	allowedTypes []string,
+) *AttachmentService {
+	allowed := make(map[string]bool, len(allowedTypes))
+	for _, t := range allowedTypes {
+		allowed[strings.ToLower(t)] = true
+	}
+
+	return &AttachmentService{
+		attachmentRepo: attachmentRepo,
+		messageRepo:    messageRepo,
+		roomService:    roomService,
+		rateLimiter:    rateLimiter,
+		validator:      validator,
+		storage:        store,
+		maxBytes:       maxBytes,
+		allowedTypes:   allowed,
+	}
+}
+
+// Upload is a file to post to a room
+type Upload struct {
+	FileName string
+	Size     int64
+	Body     io.ReadSeeker
+	Caption  string
+	Nonce    string
+}
+
+// Upload stores a file and posts it to the room as an attachment message.
+// The content type is sniffed from the file itself; the client's claim is
+// ignored. Like SendMessage, resending a nonce returns the stored message
+// with duplicate set, and uploads count against the same rate limits.
+func (s *AttachmentService) Upload(ctx context.Context, roomID, userID uuid.UUID, up *Upload) (*model.MessageWithUser, bool, error) {
+	if up.Size <= 0 {
+		return nil, false, ErrEmptyFile
+	}
+	if up.Size > s.maxBytes {
+		return nil, false, ErrFileTooLarge
+	}
+	if len(up.Nonce) > maxNonceBytes {
+		return nil, false, ErrInvalidNonce
+	}
+	caption, err := s.validator.Caption(up.Caption)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if existing, err := findDuplicate(ctx, s.messageRepo, roomID, userID, up.Nonce); existing != nil || err != nil {
+		return existing, existing != nil, err
+	}
+
+	role, err := s.roomService.Authorize(ctx, roomID, userID, model.PermPost)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := s.rateLimiter.AllowPost(ctx, roomID, userID, role); err != nil {
+		return nil, false, err
+	}
+
+	msg, duplicate, err := s.store(ctx, roomID, userID, caption, up)
+	if err != nil || duplicate {
+		s.rateLimiter.CancelPost(ctx, roomID, userID)
+	}
+	return msg, duplicate, err
+}
+
+// store saves the file and its thumbnail and posts the attachment message
+func (s *AttachmentService) store(ctx context.Context, roomID, userID uuid.UUID, caption string, up *Upload) (*model.MessageWithUser, bool, error) {
+	contentType, err := sniffContentType(up.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	if !s.allowedTypes[contentType] {
+		return nil, false, ErrUnsupportedType
+	}
+
+	att := &model.Attachment{
+		ID:          uuid.New(),
+		MessageID:   uuid.New(),
+		RoomID:      roomID,
+		UserID:      &userID,
+		FileName:    cleanFileName(up.FileName),
+		ContentType: contentType,
+		SizeBytes:   up.Size,
+	}
+	att.StorageKey = fmt.Sprintf("%s/%s", roomID, att.ID)
+
+	if err := s.storage.Put(ctx, att.StorageKey, up.Body, up.Size, contentType); err != nil {
+		return nil, false, err
+	}
+
+	if isThumbnailable(contentType) {
+		s.addThumbnail(ctx, att, up.Body)
+	}
+
+	var noncePtr *string
+	if up.Nonce != "" {
+		noncePtr = &up.Nonce
+	}
+
+	msg, err := s.messageRepo.CreateWithAttachment(ctx, userID, caption, att, noncePtr)
+	if err != nil {
+		s.deleteBlobs(att)
+	}
+	if errors.Is(err, repository.ErrDuplicateNonce) {
+		existing, err := resolveDuplicate(ctx, s.messageRepo, roomID, userID, up.Nonce)
+		return existing, err == nil, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return msg, false, nil
+}
+
+// Open returns an attachment, or its thumbnail, for download. Attachments
+// of deleted messages and of rooms the viewer can't read are reported as
+// not found.
+func (s *AttachmentService) Open(ctx context.Context, id, viewerID uuid.UUID, thumbnail bool) (*model.Attachment, io.ReadCloser, error) {
+	att, deletedAt, err := s.attachmentRepo.GetByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if deletedAt != nil {
+		return nil, nil, ErrAttachmentNotFound
+	}
+
+	allowed, err := s.roomService.CanAccess(ctx, att.RoomID, viewerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !allowed {
+		return nil, nil, ErrAttachmentNotFound
+	}
+
+	key := att.StorageKey
+	if thumbnail {
+		if att.ThumbnailKey == nil {
+			return nil, nil, ErrAttachmentNotFound
+		}
+		key = *att.ThumbnailKey
+	}
+
+	body, err := s.storage.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return att, body, nil
+}
+
+// addThumbnail records the image's dimensions and stores a JPEG thumbnail.
+// A failure here only costs the thumbnail, the upload still goes through.
+func (s *AttachmentService) addThumbnail(ctx context.Context, att *model.Attachment, body io.ReadSeeker) {
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	cfg, _, err := image.DecodeConfig(body)
+	if err != nil {
+		log.Printf("⚠️ Failed to read image %s: %v", att.ID, err)
+		return
+	}
+	att.Width, att.Height = &cfg.Width, &cfg.Height
+
+	if cfg.Width*cfg.Height > maxThumbnailInput {
+		return
+	}
+
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	img, _, err := image.Decode(body)
+	if err != nil {
+		log.Printf("⚠️ Failed to decode image %s: %v", att.ID, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumbnailOf(img, thumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		log.Printf("⚠️ Failed to encode thumbnail %s: %v", att.ID, err)
+		return
+	}
+
+	key := att.StorageKey + "_thumb"
+	if err := s.storage.Put(ctx, key, &buf, int64(buf.Len()), "image/jpeg"); err != nil {
+		log.Printf("⚠️ Failed to store thumbnail %s: %v", att.ID, err)
+		return
+	}
+	att.ThumbnailKey = &key
+}
+
+func (s *AttachmentService) deleteBlobs(att *model.Attachment) {
+	ctx := context.Background()
+	if err := s.storage.Delete(ctx, att.StorageKey); err != nil {
+		log.Printf("⚠️ Failed to delete attachment %s: %v", att.StorageKey, err)
+	}
+	if att.ThumbnailKey != nil {
+		if err := s.storage.Delete(ctx, *att.ThumbnailKey); err != nil {
+			log.Printf("⚠️ Failed to delete thumbnail %s: %v", *att.ThumbnailKey, err)
+		}
+	}
+}
+
+// sniffContentType detects the MIME type from the first 512 bytes and
+// rewinds body
+func sniffContentType(body io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(body, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	contentType := http.DetectContentType(head[:n])
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.TrimSpace(contentType), nil
+}
+
+func isThumbnailable(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// cleanFileName keeps only the base name, valid UTF-8 and at most
+// maxFileNameBytes
+func cleanFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.ToValidUTF8(strings.TrimSpace(name), "")
+	for len(name) > maxFileNameBytes {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// thumbnailOf scales img to fit within size x size pixels, averaging up to
+// 4x4 samples under each target pixel. Transparent areas become white.
+func thumbnailOf(img image.Image, size int) image.Image {
+	src := img.Bounds()
+	w, h := src.Dx(), src.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(h*size/w, 1)
+		} else {
+			w, h = max(w*size/h, 1), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := src.Min.Y + y*src.Dy()/h
+		y1 := max(src.Min.Y+(y+1)*src.Dy()/h, y0+1)
+		ystep := max((y1-y0+3)/4, 1)
+
+		for x := 0; x < w; x++ {
+			x0 := src.Min.X + x*src.Dx()/w
+			x1 := max(src.Min.X+(x+1)*src.Dx()/w, x0+1)
+			xstep := max((x1-x0+3)/4, 1)
+
+			var r, g, b, n uint32
+			for sy := y0; sy < y1; sy += ystep {
+				for sx := x0; sx < x1; sx += xstep {
+					// Premultiplied, so compositing over white adds the missing alpha
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += cr + 0xffff - ca
+					g += cg + 0xffff - ca
+					b += cb + 0xffff - ca
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), 255})
+		}
+	}
+
+	return dst
+}
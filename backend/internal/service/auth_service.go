@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidCredentials  = errors.New("invalid username or password")
+	ErrPasswordTooShort    = errors.New("password must be at least 8 characters")
+	ErrPasswordTooLong     = errors.New("password must be at most 72 bytes")
+	ErrNicknameLoginDenied = errors.New("nickname-only login is disabled")
+)
+
+// AccountLockedError is returned while an account is locked after too many
+// failed login attempts
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return "account locked until " + e.Until.Format(time.RFC3339)
+}
+
+const (
+	minPasswordLength = 8
+	maxPasswordBytes  = 72 // bcrypt ignores anything beyond this
+)
+
+// dummyHash is compared against when the username does not exist, so unknown
+// and known usernames take the same time to reject
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+type AuthService struct {
+	userRepo           *repository.UserRepository
+	credentialRepo     *repository.CredentialRepository
+	maxLoginAttempts   int
+	loginLockout       time.Duration
+	allowNicknameLogin bool
+}
+
+func NewAuthService(
+	userRepo *repository.UserRepository,
+	credentialRepo *repository.CredentialRepository,
+	maxLoginAttempts int,
+	loginLockout time.Duration,
+	allowNicknameLogin bool,
+) *AuthService {
+	return &AuthService{
+		userRepo:           userRepo,
+		credentialRepo:     credentialRepo,
+		maxLoginAttempts:   maxLoginAttempts,
+		loginLockout:       loginLockout,
+		allowNicknameLogin: allowNicknameLogin,
+	}
+}
+
+// NicknameLoginEnabled reports whether the demo nickname-only flow is allowed
+func (s *AuthService) NicknameLoginEnabled() bool {
+	return s.allowNicknameLogin
+}
+
+func (s *AuthService) Register(ctx context.Context, req *model.RegisterRequest) (*model.User, error) {
+	if err := validatePassword(req.Password); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.credentialRepo.Register(ctx, &model.CreateUserRequest{
+		Username:    req.Username,
+		DisplayName: req.DisplayName,
+	}, string(hash))
+}
+
+// Login verifies a username and password, enforcing account lockout
+func (s *AuthService) Login(ctx context.Context, username, password string) (*model.User, error) {
+	user, err := s.userRepo.GetByUsername(ctx, username)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
+	cred, err := s.credentialRepo.GetByUserID(ctx, user.ID)
+	if errors.Is(err, repository.ErrCredentialNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkPassword(ctx, cred, password); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// checkPassword compares a password against the user's credential. Wrong
+// guesses count towards the lockout, which applies to logins and password
+// changes alike, so a stolen session can't be used to guess the password.
+func (s *AuthService) checkPassword(ctx context.Context, cred *model.UserCredential, password string) error {
+	if cred.LockedUntil != nil && cred.LockedUntil.After(time.Now()) {
+		return &AccountLockedError{Until: *cred.LockedUntil}
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(cred.PasswordHash), []byte(password)); err != nil {
+		lockedUntil, err := s.credentialRepo.RecordFailure(ctx, cred.UserID, s.maxLoginAttempts, s.loginLockout)
+		if err != nil {
+			return err
+		}
+		if lockedUntil != nil && lockedUntil.After(time.Now()) {
+			return &AccountLockedError{Until: *lockedUntil}
+		}
+		return ErrInvalidCredentials
+	}
+
+	return s.credentialRepo.ResetFailures(ctx, cred.UserID)
+}
+
+// NicknameLogin signs in by username alone for demo deployments. Accounts that
+// have a password can never be entered this way.
+func (s *AuthService) NicknameLogin(ctx context.Context, req *model.CreateUserRequest) (*model.User, error) {
+	if !s.allowNicknameLogin {
+		return nil, ErrNicknameLoginDenied
+	}
+
+	user, err := s.userRepo.GetOrCreate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	hasPassword, err := s.credentialRepo.HasCredential(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if hasPassword {
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+func (s *AuthService) ChangePassword(ctx context.Context, userID uuid.UUID, req *model.ChangePasswordRequest) error {
+	cred, err := s.credentialRepo.GetByUserID(ctx, userID)
+	if errors.Is(err, repository.ErrCredentialNotFound) {
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkPassword(ctx, cred, req.CurrentPassword); err != nil {
+		return err
+	}
+
+	if err := validatePassword(req.NewPassword); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.credentialRepo.UpdatePassword(ctx, userID, string(hash))
+}
+
+func validatePassword(password string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if len(password) > maxPasswordBytes {
+		return ErrPasswordTooLong
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/khonE3/chat-backend/internal/model"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Defaults for rule settings left at zero
+const (
+	defaultMinLetters   = 10
+	defaultMaxCapsRatio = 0.7
+	defaultRepeatWindow = 60
+)
+
+// automodLinkPattern finds links with a scheme or starting with www., which
+// is how links are written often enough that skipping them would be an easy
+// way around a links rule
+var automodLinkPattern = regexp.MustCompile("(?i)(?:https?://|www\\.)[^\\s<>\"'`\\p{Thai}]+")
+
+// span is a byte range [start, end) of the original message content
+type span struct{ start, end int }
+
+// foldedText is message content folded for blocklist matching. Every folded
+// rune remembers the bytes of the original rune it came from, so matches can
+// be masked in the original.
+type foldedText struct {
+	runes []rune
+	spans []span
+}
+
+// foldText lower-cases s and applies NFKC, which also turns Thai sara am
+// (ำ) into nikhahit and sara aa (ํา), the two ways it gets typed. Thai digits
+// become ASCII digits and zero-width characters are dropped. With lettersOnly
+// everything but letters, marks and digits is dropped as well, so spaces or
+// dots slipped between the letters of a word don't hide it.
+func foldText(s string, lettersOnly bool) foldedText {
+	var f foldedText
+	for i, r := range s {
+		size := utf8.RuneLen(r)
+		if size < 0 {
+			size = 1
+		}
+		if isZeroWidth(r) {
+			continue
+		}
+		if r >= '๐' && r <= '๙' {
+			r = '0' + (r - '๐')
+		}
+
+		for _, fr := range norm.NFKC.String(string(r)) {
+			fr = unicode.ToLower(fr)
+			if lettersOnly && !isWordRune(fr) {
+				continue
+			}
+			f.runes = append(f.runes, fr)
+			f.spans = append(f.spans, span{i, i + size})
+		}
+	}
+	return f
+}
+
+// foldWord folds a blocklist entry the same way as the text it is matched in
+func foldWord(word string, lettersOnly bool) []rune {
+	return foldText(word, lettersOnly).runes
+}
+
+func isZeroWidth(r rune) bool {
+	switch r {
+	case '\u200B', '\u200C', '\u200D', '\u2060', '\uFEFF':
+		return true
+	}
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r)
+}
+
+func hasThai(word string) bool {
+	for _, r := range word {
+		if unicode.Is(unicode.Thai, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the original byte spans of every occurrence of word in f.
+// With wholeWord an occurrence must not be preceded or followed by a letter
+// or digit.
+func (f foldedText) find(word []rune, wholeWord bool) []span {
+	var found []span
+	if len(word) == 0 {
+		return nil
+	}
+
+	for i := 0; i+len(word) <= len(f.runes); i++ {
+		if !runesEqual(f.runes[i:i+len(word)], word) {
+			continue
+		}
+		end := i + len(word)
+		if wholeWord && ((i > 0 && isWordRune(f.runes[i-1])) || (end < len(f.runes) && isWordRune(f.runes[end]))) {
+			continue
+		}
+		found = append(found, span{f.spans[i].start, f.spans[end-1].end})
+		i = end - 1
+	}
+	return found
+}
+
+func runesEqual(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// automodMessage is a message being checked, with the folded forms rules
+// share worked out once
+type automodMessage struct {
+	content  string
+	spaced   foldedText // folded, separators kept
+	skeleton foldedText // folded, letters and digits only
+}
+
+func newAutomodMessage(content string) *automodMessage {
+	return &automodMessage{
+		content:  content,
+		spaced:   foldText(content, false),
+		skeleton: foldText(content, true),
+	}
+}
+
+// digest identifies the message for repeat detection, ignoring case,
+// spacing and punctuation
+func (m *automodMessage) digest() string {
+	sum := sha256.Sum256([]byte(string(m.skeleton.runes)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// compiledRule is a rule ready to run against messages
+type compiledRule struct {
+	rule model.AutomodRule
+
+	// Blocklist entries. Thai is written without spaces between words, so
+	// Thai entries match anywhere in the letters-only skeleton; others match
+	// whole words only, so "ass" doesn't catch "class".
+	thaiWords  [][]rune
+	otherWords [][]rune
+
+	pattern *regexp.Regexp
+}
+
+func compileRule(rule model.AutomodRule) (*compiledRule, error) {
+	c := &compiledRule{rule: rule}
+
+	switch rule.Kind {
+	case model.AutomodBlocklist:
+		for _, word := range rule.Words {
+			if hasThai(word) {
+				c.thaiWords = append(c.thaiWords, foldWord(word, true))
+			} else {
+				c.otherWords = append(c.otherWords, foldWord(word, false))
+			}
+		}
+	case model.AutomodRegex:
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		c.pattern = pattern
+	}
+	return c, nil
+}
+
+// match reports whether the message trips the rule and, for rules that
+// point at text, which parts of it did. Repeat rules need shared state and
+// are checked by AutomodService instead.
+func (c *compiledRule) match(msg *automodMessage) (bool, []span) {
+	switch c.rule.Kind {
+	case model.AutomodBlocklist:
+		var spans []span
+		for _, word := range c.thaiWords {
+			spans = append(spans, msg.skeleton.find(word, false)...)
+		}
+		for _, word := range c.otherWords {
+			spans = append(spans, msg.spaced.find(word, true)...)
+		}
+		return len(spans) > 0, spans
+
+	case model.AutomodRegex:
+		var spans []span
+		for _, loc := range c.pattern.FindAllStringIndex(msg.content, -1) {
+			if loc[1] > loc[0] {
+				spans = append(spans, span{loc[0], loc[1]})
+			}
+		}
+		return len(spans) > 0, spans
+
+	case model.AutomodLinks:
+		spans := c.matchLinks(msg.content)
+		return len(spans) > 0, spans
+
+	case model.AutomodCaps:
+		return c.matchCaps(msg.content), nil
+
+	case model.AutomodSpam:
+		return c.matchSpam(msg.content), nil
+	}
+	return false, nil
+}
+
+// matchLinks returns the links that break the rule: those outside the
+// allowed domains, or those inside the denied ones
+func (c *compiledRule) matchLinks(content string) []span {
+	var spans []span
+	for _, loc := range automodLinkPattern.FindAllStringIndex(content, -1) {
+		listed := c.listedDomain(content[loc[0]:loc[1]])
+		if listed == (c.rule.LinkMode == model.LinkDeny) {
+			spans = append(spans, span{loc[0], loc[1]})
+		}
+	}
+	return spans
+}
+
+func (c *compiledRule) listedDomain(link string) bool {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		// A link that can't be parsed is on no list
+		return false
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, domain := range c.rule.Domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCaps looks only at letters that have case, so Thai text neither
+// counts as shouting nor dilutes Latin text that is
+func (c *compiledRule) matchCaps(content string) bool {
+	minLetters := c.rule.MinLetters
+	if minLetters == 0 {
+		minLetters = defaultMinLetters
+	}
+	maxRatio := c.rule.MaxCapsRatio
+	if maxRatio == 0 {
+		maxRatio = defaultMaxCapsRatio
+	}
+
+	var upper, cased int
+	for _, r := range content {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+			cased++
+		case unicode.IsLower(r):
+			cased++
+		}
+	}
+	return cased >= minLetters && float64(upper)/float64(cased) > maxRatio
+}
+
+func (c *compiledRule) matchSpam(content string) bool {
+	if c.rule.MaxMentions > 0 {
+		usernames, here, room := ParseMentions(content)
+		mentions := len(usernames)
+		if here {
+			mentions++
+		}
+		if room {
+			mentions++
+		}
+		if mentions > c.rule.MaxMentions {
+			return true
+		}
+	}
+
+	if c.rule.MaxLines > 0 && strings.Count(content, "\n")+1 > c.rule.MaxLines {
+		return true
+	}
+
+	if c.rule.MaxRunLength > 0 {
+		var last rune
+		run := 0
+		for _, r := range content {
+			if r == last {
+				run++
+			} else {
+				last, run = r, 1
+			}
+			if run > c.rule.MaxRunLength && !unicode.IsSpace(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// maskSpans stars out the given parts of content, one star per character
+// as it is seen: Thai vowel and tone marks above or below a letter are
+// dropped rather than starred, and whitespace is kept
+func maskSpans(content string, spans []span) string {
+	masked := make([]bool, len(content))
+	for _, s := range spans {
+		for i := s.start; i < s.end && i < len(content); i++ {
+			masked[i] = true
+		}
+	}
+
+	var b strings.Builder
+	b.Grow(len(content))
+	for i, r := range content {
+		switch {
+		case !masked[i], unicode.IsSpace(r):
+			b.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// dropped with the letter it sits on
+		default:
+			b.WriteByte('*')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/khonE3/chat-backend/internal/model"
+)
+
+func TestFoldText(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		lettersOnly bool
+		want        string
+	}{
+		{"lower-cases", "HeLLo", false, "hello"},
+		{"full-width", "ＨＥＬＬＯ", false, "hello"},
+		{"thai digits", "๑๒๓", false, "123"},
+		{"zero-width dropped", "ba\u200bd", false, "bad"},
+		{"separators kept", "b.a d", false, "b.a d"},
+		{"separators dropped", "b.a d", true, "bad"},
+		{"sara am", "ทำ", false, "ทํา"},
+		{"nikhahit and sara aa", "ทํา", false, "ทํา"},
+		{"thai marks kept", "เหี้ย", true, "เหี้ย"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(foldText(tt.in, tt.lettersOnly).runes); got != tt.want {
+				t.Errorf("foldText(%q, %v) = %q, want %q", tt.in, tt.lettersOnly, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlocklistMatchAndMask(t *testing.T) {
+	tests := []struct {
+		name    string
+		words   []string
+		content string
+		matched bool
+		masked  string
+	}{
+		{"whole word", []string{"bad"}, "this is BAD!", true, "this is ***!"},
+		{"inside a longer word", []string{"bad"}, "badge", false, "badge"},
+		{"full-width", []string{"bad"}, "ｂａｄ", true, "***"},
+		{"every occurrence", []string{"bad"}, "bad and bad", true, "*** and ***"},
+		{"thai inside a sentence", []string{"ควาย"}, "ไอ้ควายเอ๊ย", true, "ไอ้****เอ๊ย"},
+		{"thai split by dots", []string{"ควาย"}, "ค.ว.า.ย", true, "*******"},
+		{"thai split by spaces", []string{"ควาย"}, "ค ว า ย", true, "* * * *"},
+		{"thai split by zero-width", []string{"ควาย"}, "คว\u200bาย", true, "*****"},
+		{"tone marks dropped when masked", []string{"เหี้ย"}, "เหี้ย", true, "***"},
+		{"sara am typed as two marks", []string{"ทำ"}, "ทํา", true, "**"},
+		{"sara am typed as one", []string{"ทํา"}, "ทำ", true, "**"},
+		{"thai digits", []string{"123"}, "๑๒๓", true, "***"},
+		{"clean thai", []string{"ควาย"}, "สวัสดีครับ", false, "สวัสดีครับ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := compileRule(model.AutomodRule{Kind: model.AutomodBlocklist, Words: tt.words})
+			if err != nil {
+				t.Fatalf("compileRule: %v", err)
+			}
+
+			matched, spans := rule.match(newAutomodMessage(tt.content))
+			if matched != tt.matched {
+				t.Fatalf("match(%q) = %v, want %v", tt.content, matched, tt.matched)
+			}
+			if got := maskSpans(tt.content, spans); got != tt.masked {
+				t.Errorf("maskSpans(%q) = %q, want %q", tt.content, got, tt.masked)
+			}
+		})
+	}
+}
+
+func TestMaskSpans(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		spans   []span
+		want    string
+	}{
+		{"nothing", "hello", nil, "hello"},
+		{"one word", "hello world", []span{{0, 5}}, "***** world"},
+		{"whitespace kept", "a b", []span{{0, 3}}, "* *"},
+		{"span past the end", "abc", []span{{1, 10}}, "a**"},
+		{"thai above and below marks", "ที่", []span{{0, len("ที่")}}, "*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maskSpans(tt.content, tt.spans); got != tt.want {
+				t.Errorf("maskSpans(%q, %v) = %q, want %q", tt.content, tt.spans, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,628 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+)
+
+var (
+	ErrAutomod      = errors.New("message blocked by automod")
+	ErrInvalidRule  = errors.New("invalid automod rule")
+	ErrRuleNotFound = errors.New("automod rule not found")
+	ErrTooManyRules = errors.New("too many automod rules")
+	ErrHeldNotFound = errors.New("held message not found")
+
+	// ErrSenderCannotPost wraps the reason an approved message's sender can
+	// no longer post in its room
+	ErrSenderCannotPost = errors.New("sender can no longer post in this room")
+)
+
+const (
+	maxRulesPerScope     = 50
+	maxRuleNameRunes     = 100
+	maxBlocklistWords    = 1000
+	maxBlocklistRunes    = 100
+	maxRulePatternLen    = 500
+	maxRuleDomains       = 200
+	maxRepeatWindow      = 24 * 60 * 60
+	automodResync        = time.Minute
+	automodRepeatTimeout = 2 * time.Second
+)
+
+// AutomodError reports that automod stopped a message. It matches
+// ErrAutomod.
+type AutomodError struct {
+	Action model.AutomodAction
+	Rule   string             // name of the rule that tripped
+	Held   *model.HeldMessage // set when the message was held for review
+	Mute   *ModerationResult  // set when the sender was timed out, to announce
+}
+
+func (e *AutomodError) Error() string {
+	switch e.Action {
+	case model.AutomodHold:
+		return "Your message is waiting for a moderator to review it"
+	case model.AutomodMute:
+		return fmt.Sprintf("Message blocked by automod (%s), you have been timed out", e.Rule)
+	}
+	return fmt.Sprintf("Message blocked by automod (%s)", e.Rule)
+}
+
+func (e *AutomodError) Is(target error) bool {
+	return target == ErrAutomod
+}
+
+// automodRuleSet is the compiled rules in force, swapped whole on reload
+type automodRuleSet struct {
+	global []*compiledRule
+	rooms  map[uuid.UUID][]*compiledRule
+}
+
+// AutomodService screens messages against global and per-room rules before
+// they are stored. Rules live in PostgreSQL; every instance keeps a compiled
+// copy that is reloaded whenever any instance changes a rule.
+type AutomodService struct {
+	automodRepo    *repository.AutomodRepository
+	moderationRepo *repository.ModerationRepository
+	messageRepo    *repository.MessageRepository
+	userRepo       *repository.UserRepository
+	roomService    *RoomService
+	admins         map[uuid.UUID]bool
+
+	rules atomic.Pointer[automodRuleSet]
+}
+
+func NewAutomodService(
+	automodRepo *repository.AutomodRepository,
+	moderationRepo *repository.ModerationRepository,
+	messageRepo *repository.MessageRepository,
+	userRepo *repository.UserRepository,
+	roomService *RoomService,
+	adminIDs []uuid.UUID,
+) *AutomodService {
+	admins := make(map[uuid.UUID]bool, len(adminIDs))
+	for _, id := range adminIDs {
+		admins[id] = true
+	}
+
+	s := &AutomodService{
+		automodRepo:    automodRepo,
+		moderationRepo: moderationRepo,
+		messageRepo:    messageRepo,
+		userRepo:       userRepo,
+		roomService:    roomService,
+		admins:         admins,
+	}
+	s.rules.Store(&automodRuleSet{rooms: map[uuid.UUID][]*compiledRule{}})
+	return s
+}
+
+// Watch loads the rules and reloads them whenever they change. A periodic
+// reload covers changes published while the subscription was reconnecting.
+func (s *AutomodService) Watch() {
+	ctx := context.Background()
+	pubsub := s.automodRepo.SubscribeChanges(ctx)
+	defer pubsub.Close()
+
+	s.reload(ctx)
+
+	ticker := time.NewTicker(automodResync)
+	defer ticker.Stop()
+
+	changes := pubsub.Channel()
+	for {
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+			s.reload(ctx)
+		case <-ticker.C:
+			s.reload(ctx)
+		}
+	}
+}
+
+func (s *AutomodService) reload(ctx context.Context) {
+	rules, err := s.automodRepo.ListRules(ctx)
+	if err != nil {
+		log.Printf("⚠️ Failed to load automod rules, keeping the previous set: %v", err)
+		return
+	}
+
+	set := &automodRuleSet{rooms: map[uuid.UUID][]*compiledRule{}}
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		compiled, err := compileRule(rule)
+		if err != nil {
+			log.Printf("⚠️ Skipping automod rule %s: %v", rule.ID, err)
+			continue
+		}
+		if rule.RoomID == nil {
+			set.global = append(set.global, compiled)
+		} else {
+			set.rooms[*rule.RoomID] = append(set.rooms[*rule.RoomID], compiled)
+		}
+	}
+	s.rules.Store(set)
+}
+
+// automodVerdict is the outcome of screening a message
+type automodVerdict struct {
+	action  model.AutomodAction // empty when the message passes
+	rule    *model.AutomodRule  // the rule behind the action
+	content string              // the content with any masks applied
+}
+
+// screen runs the rules for the room over content. Moderators and above are
+// not screened. Repeat rules count the message only when countRepeats is
+// set, so edits don't add to the tally.
+func (s *AutomodService) screen(ctx context.Context, roomID, userID uuid.UUID, role model.RoomRole, content string, countRepeats bool) automodVerdict {
+	verdict := automodVerdict{content: content}
+	if role.Can(model.PermDeleteMessages) {
+		return verdict
+	}
+
+	set := s.rules.Load()
+	rules := append(append([]*compiledRule{}, set.global...), set.rooms[roomID]...)
+	if len(rules) == 0 {
+		return verdict
+	}
+
+	msg := newAutomodMessage(content)
+	var masks []span
+	for _, c := range rules {
+		var tripped bool
+		var spans []span
+		if c.rule.Kind == model.AutomodRepeat {
+			if !countRepeats {
+				continue
+			}
+			tripped = s.repeated(ctx, roomID, userID, msg, &c.rule)
+		} else {
+			tripped, spans = c.match(msg)
+		}
+		if !tripped {
+			continue
+		}
+
+		if c.rule.Action == model.AutomodMask {
+			masks = append(masks, spans...)
+		}
+		if c.rule.Action.Severity() > verdict.action.Severity() {
+			verdict.action = c.rule.Action
+			verdict.rule = &c.rule
+		}
+	}
+
+	if len(masks) > 0 {
+		verdict.content = maskSpans(content, masks)
+	}
+	return verdict
+}
+
+// repeated counts the message towards a repeat rule. It fails open, like
+// rate limits, when Redis can't be reached.
+func (s *AutomodService) repeated(ctx context.Context, roomID, userID uuid.UUID, msg *automodMessage, rule *model.AutomodRule) bool {
+	window := rule.WindowSeconds
+	if window == 0 {
+		window = defaultRepeatWindow
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, automodRepeatTimeout)
+	defer cancel()
+
+	count, err := s.automodRepo.CountRepeat(ctx, roomID, userID, msg.digest(), time.Duration(window)*time.Second)
+	if err != nil {
+		log.Printf("⚠️ Automod repeat check failed, allowing message: %v", err)
+		return false
+	}
+	return count > int64(rule.MaxRepeats)
+}
+
+// Apply screens a message about to be sent. It returns the content to
+// store, masked if a rule said so, or an *AutomodError when the message was
+// rejected, held for review or got the sender timed out. A retry of a held
+// message (same nonce) gets the same answer without being screened again.
+func (s *AutomodService) Apply(ctx context.Context, roomID, userID uuid.UUID, role model.RoomRole, content string, replyToID *uuid.UUID, nonce string) (string, error) {
+	if nonce != "" {
+		held, err := s.automodRepo.GetHeldByNonce(ctx, userID, nonce)
+		if err == nil {
+			return "", heldError(held)
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return "", err
+		}
+	}
+
+	verdict := s.screen(ctx, roomID, userID, role, content, true)
+
+	switch verdict.action {
+	case model.AutomodHold:
+		held := &model.HeldMessage{
+			ID:        uuid.New(),
+			RoomID:    roomID,
+			UserID:    userID,
+			Content:   verdict.content,
+			ReplyToID: replyToID,
+			RuleID:    verdict.rule.ID,
+			RuleName:  verdict.rule.Name,
+			Status:    model.HeldPending,
+			CreatedAt: time.Now().UTC(),
+		}
+		if nonce != "" {
+			held.Nonce = &nonce
+		}
+		err := s.automodRepo.Hold(ctx, held)
+		if errors.Is(err, repository.ErrDuplicateNonce) {
+			// Held by a concurrent retry
+			if held, err = s.automodRepo.GetHeldByNonce(ctx, userID, nonce); err == nil {
+				return "", heldError(held)
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", heldError(held)
+
+	case model.AutomodMute:
+		result, err := s.timeOut(ctx, roomID, userID, verdict.rule)
+		if err != nil {
+			return "", err
+		}
+		return "", &AutomodError{Action: verdict.action, Rule: verdict.rule.Name, Mute: result}
+
+	case model.AutomodReject:
+		return "", &AutomodError{Action: verdict.action, Rule: verdict.rule.Name}
+	}
+
+	return verdict.content, nil
+}
+
+// heldError answers a send that was held. Once a moderator rejected it,
+// retries are rejected too; approved ones were posted with the nonce, so
+// ChatService answers those as duplicates before automod is asked.
+func heldError(held *model.HeldMessage) *AutomodError {
+	if held.Status == model.HeldRejected {
+		return &AutomodError{Action: model.AutomodReject, Rule: held.RuleName}
+	}
+	return &AutomodError{Action: model.AutomodHold, Rule: held.RuleName, Held: held}
+}
+
+// ApplyEdit screens the new content of an edited message. Edits can't be
+// held or punished after the fact, so anything stronger than a mask just
+// rejects the edit.
+func (s *AutomodService) ApplyEdit(ctx context.Context, roomID, userID uuid.UUID, role model.RoomRole, content string) (string, error) {
+	verdict := s.screen(ctx, roomID, userID, role, content, false)
+	if verdict.action.Severity() > model.AutomodMask.Severity() {
+		return "", &AutomodError{Action: model.AutomodReject, Rule: verdict.rule.Name}
+	}
+	return verdict.content, nil
+}
+
+// timeOut mutes the sender for the rule's mute_seconds and records a system
+// message. There is no moderator behind it, so the event has no actor.
+func (s *AutomodService) timeOut(ctx context.Context, roomID, userID uuid.UUID, rule *model.AutomodRule) (*ModerationResult, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	duration := time.Duration(rule.MuteSeconds) * time.Second
+	expiresAt := now.Add(duration)
+	reason := "automod: " + rule.Name
+
+	err = s.moderationRepo.Restrict(ctx, &model.RoomRestriction{
+		ID:        uuid.New(),
+		RoomID:    roomID,
+		UserID:    userID,
+		Kind:      model.RestrictionTimeout,
+		Reason:    &reason,
+		CreatedAt: now,
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("Automod timed out %s for %s: %s", user.DisplayName, formatDuration(duration), rule.Name)
+	msg, err := s.messageRepo.CreateSystem(ctx, roomID, text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ModerationResult{
+		Event: model.ModerationPayload{
+			RoomID:    roomID,
+			UserID:    userID,
+			Action:    model.ModerationTimeout,
+			Automod:   true,
+			Reason:    &reason,
+			ExpiresAt: &expiresAt,
+		},
+		SystemMessage: msg,
+	}, nil
+}
+
+// ListRules returns the rules of a room, or the global rules when roomID
+// is nil
+func (s *AutomodService) ListRules(ctx context.Context, actorID uuid.UUID, roomID *uuid.UUID) ([]model.AutomodRule, error) {
+	if err := s.authorizeRules(ctx, actorID, roomID); err != nil {
+		return nil, err
+	}
+
+	return s.automodRepo.ListScopeRules(ctx, roomID)
+}
+
+// CreateRule adds a rule to a room, or a global one when roomID is nil.
+// Room rules need manage_room in the room; global rules need a site admin.
+func (s *AutomodService) CreateRule(ctx context.Context, actorID uuid.UUID, roomID *uuid.UUID, req *model.AutomodRuleRequest) (*model.AutomodRule, error) {
+	if err := s.authorizeRules(ctx, actorID, roomID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	rule := &model.AutomodRule{
+		ID:        uuid.New(),
+		RoomID:    roomID,
+		CreatedBy: actorID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := applyRuleRequest(rule, req); err != nil {
+		return nil, err
+	}
+
+	err := s.automodRepo.CreateRule(ctx, rule, maxRulesPerScope)
+	if errors.Is(err, repository.ErrTooManyRules) {
+		return nil, ErrTooManyRules
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// UpdateRule replaces the settings of a rule, keeping its scope
+func (s *AutomodService) UpdateRule(ctx context.Context, actorID, ruleID uuid.UUID, req *model.AutomodRuleRequest) (*model.AutomodRule, error) {
+	rule, err := s.getRule(ctx, actorID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyRuleRequest(rule, req); err != nil {
+		return nil, err
+	}
+	rule.UpdatedAt = time.Now().UTC()
+
+	err = s.automodRepo.UpdateRule(ctx, rule)
+	if errors.Is(err, repository.ErrRuleNotFound) {
+		return nil, ErrRuleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// DeleteRule removes a rule
+func (s *AutomodService) DeleteRule(ctx context.Context, actorID, ruleID uuid.UUID) error {
+	if _, err := s.getRule(ctx, actorID, ruleID); err != nil {
+		return err
+	}
+
+	deleted, err := s.automodRepo.DeleteRule(ctx, ruleID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+func (s *AutomodService) getRule(ctx context.Context, actorID, ruleID uuid.UUID) (*model.AutomodRule, error) {
+	rule, err := s.automodRepo.GetRule(ctx, ruleID)
+	if errors.Is(err, repository.ErrRuleNotFound) {
+		return nil, ErrRuleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authorizeRules(ctx, actorID, rule.RoomID); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// authorizeRules lets site admins manage any rules and room admins those of
+// their room
+func (s *AutomodService) authorizeRules(ctx context.Context, actorID uuid.UUID, roomID *uuid.UUID) error {
+	if s.admins[actorID] {
+		return nil
+	}
+	if roomID == nil {
+		return ErrForbidden
+	}
+	_, err := s.roomService.Authorize(ctx, *roomID, actorID, model.PermManageRoom)
+	return err
+}
+
+// applyRuleRequest checks a create or update request and copies it onto
+// the rule
+func applyRuleRequest(rule *model.AutomodRule, req *model.AutomodRuleRequest) error {
+	name := cleanText(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxRuleNameRunes {
+		return invalidRule("name must be 1 to %d characters", maxRuleNameRunes)
+	}
+
+	switch req.Action {
+	case model.AutomodMask:
+		switch req.Kind {
+		case model.AutomodBlocklist, model.AutomodRegex, model.AutomodLinks:
+		default:
+			return invalidRule("only blocklist, regex and links rules can mask")
+		}
+	case model.AutomodMute:
+		if req.MuteSeconds <= 0 || time.Duration(req.MuteSeconds)*time.Second > maxTimeout {
+			return invalidRule("mute_seconds must be between 1 and %d", int(maxTimeout.Seconds()))
+		}
+	case model.AutomodHold, model.AutomodReject:
+	default:
+		return invalidRule("action must be mask, hold, reject or mute")
+	}
+
+	next := model.AutomodRule{
+		ID:        rule.ID,
+		RoomID:    rule.RoomID,
+		Name:      name,
+		Kind:      req.Kind,
+		Action:    req.Action,
+		Enabled:   req.Enabled == nil || *req.Enabled,
+		CreatedBy: rule.CreatedBy,
+		CreatedAt: rule.CreatedAt,
+		UpdatedAt: rule.UpdatedAt,
+	}
+	if req.Action == model.AutomodMute {
+		next.MuteSeconds = req.MuteSeconds
+	}
+
+	switch req.Kind {
+	case model.AutomodBlocklist:
+		for _, word := range req.Words {
+			word = cleanText(word)
+			if word == "" {
+				continue
+			}
+			if utf8.RuneCountInString(word) > maxBlocklistRunes || len(foldWord(word, hasThai(word))) == 0 {
+				return invalidRule("words must have letters and be at most %d characters", maxBlocklistRunes)
+			}
+			next.Words = append(next.Words, word)
+		}
+		if len(next.Words) == 0 || len(next.Words) > maxBlocklistWords {
+			return invalidRule("words must list 1 to %d entries", maxBlocklistWords)
+		}
+
+	case model.AutomodRegex:
+		if req.Pattern == "" || len(req.Pattern) > maxRulePatternLen {
+			return invalidRule("pattern must be 1 to %d bytes", maxRulePatternLen)
+		}
+		if _, err := regexp.Compile(req.Pattern); err != nil {
+			return invalidRule("pattern does not compile: %v", err)
+		}
+		next.Pattern = req.Pattern
+
+	case model.AutomodLinks:
+		if req.LinkMode != model.LinkAllow && req.LinkMode != model.LinkDeny {
+			return invalidRule("link_mode must be allow or deny")
+		}
+		for _, domain := range req.Domains {
+			domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+			if domain == "" {
+				continue
+			}
+			if strings.ContainsAny(domain, "/:@ ") {
+				return invalidRule("domains must be bare host names like example.com")
+			}
+			next.Domains = append(next.Domains, domain)
+		}
+		if len(next.Domains) > maxRuleDomains || (req.LinkMode == model.LinkDeny && len(next.Domains) == 0) {
+			return invalidRule("domains must list up to %d entries, at least one to deny", maxRuleDomains)
+		}
+		next.LinkMode = req.LinkMode
+
+	case model.AutomodCaps:
+		if req.MinLetters < 0 || req.MaxCapsRatio < 0 || req.MaxCapsRatio > 1 {
+			return invalidRule("min_letters must not be negative and max_caps_ratio must be between 0 and 1")
+		}
+		next.MinLetters = req.MinLetters
+		next.MaxCapsRatio = req.MaxCapsRatio
+
+	case model.AutomodSpam:
+		if req.MaxMentions < 0 || req.MaxRunLength < 0 || req.MaxLines < 0 ||
+			req.MaxMentions+req.MaxRunLength+req.MaxLines == 0 {
+			return invalidRule("set at least one of max_mentions, max_run_length and max_lines")
+		}
+		next.MaxMentions = req.MaxMentions
+		next.MaxRunLength = req.MaxRunLength
+		next.MaxLines = req.MaxLines
+
+	case model.AutomodRepeat:
+		if req.MaxRepeats < 1 || req.WindowSeconds < 0 || req.WindowSeconds > maxRepeatWindow {
+			return invalidRule("max_repeats must be at least 1 and window_seconds at most %d", maxRepeatWindow)
+		}
+		next.MaxRepeats = req.MaxRepeats
+		next.WindowSeconds = req.WindowSeconds
+
+	default:
+		return invalidRule("kind must be blocklist, regex, links, caps, spam or repeat")
+	}
+
+	*rule = next
+	return nil
+}
+
+func invalidRule(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidRule, fmt.Sprintf(format, args...))
+}
+
+// ListHeld returns the messages awaiting review in a room, for staff who
+// may delete other users' messages
+func (s *AutomodService) ListHeld(ctx context.Context, roomID, actorID uuid.UUID, limit, offset int) ([]model.HeldMessage, error) {
+	if _, err := s.roomService.Authorize(ctx, roomID, actorID, model.PermDeleteMessages); err != nil {
+		return nil, err
+	}
+	return s.automodRepo.ListPending(ctx, roomID, limit, offset)
+}
+
+// Review approves or rejects a held message. An approved message is
+// returned for the caller to post; if posting fails, Reopen puts it back in
+// the queue.
+func (s *AutomodService) Review(ctx context.Context, heldID, actorID uuid.UUID, approve bool) (*model.HeldMessage, error) {
+	held, err := s.automodRepo.GetHeld(ctx, heldID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrHeldNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.roomService.Authorize(ctx, held.RoomID, actorID, model.PermDeleteMessages); err != nil {
+		return nil, err
+	}
+
+	status := model.HeldRejected
+	if approve {
+		status = model.HeldApproved
+	}
+	if err := s.automodRepo.Review(ctx, heldID, actorID, status); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	held.Status = status
+	held.ReviewedBy = &actorID
+	held.ReviewedAt = &now
+	return held, nil
+}
+
+// Reopen returns an approved message to the queue
+func (s *AutomodService) Reopen(ctx context.Context, heldID uuid.UUID) error {
+	return s.automodRepo.Reopen(ctx, heldID)
+}
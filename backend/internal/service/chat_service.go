@@ -2,60 +2,686 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/khonE3/chat-backend/internal/model"
 	"github.com/khonE3/chat-backend/internal/repository"
 )
 
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrEmptyMessage    = errors.New("message content is empty")
+	ErrInvalidEmoji    = errors.New("invalid emoji")
+	ErrQueryTooShort   = errors.New("search query must be at least 2 characters")
+	ErrQueryTooLong    = errors.New("search query must be at most 200 characters")
+	ErrInvalidSince    = errors.New("since must be a message ID or sequence number")
+	ErrInvalidNonce    = errors.New("nonce must be at most 64 bytes")
+	ErrNonceReused     = errors.New("nonce already used for another message")
+)
+
+const (
+	maxEmojiBytes  = 32
+	minSearchRunes = 2
+	maxSearchRunes = 200
+	maxReplay      = 500
+	maxNonceBytes  = 64
+)
+
 type ChatService struct {
 	messageRepo  *repository.MessageRepository
 	pubsubRepo   *repository.PubSubRepository
 	presenceRepo *repository.PresenceRepository
+	reactionRepo *repository.ReactionRepository
+	receiptRepo  *repository.ReceiptRepository
+	roomService  *RoomService
+	rateLimiter  *RateLimitService
+	validator    *ContentValidator
+	automod      *AutomodService
 }
 
 func NewChatService(
 	messageRepo *repository.MessageRepository,
 	pubsubRepo *repository.PubSubRepository,
 	presenceRepo *repository.PresenceRepository,
+	reactionRepo *repository.ReactionRepository,
+	receiptRepo *repository.ReceiptRepository,
+	roomService *RoomService,
+	rateLimiter *RateLimitService,
+	validator *ContentValidator,
+	automod *AutomodService,
 ) *ChatService {
 	return &ChatService{
 		messageRepo:  messageRepo,
 		pubsubRepo:   pubsubRepo,
 		presenceRepo: presenceRepo,
+		reactionRepo: reactionRepo,
+		receiptRepo:  receiptRepo,
+		roomService:  roomService,
+		rateLimiter:  rateLimiter,
+		validator:    validator,
+		automod:      automod,
 	}
 }
 
-func (s *ChatService) SendMessage(ctx context.Context, roomID string, userID uuid.UUID, content string) (*model.MessageWithUser, error) {
+// SendMessage stores a message. When replyToID is set the message becomes a
+// reply in the thread of the quoted message. A non-empty nonce makes the
+// send idempotent: resending it returns the stored message with duplicate
+// set instead of creating another. Sends over the user's rate or the room's
+// slow mode fail with a *RateLimitError, and messages automod stops with an
+// *AutomodError.
+func (s *ChatService) SendMessage(ctx context.Context, roomID string, userID uuid.UUID, content string, replyToID *uuid.UUID, nonce string) (*model.MessageWithUser, bool, error) {
 	roomUUID, err := uuid.Parse(roomID)
 	if err != nil {
+		return nil, false, err
+	}
+
+	if content, err = s.validator.Message(content); err != nil {
+		return nil, false, err
+	}
+	if len(nonce) > maxNonceBytes {
+		return nil, false, ErrInvalidNonce
+	}
+
+	// A retry of a stored message is answered before anything is charged
+	// for it or screened again
+	if existing, err := findDuplicate(ctx, s.messageRepo, roomUUID, userID, nonce); existing != nil || err != nil {
+		return existing, existing != nil, err
+	}
+
+	role, err := s.roomService.Authorize(ctx, roomUUID, userID, model.PermPost)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := s.rateLimiter.AllowPost(ctx, roomUUID, userID, role); err != nil {
+		return nil, false, err
+	}
+
+	msg, duplicate, err := s.screenAndCreate(ctx, roomUUID, userID, role, content, replyToID, nonce)
+	if err != nil || duplicate {
+		s.rateLimiter.CancelPost(ctx, roomUUID, userID)
+	}
+	return msg, duplicate, err
+}
+
+// screenAndCreate runs automod over a message that passed the rate limits
+// and stores it
+func (s *ChatService) screenAndCreate(ctx context.Context, roomID, userID uuid.UUID, role model.RoomRole, content string, replyToID *uuid.UUID, nonce string) (*model.MessageWithUser, bool, error) {
+	content, err := s.automod.Apply(ctx, roomID, userID, role, content, replyToID, nonce)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var noncePtr *string
+	if nonce != "" {
+		noncePtr = &nonce
+	}
+
+	msg, err := s.create(ctx, roomID, userID, content, replyToID, noncePtr)
+	if errors.Is(err, repository.ErrDuplicateNonce) {
+		// Another connection stored the same send in the meantime
+		existing, err := resolveDuplicate(ctx, s.messageRepo, roomID, userID, nonce)
+		return existing, err == nil, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return msg, false, nil
+}
+
+// PostHeld posts a held message a moderator approved, as its original
+// sender and with its nonce, so the sender's retries resolve to it. If the
+// message it replied to is gone it is posted on its own. It fails with
+// ErrSenderCannotPost if the sender left, was banned or was muted while the
+// message waited.
+func (s *ChatService) PostHeld(ctx context.Context, held *model.HeldMessage) (*model.MessageWithUser, error) {
+	if _, err := s.roomService.Authorize(ctx, held.RoomID, held.UserID, model.PermPost); err != nil {
+		if errors.Is(err, ErrForbidden) {
+			return nil, fmt.Errorf("%w: %w", ErrSenderCannotPost, err)
+		}
 		return nil, err
 	}
 
-	// Save message to database
-	msg, err := s.messageRepo.Create(ctx, roomUUID, userID, content, model.MessageTypeText)
+	msg, err := s.create(ctx, held.RoomID, held.UserID, held.Content, held.ReplyToID, held.Nonce)
+	if errors.Is(err, ErrMessageNotFound) {
+		return s.create(ctx, held.RoomID, held.UserID, held.Content, nil, held.Nonce)
+	}
+	return msg, err
+}
+
+// create stores a text message, as a reply in the thread of replyToID when
+// that is set
+func (s *ChatService) create(ctx context.Context, roomID, userID uuid.UUID, content string, replyToID *uuid.UUID, nonce *string) (*model.MessageWithUser, error) {
+	if replyToID == nil {
+		return s.messageRepo.Create(ctx, roomID, userID, content, model.MessageTypeText, nonce)
+	}
+
+	parent, err := s.getRoomMessage(ctx, roomID, *replyToID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get message with user info
-	return s.messageRepo.GetByID(ctx, msg.ID)
+	rootID := parent.ID
+	if parent.ThreadRootID != nil {
+		rootID = *parent.ThreadRootID
+	}
+
+	return s.messageRepo.CreateReply(ctx, roomID, userID, content, parent.ID, rootID, nonce)
 }
 
-func (s *ChatService) GetRecentMessages(ctx context.Context, roomID string, limit int) ([]model.MessageWithUser, error) {
+// findDuplicate returns the message userID already sent with nonce, or nil
+// when the nonce is new (or empty)
+func findDuplicate(ctx context.Context, messageRepo *repository.MessageRepository, roomID, userID uuid.UUID, nonce string) (*model.MessageWithUser, error) {
+	if nonce == "" {
+		return nil, nil
+	}
+	existing, err := resolveDuplicate(ctx, messageRepo, roomID, userID, nonce)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return existing, err
+}
+
+// resolveDuplicate returns the message userID already sent with nonce, or
+// ErrNonceReused when that message belongs to another room
+func resolveDuplicate(ctx context.Context, messageRepo *repository.MessageRepository, roomID, userID uuid.UUID, nonce string) (*model.MessageWithUser, error) {
+	existing, err := messageRepo.GetByNonce(ctx, userID, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if existing.RoomID != roomID {
+		return nil, ErrNonceReused
+	}
+	return existing, nil
+}
+
+// GetThread returns a thread root and a page of its replies, provided the
+// user can read the room it belongs to
+func (s *ChatService) GetThread(ctx context.Context, rootID, userID uuid.UUID, limit, offset int) (*model.MessageWithUser, []model.MessageWithUser, error) {
+	root, err := s.messageRepo.GetByID(ctx, rootID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Don't reveal whether messages in inaccessible rooms exist
+	allowed, err := s.roomService.CanAccess(ctx, root.RoomID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !allowed {
+		return nil, nil, ErrMessageNotFound
+	}
+
+	// Asking for a reply's thread returns the whole thread
+	if root.ThreadRootID != nil {
+		return s.GetThread(ctx, *root.ThreadRootID, userID, limit, offset)
+	}
+
+	replies, err := s.messageRepo.GetThread(ctx, root.ID, limit, offset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	withRoot := append([]model.MessageWithUser{*root}, replies...)
+	if err := s.attachReactions(ctx, withRoot, userID); err != nil {
+		return nil, nil, err
+	}
+
+	return &withRoot[0], withRoot[1:], nil
+}
+
+// GetRecentMessages returns the newest page of a room timeline, served from
+// the stream cache when possible. Reactions are per viewer, so they are
+// always read from Postgres.
+func (s *ChatService) GetRecentMessages(ctx context.Context, roomID string, limit int, viewerID uuid.UUID) ([]model.MessageWithUser, error) {
 	roomUUID, err := uuid.Parse(roomID)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.messageRepo.GetByRoom(ctx, roomUUID, limit, 0)
+	messages, err := s.messageRepo.GetRecent(ctx, roomUUID, limit)
+	if err != nil {
+		return nil, err
+	}
+	if messages == nil {
+		messages = []model.MessageWithUser{}
+	}
+
+	return messages, s.attachReactions(ctx, messages, viewerID)
 }
 
-func (s *ChatService) GetMessageHistory(ctx context.Context, roomID string, limit, offset int) ([]model.MessageWithUser, error) {
+// ReplaySince returns the timeline messages a reconnecting client missed.
+// since is either the last seen sequence number or the ID of the last seen
+// message; a thread reply stands for its root.
+func (s *ChatService) ReplaySince(ctx context.Context, roomID, since string, viewerID uuid.UUID) (*model.ReplayPayload, error) {
 	roomUUID, err := uuid.Parse(roomID)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.messageRepo.GetByRoom(ctx, roomUUID, limit, offset)
+	seq, err := strconv.ParseInt(since, 10, 64)
+	if err != nil {
+		messageID, err := uuid.Parse(since)
+		if err != nil {
+			return nil, ErrInvalidSince
+		}
+
+		msg, err := s.getRoomMessage(ctx, roomUUID, messageID)
+		if err != nil {
+			return nil, err
+		}
+		if msg.ThreadRootID != nil {
+			if msg, err = s.getRoomMessage(ctx, roomUUID, *msg.ThreadRootID); err != nil {
+				return nil, err
+			}
+		}
+		seq = msg.Seq
+	}
+	if seq < 0 {
+		return nil, ErrInvalidSince
+	}
+
+	messages, truncated, err := s.messageRepo.GetSince(ctx, roomUUID, seq, maxReplay)
+	if err != nil {
+		return nil, err
+	}
+	if messages == nil {
+		messages = []model.MessageWithUser{}
+	}
+
+	payload := &model.ReplayPayload{Since: seq, Messages: messages, Truncated: truncated}
+	return payload, s.attachReactions(ctx, messages, viewerID)
+}
+
+// HistoryCacheStats reports how often join-time history came from the cache
+func (s *ChatService) HistoryCacheStats() repository.StreamCacheStats {
+	return s.messageRepo.CacheStats()
+}
+
+// GetMessageHistory returns a page of a room timeline selected by cursor,
+// around a given message, or (legacy) by offset
+func (s *ChatService) GetMessageHistory(ctx context.Context, roomID string, q *model.HistoryQuery, viewerID uuid.UUID) (*model.MessagePage, error) {
+	roomUUID, err := uuid.Parse(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &model.MessagePage{}
+	var hasOlder, hasNewer bool
+
+	switch {
+	case q.Around != nil:
+		page.Messages, hasOlder, hasNewer, err = s.getAround(ctx, roomUUID, *q.Around, q.Limit)
+
+	case q.After != nil:
+		page.Messages, hasNewer, err = s.messageRepo.GetAfter(ctx, roomUUID, q.After, q.Limit)
+		hasOlder = true
+
+	case q.Before == nil && q.Offset > 0:
+		page.Messages, err = s.messageRepo.GetByRoom(ctx, roomUUID, q.Limit, q.Offset)
+		hasOlder = len(page.Messages) == q.Limit
+		hasNewer = true
+
+	default:
+		page.Messages, hasOlder, err = s.messageRepo.GetBefore(ctx, roomUUID, q.Before, q.Limit)
+		hasNewer = q.Before != nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if page.Messages == nil {
+		page.Messages = []model.MessageWithUser{}
+	}
+
+	if n := len(page.Messages); n > 0 {
+		if hasOlder {
+			cursor := model.CursorFor(&page.Messages[0].Message).Encode()
+			page.NextCursor = &cursor
+		}
+		if hasNewer {
+			cursor := model.CursorFor(&page.Messages[n-1].Message).Encode()
+			page.PrevCursor = &cursor
+		}
+	} else if q.Before != nil && hasNewer {
+		// Scrolled past the oldest message: let the client come back
+		cursor := q.Before.Encode()
+		page.PrevCursor = &cursor
+	}
+
+	return page, s.attachReactions(ctx, page.Messages, viewerID)
+}
+
+// getAround returns the message with context on both sides, for jumping to
+// a message. A thread reply jumps to its root.
+func (s *ChatService) getAround(ctx context.Context, roomID, messageID uuid.UUID, limit int) ([]model.MessageWithUser, bool, bool, error) {
+	target, err := s.messageRepo.GetByID(ctx, messageID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, false, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, false, false, err
+	}
+	if target.RoomID != roomID {
+		return nil, false, false, ErrMessageNotFound
+	}
+	if target.ThreadRootID != nil {
+		return s.getAround(ctx, roomID, *target.ThreadRootID, limit)
+	}
+
+	cursor := model.CursorFor(&target.Message)
+	olderCount := (limit - 1) / 2
+	newerCount := limit - 1 - olderCount
+
+	older, hasOlder, err := s.messageRepo.GetBefore(ctx, roomID, cursor, olderCount)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	newer, hasNewer, err := s.messageRepo.GetAfter(ctx, roomID, cursor, newerCount)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	messages := append(older, *target)
+	messages = append(messages, newer...)
+	return messages, hasOlder, hasNewer, nil
+}
+
+// GetMessage returns a single message with user and thread info
+func (s *ChatService) GetMessage(ctx context.Context, messageID uuid.UUID) (*model.MessageWithUser, error) {
+	return s.messageRepo.GetByID(ctx, messageID)
+}
+
+// EditMessage replaces the content of a message. Only its author may edit it.
+func (s *ChatService) EditMessage(ctx context.Context, roomID, messageID, userID uuid.UUID, content string) (*model.MessageWithUser, error) {
+	content, err := s.validator.Message(content)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.getRoomMessage(ctx, roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	// System messages are a record of what happened; nobody rewrites them
+	if existing.MessageType == model.MessageTypeSystem || existing.UserID == nil || *existing.UserID != userID {
+		return nil, ErrForbidden
+	}
+
+	// Authors who lost posting rights can't rewrite what they said either
+	role, err := s.roomService.Authorize(ctx, roomID, userID, model.PermPost)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = s.automod.ApplyEdit(ctx, roomID, userID, role, content); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.messageRepo.Update(ctx, messageID, content); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+
+	return s.messageRepo.GetByID(ctx, messageID)
+}
+
+// DeleteMessage retracts a message. Authors may delete their own messages;
+// moderators and above may delete anyone's.
+func (s *ChatService) DeleteMessage(ctx context.Context, roomID, messageID, userID uuid.UUID) (*model.Message, error) {
+	existing, err := s.getRoomMessage(ctx, roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	// System messages can only be removed by moderators, whoever they name
+	isAuthor := existing.MessageType != model.MessageTypeSystem && existing.UserID != nil && *existing.UserID == userID
+	if !isAuthor {
+		if _, err := s.roomService.Authorize(ctx, roomID, userID, model.PermDeleteMessages); err != nil {
+			return nil, err
+		}
+	}
+
+	msg, err := s.messageRepo.Delete(ctx, messageID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	return msg, err
+}
+
+// AddReaction reacts to a message with an emoji
+func (s *ChatService) AddReaction(ctx context.Context, roomID, messageID, userID uuid.UUID, emoji string) (*model.ReactionPayload, error) {
+	if err := validateEmoji(emoji); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.getRoomMessage(ctx, roomID, messageID); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.roomService.Authorize(ctx, roomID, userID, model.PermPost); err != nil {
+		return nil, err
+	}
+
+	count, err := s.reactionRepo.Add(ctx, messageID, userID, emoji)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.ReactionPayload{
+		MessageID: messageID.String(),
+		RoomID:    roomID.String(),
+		UserID:    userID.String(),
+		Emoji:     emoji,
+		Count:     count,
+	}, nil
+}
+
+// RemoveReaction withdraws the user's own reaction
+func (s *ChatService) RemoveReaction(ctx context.Context, roomID, messageID, userID uuid.UUID, emoji string) (*model.ReactionPayload, error) {
+	if err := validateEmoji(emoji); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.getRoomMessage(ctx, roomID, messageID); err != nil {
+		return nil, err
+	}
+
+	count, err := s.reactionRepo.Remove(ctx, messageID, userID, emoji)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.ReactionPayload{
+		MessageID: messageID.String(),
+		RoomID:    roomID.String(),
+		UserID:    userID.String(),
+		Emoji:     emoji,
+		Count:     count,
+	}, nil
+}
+
+// MarkRead advances the user's read marker to messageID, or to the newest
+// message when messageID is nil. It returns the receipt to broadcast, or nil
+// when the marker did not move or the user doesn't share read receipts.
+func (s *ChatService) MarkRead(ctx context.Context, roomID, userID uuid.UUID, messageID *uuid.UUID) (*model.ReadReceiptPayload, error) {
+	allowed, err := s.roomService.CanAccess(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrForbidden
+	}
+
+	var msg *model.MessageWithUser
+	if messageID == nil {
+		newest, _, err := s.messageRepo.GetBefore(ctx, roomID, nil, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(newest) == 0 {
+			return nil, nil
+		}
+		msg = &newest[0]
+	} else {
+		if msg, err = s.getRoomMessage(ctx, roomID, *messageID); err != nil {
+			return nil, err
+		}
+		// Reading a thread counts as reading up to its root
+		if msg.ThreadRootID != nil {
+			if msg, err = s.getRoomMessage(ctx, roomID, *msg.ThreadRootID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	reader, shared, err := s.receiptRepo.Advance(ctx, userID, &msg.Message)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !shared {
+		return nil, nil
+	}
+
+	return &model.ReadReceiptPayload{
+		RoomID:      roomID,
+		MessageID:   msg.ID,
+		Seq:         msg.Seq,
+		UserID:      reader.ID,
+		Username:    reader.Username,
+		DisplayName: reader.DisplayName,
+		AvatarURL:   reader.AvatarURL,
+		ReadAt:      time.Now(),
+	}, nil
+}
+
+// GetReaders lists who has read a message, for "seen by"
+func (s *ChatService) GetReaders(ctx context.Context, roomID, messageID uuid.UUID) ([]model.User, error) {
+	msg, err := s.getRoomMessage(ctx, roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return s.receiptRepo.GetReaders(ctx, &msg.Message)
+}
+
+// SearchMessages runs a full-text search limited to rooms viewerID can read
+// and marks where the query terms appear in each result
+func (s *ChatService) SearchMessages(ctx context.Context, params *model.SearchParams, viewerID uuid.UUID) ([]model.SearchResult, error) {
+	params.Query = strings.TrimSpace(params.Query)
+	n := len([]rune(params.Query))
+	if n < minSearchRunes {
+		return nil, ErrQueryTooShort
+	}
+	if n > maxSearchRunes {
+		return nil, ErrQueryTooLong
+	}
+
+	results, err := s.messageRepo.Search(ctx, params, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		results[i].Highlights = highlightMatches(results[i].Content, params.Query)
+	}
+
+	return results, nil
+}
+
+// highlightMatches finds case-insensitive occurrences of the whole query and
+// of each space-separated term, returning merged rune ranges
+func highlightMatches(content, query string) []model.HighlightRange {
+	haystack := []rune(strings.ToLower(content))
+
+	terms := append([]string{query}, strings.Fields(query)...)
+	var ranges []model.HighlightRange
+	for _, term := range terms {
+		needle := []rune(strings.ToLower(term))
+		if len(needle) == 0 {
+			continue
+		}
+		for i := 0; i+len(needle) <= len(haystack); i++ {
+			if string(haystack[i:i+len(needle)]) == string(needle) {
+				ranges = append(ranges, model.HighlightRange{Start: i, End: i + len(needle)})
+			}
+		}
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	merged := []model.HighlightRange{}
+	for _, r := range ranges {
+		if last := len(merged) - 1; last >= 0 && r.Start <= merged[last].End {
+			if r.End > merged[last].End {
+				merged[last].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// attachReactions fills in reaction summaries as seen by viewerID
+func (s *ChatService) attachReactions(ctx context.Context, messages []model.MessageWithUser, viewerID uuid.UUID) error {
+	ids := make([]uuid.UUID, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+
+	reactions, err := s.reactionRepo.GetForMessages(ctx, ids, viewerID)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+	}
+	return nil
+}
+
+func validateEmoji(emoji string) error {
+	if emoji == "" || len(emoji) > maxEmojiBytes {
+		return ErrInvalidEmoji
+	}
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return ErrInvalidEmoji
+		}
+	}
+	return nil
+}
+
+// getRoomMessage loads a live (not deleted) message and checks it belongs to the room
+func (s *ChatService) getRoomMessage(ctx context.Context, roomID, messageID uuid.UUID) (*model.MessageWithUser, error) {
+	msg, err := s.messageRepo.GetByID(ctx, messageID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.RoomID != roomID || msg.DeletedAt != nil {
+		return nil, ErrMessageNotFound
+	}
+
+	return msg, nil
 }
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+)
+
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrSelfDM         = errors.New("cannot start a conversation with yourself")
+	ErrNotGroup       = errors.New("room is not a group conversation")
+	ErrGroupFull      = errors.New("group conversation is full")
+	ErrNoParticipants = errors.New("at least one other participant is required")
+)
+
+type DMService struct {
+	dmRepo       *repository.DMRepository
+	userRepo     *repository.UserRepository
+	roomRepo     *repository.RoomRepository
+	messageRepo  *repository.MessageRepository
+	maxGroupSize int
+}
+
+func NewDMService(
+	dmRepo *repository.DMRepository,
+	userRepo *repository.UserRepository,
+	roomRepo *repository.RoomRepository,
+	messageRepo *repository.MessageRepository,
+	maxGroupSize int,
+) *DMService {
+	return &DMService{
+		dmRepo:       dmRepo,
+		userRepo:     userRepo,
+		roomRepo:     roomRepo,
+		messageRepo:  messageRepo,
+		maxGroupSize: maxGroupSize,
+	}
+}
+
+// OpenDM returns the conversation between userID and peerID, creating it on
+// first use. created reports whether it was new.
+func (s *DMService) OpenDM(ctx context.Context, userID, peerID uuid.UUID) (*model.DMConversation, bool, error) {
+	if userID == peerID {
+		return nil, false, ErrSelfDM
+	}
+
+	peer, err := s.userRepo.GetByID(ctx, peerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	room, created, err := s.dmRepo.GetOrCreate(ctx, userID, peerID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &model.DMConversation{
+		Room:           *room,
+		Peer:           *peer,
+		LastActivityAt: room.CreatedAt,
+	}, created, nil
+}
+
+// Inbox lists the user's conversations, most recently active first
+func (s *DMService) Inbox(ctx context.Context, userID uuid.UUID) ([]model.DMConversation, error) {
+	return s.dmRepo.ListForUser(ctx, userID)
+}
+
+// MembershipChange is the outcome of a group membership change: the event
+// for connected members and the system message recording it
+type MembershipChange struct {
+	Event         model.MembersChangedPayload
+	SystemMessage *model.MessageWithUser
+}
+
+// CreateGroup starts a group conversation between the creator and userIDs
+func (s *DMService) CreateGroup(ctx context.Context, creatorID uuid.UUID, req *model.CreateGroupRequest) (*model.Room, *MembershipChange, error) {
+	creator, err := s.getUser(ctx, creatorID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	members, err := s.getUsers(ctx, req.UserIDs, creatorID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(members) == 0 {
+		return nil, nil, ErrNoParticipants
+	}
+	if len(members)+1 > s.maxGroupSize {
+		return nil, nil, ErrGroupFull
+	}
+
+	room, err := s.dmRepo.CreateGroup(ctx, strings.TrimSpace(req.Name), creatorID, userIDs(members))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	text := fmt.Sprintf("%s created the group with %s", creator.DisplayName, joinNames(members))
+	change, err := s.recordChange(ctx, room.ID, creatorID, model.MembershipAdded, members, text)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return room, change, nil
+}
+
+// AddMembers adds users to a group. Any member may add others while there
+// is room; users who are already members are skipped.
+func (s *DMService) AddMembers(ctx context.Context, roomID, actorID uuid.UUID, ids []uuid.UUID) (*MembershipChange, error) {
+	current, err := s.groupMembers(ctx, roomID, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	actor, err := s.getUser(ctx, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	var fresh []uuid.UUID
+	for _, id := range ids {
+		if !current[id] {
+			fresh = append(fresh, id)
+		}
+	}
+
+	added, err := s.getUsers(ctx, fresh, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if len(added) == 0 {
+		return nil, ErrNoParticipants
+	}
+	if len(current)+len(added) > s.maxGroupSize {
+		return nil, ErrGroupFull
+	}
+
+	// The check above is a fast path; the repository enforces the limit
+	// against concurrent adds
+	inserted, err := s.dmRepo.AddGroupMembers(ctx, roomID, actorID, userIDs(added), s.maxGroupSize)
+	if errors.Is(err, repository.ErrGroupFull) {
+		return nil, ErrGroupFull
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Someone else may have added some of them in the meantime
+	added = onlyUsers(added, inserted)
+	if len(added) == 0 {
+		return nil, ErrNoParticipants
+	}
+
+	text := fmt.Sprintf("%s added %s", actor.DisplayName, joinNames(added))
+	return s.recordChange(ctx, roomID, actorID, model.MembershipAdded, added, text)
+}
+
+// RemoveMember removes targetID from a group. Any member may remove another
+// member; removing yourself leaves the group.
+func (s *DMService) RemoveMember(ctx context.Context, roomID, actorID, targetID uuid.UUID) (*MembershipChange, error) {
+	current, err := s.groupMembers(ctx, roomID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !current[targetID] {
+		return nil, repository.ErrNotMember
+	}
+
+	actor, err := s.getUser(ctx, actorID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.getUser(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.dmRepo.RemoveGroupMember(ctx, roomID, targetID); err != nil {
+		return nil, err
+	}
+
+	action := model.MembershipRemoved
+	text := fmt.Sprintf("%s removed %s", actor.DisplayName, target.DisplayName)
+	if actorID == targetID {
+		action = model.MembershipLeft
+		text = fmt.Sprintf("%s left the group", actor.DisplayName)
+	}
+
+	return s.recordChange(ctx, roomID, actorID, action, []model.User{*target}, text)
+}
+
+// Groups lists the user's group conversations, most recently active first
+func (s *DMService) Groups(ctx context.Context, userID uuid.UUID) ([]model.GroupConversation, error) {
+	return s.dmRepo.ListGroupsForUser(ctx, userID)
+}
+
+// groupMembers returns the member set of a group the actor belongs to
+func (s *DMService) groupMembers(ctx context.Context, roomID, actorID uuid.UUID) (map[uuid.UUID]bool, error) {
+	room, err := s.roomRepo.GetByID(ctx, roomID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotGroup
+	}
+	if err != nil {
+		return nil, err
+	}
+	if room.Kind != model.RoomKindGroup {
+		return nil, ErrNotGroup
+	}
+
+	ids, err := s.dmRepo.GroupMemberIDs(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		members[id] = true
+	}
+	if !members[actorID] {
+		return nil, ErrForbidden
+	}
+	return members, nil
+}
+
+func (s *DMService) recordChange(ctx context.Context, roomID, actorID uuid.UUID, action model.MembershipAction, users []model.User, text string) (*MembershipChange, error) {
+	msg, err := s.messageRepo.CreateSystem(ctx, roomID, text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MembershipChange{
+		Event: model.MembersChangedPayload{
+			RoomID:  roomID,
+			Action:  action,
+			ActorID: actorID,
+			Users:   users,
+		},
+		SystemMessage: msg,
+	}, nil
+}
+
+func (s *DMService) getUser(ctx context.Context, id uuid.UUID) (*model.User, error) {
+	user, err := s.userRepo.GetByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// getUsers loads the given users once each, leaving out exclude
+func (s *DMService) getUsers(ctx context.Context, ids []uuid.UUID, exclude uuid.UUID) ([]model.User, error) {
+	seen := map[uuid.UUID]bool{exclude: true}
+	var users []model.User
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		// Bail out early rather than loading an arbitrarily long list
+		if len(users) >= s.maxGroupSize {
+			return nil, ErrGroupFull
+		}
+
+		user, err := s.getUser(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, nil
+}
+
+// onlyUsers keeps the users whose IDs are in ids
+func onlyUsers(users []model.User, ids []uuid.UUID) []model.User {
+	keep := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		keep[id] = true
+	}
+
+	var kept []model.User
+	for _, u := range users {
+		if keep[u.ID] {
+			kept = append(kept, u)
+		}
+	}
+	return kept
+}
+
+func userIDs(users []model.User) []uuid.UUID {
+	ids := make([]uuid.UUID, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids
+}
+
+func joinNames(users []model.User) string {
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = u.DisplayName
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
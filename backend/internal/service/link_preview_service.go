@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+	"github.com/khonE3/chat-backend/pkg/unfurl"
+)
+
+const (
+	maxPreviewsPerMessage = 3
+	maxConcurrentUnfurls  = 8
+	failedPreviewTTL      = time.Hour
+)
+
+// urlPattern finds http(s) links. Thai script ends a link, since Thai text
+// often runs straight on from a URL without a space.
+var urlPattern = regexp.MustCompile("https?://[^\\s<>\"'`\\p{Thai}]+")
+
+type LinkPreviewService struct {
+	fetcher     *unfurl.Fetcher
+	previewRepo *repository.LinkPreviewRepository
+	messageRepo *repository.MessageRepository
+	cacheTTL    time.Duration
+
+	// Bounds the number of messages being unfurled at once
+	slots chan struct{}
+}
+
+func NewLinkPreviewService(fetcher *unfurl.Fetcher, previewRepo *repository.LinkPreviewRepository, messageRepo *repository.MessageRepository, cacheTTL time.Duration) *LinkPreviewService {
+	return &LinkPreviewService{
+		fetcher:     fetcher,
+		previewRepo: previewRepo,
+		messageRepo: messageRepo,
+		cacheTTL:    cacheTTL,
+		slots:       make(chan struct{}, maxConcurrentUnfurls),
+	}
+}
+
+// Unfurl fetches previews for the links in msg and stores them with the
+// message. It returns nil when there is nothing to show, including when
+// the message changed while the pages were being fetched.
+func (s *LinkPreviewService) Unfurl(ctx context.Context, msg *model.Message) (*model.MessageUpdatePayload, error) {
+	if msg.MessageType != model.MessageTypeText && msg.MessageType != model.MessageTypeAttachment {
+		return nil, nil
+	}
+
+	urls := ExtractURLs(msg.Content, maxPreviewsPerMessage)
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var previews []model.LinkPreview
+	for _, u := range urls {
+		if preview := s.preview(ctx, u); preview != nil {
+			previews = append(previews, *preview)
+		}
+	}
+	if len(previews) == 0 {
+		return nil, nil
+	}
+
+	stored, err := s.messageRepo.SetLinkPreviews(ctx, msg.ID, msg.Content, previews)
+	if err != nil || !stored {
+		return nil, err
+	}
+
+	return &model.MessageUpdatePayload{
+		MessageID:    msg.ID.String(),
+		RoomID:       msg.RoomID.String(),
+		LinkPreviews: previews,
+	}, nil
+}
+
+// preview returns the preview of a page, from cache when possible. Pages
+// that fail to load are remembered for a shorter while than good ones.
+func (s *LinkPreviewService) preview(ctx context.Context, url string) *model.LinkPreview {
+	cached, found, err := s.previewRepo.Get(ctx, url)
+	if err != nil {
+		log.Printf("⚠️ Failed to read link preview cache: %v", err)
+	}
+	if found {
+		return cached
+	}
+
+	page, err := s.fetcher.Fetch(ctx, url)
+	if err != nil {
+		log.Printf("⚠️ No link preview for %s: %v", url, err)
+		s.cache(ctx, url, nil, failedPreviewTTL)
+		return nil
+	}
+	if page.Empty() {
+		s.cache(ctx, url, nil, s.cacheTTL)
+		return nil
+	}
+
+	preview := &model.LinkPreview{
+		URL:         page.URL,
+		Title:       page.Title,
+		Description: page.Description,
+		ImageURL:    page.ImageURL,
+		SiteName:    page.SiteName,
+	}
+	s.cache(ctx, url, preview, s.cacheTTL)
+	return preview
+}
+
+func (s *LinkPreviewService) cache(ctx context.Context, url string, preview *model.LinkPreview, ttl time.Duration) {
+	if err := s.previewRepo.Set(ctx, url, preview, ttl); err != nil {
+		log.Printf("⚠️ Failed to cache link preview: %v", err)
+	}
+}
+
+// ExtractURLs returns up to limit distinct links from text in order of
+// appearance. Trailing punctuation is not part of a link, except a closing
+// parenthesis that balances one inside it.
+func ExtractURLs(text string, limit int) []string {
+	var urls []string
+	seen := make(map[string]bool)
+
+	for _, u := range urlPattern.FindAllString(text, -1) {
+		for {
+			trimmed := strings.TrimRight(u, ".,;:!?'\"]}")
+			if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, "(") < strings.Count(trimmed, ")") {
+				trimmed = trimmed[:len(trimmed)-1]
+			}
+			if trimmed == u {
+				break
+			}
+			u = trimmed
+		}
+
+		if len(u) <= len("https://") || seen[u] {
+			continue
+		}
+		seen[u] = true
+		urls = append(urls, u)
+		if len(urls) == limit {
+			break
+		}
+	}
+
+	return urls
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractURLs(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"none", "no links here", 3, nil},
+		{"one", "see https://example.com/a", 3, []string{"https://example.com/a"}},
+		{"trailing punctuation", "see https://example.com/a.", 3, []string{"https://example.com/a"}},
+		{"quoted", `"https://example.com/a"!`, 3, []string{"https://example.com/a"}},
+		{"in parentheses", "(https://example.com/a)", 3, []string{"https://example.com/a"}},
+		{"balanced parenthesis kept", "(https://en.wikipedia.org/wiki/Go_(language))", 3, []string{"https://en.wikipedia.org/wiki/Go_(language)"}},
+		{"thai around", "ดูhttps://example.com/aครับ", 3, []string{"https://example.com/a"}},
+		{"once each", "http://a.test http://b.test http://a.test", 3, []string{"http://a.test", "http://b.test"}},
+		{"limit", "http://a.test http://b.test http://c.test", 2, []string{"http://a.test", "http://b.test"}},
+		{"scheme only", "https://", 3, nil},
+		{"no scheme", "www.example.com", 3, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractURLs(tt.text, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractURLs(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
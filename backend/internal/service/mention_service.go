@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+)
+
+// mentionPattern matches @name where the @ doesn't follow a letter or digit,
+// so e-mail addresses aren't mentions. Usernames may be Thai, and Thai
+// vowels and tone marks are combining marks (\p{M}).
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{M}\p{N}_.])@([\p{L}\p{M}\p{N}_.\-]+)`)
+
+type MentionService struct {
+	mentionRepo  *repository.MentionRepository
+	roomRepo     *repository.RoomRepository
+	presenceRepo *repository.PresenceRepository
+}
+
+func NewMentionService(mentionRepo *repository.MentionRepository, roomRepo *repository.RoomRepository, presenceRepo *repository.PresenceRepository) *MentionService {
+	return &MentionService{
+		mentionRepo:  mentionRepo,
+		roomRepo:     roomRepo,
+		presenceRepo: presenceRepo,
+	}
+}
+
+// MentionNotice is a mention event due to one user
+type MentionNotice struct {
+	UserID  uuid.UUID
+	Payload *model.MentionPayload
+}
+
+// Record stores the mentions in a newly sent message and returns the events
+// to push to the mentioned users. Only room members can be mentioned; @here
+// reaches those online in the room and @room all of them.
+func (s *MentionService) Record(ctx context.Context, msg *model.MessageWithUser) ([]MentionNotice, error) {
+	if msg.UserID == nil || (msg.MessageType != model.MessageTypeText && msg.MessageType != model.MessageTypeAttachment) {
+		return nil, nil
+	}
+
+	usernames, here, room := ParseMentions(msg.Content)
+	if len(usernames) == 0 && !here && !room {
+		return nil, nil
+	}
+
+	// Direct mentions first: someone named and covered by @room counts as
+	// mentioned by name
+	kinds := make(map[uuid.UUID]model.MentionKind)
+	add := func(kind model.MentionKind, names []string, ids []uuid.UUID) error {
+		mentioned, err := s.mentionRepo.Add(ctx, &msg.Message, kind, names, ids)
+		for _, id := range mentioned {
+			kinds[id] = kind
+		}
+		return err
+	}
+
+	if len(usernames) > 0 {
+		if err := add(model.MentionUser, usernames, nil); err != nil {
+			return nil, err
+		}
+	}
+	if here && !room {
+		online, err := s.onlineUserIDs(ctx, msg.RoomID)
+		if err != nil {
+			return nil, err
+		}
+		if len(online) > 0 {
+			if err := add(model.MentionHere, nil, online); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if room {
+		if err := add(model.MentionRoom, nil, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(kinds) == 0 {
+		return nil, nil
+	}
+
+	roomInfo, err := s.roomRepo.GetByID(ctx, msg.RoomID)
+	if err != nil {
+		return nil, err
+	}
+
+	notices := make([]MentionNotice, 0, len(kinds))
+	for userID, kind := range kinds {
+		notices = append(notices, MentionNotice{
+			UserID: userID,
+			Payload: &model.MentionPayload{
+				Kind:     kind,
+				RoomID:   msg.RoomID.String(),
+				RoomName: roomInfo.Name,
+				Message:  msg,
+			},
+		})
+	}
+
+	return notices, nil
+}
+
+// ListForUser returns a page of the user's mentions, newest first
+func (s *MentionService) ListForUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]model.Mention, error) {
+	return s.mentionRepo.ListForUser(ctx, userID, limit, offset)
+}
+
+func (s *MentionService) onlineUserIDs(ctx context.Context, roomID uuid.UUID) ([]uuid.UUID, error) {
+	online, err := s.presenceRepo.GetOnlineUsers(ctx, roomID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(online))
+	for _, u := range online {
+		id, err := uuid.Parse(u.UserID)
+		if err != nil {
+			log.Printf("⚠️ Invalid user ID in presence for room %s: %q", roomID, u.UserID)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ParseMentions returns the lower-cased usernames mentioned in content and
+// whether it mentions @here or @room. Trailing dots and dashes are treated
+// as punctuation, not part of the name.
+func ParseMentions(content string) (usernames []string, here, room bool) {
+	seen := make(map[string]bool)
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		switch name {
+		case "":
+			continue
+		case "here":
+			here = true
+		case "room":
+			room = true
+		default:
+			if !seen[name] {
+				seen[name] = true
+				usernames = append(usernames, name)
+			}
+		}
+	}
+
+	return usernames, here, room
+}
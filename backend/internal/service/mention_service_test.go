@@ -0,0 +1,39 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		usernames []string
+		here      bool
+		room      bool
+	}{
+		{"none", "hello everyone", nil, false, false},
+		{"one", "hi @alice", []string{"alice"}, false, false},
+		{"lower-cased", "hi @Alice", []string{"alice"}, false, false},
+		{"in order, once each", "@bob @alice @BOB", []string{"bob", "alice"}, false, false},
+		{"trailing punctuation", "thanks @carol. and @dave-", []string{"carol", "dave"}, false, false},
+		{"dots and dashes inside", "@first.last @a-b", []string{"first.last", "a-b"}, false, false},
+		{"in brackets", "(@erin)", []string{"erin"}, false, false},
+		{"email address", "mail me at frank@example.com", nil, false, false},
+		{"thai name", "สวัสดี @สมชาย ครับ", []string{"สมชาย"}, false, false},
+		{"here", "@here meeting now", nil, true, false},
+		{"room", "@room and @here", nil, true, true},
+		{"lone at sign", "@ nobody", nil, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usernames, here, room := ParseMentions(tt.content)
+			if !reflect.DeepEqual(usernames, tt.usernames) || here != tt.here || room != tt.room {
+				t.Errorf("ParseMentions(%q) = %q, %v, %v; want %q, %v, %v",
+					tt.content, usernames, here, room, tt.usernames, tt.here, tt.room)
+			}
+		})
+	}
+}
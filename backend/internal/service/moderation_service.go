@@ -0,0 +1,329 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+)
+
+var (
+	ErrSelfModeration  = errors.New("cannot moderate yourself")
+	ErrInvalidDuration = errors.New("invalid restriction duration")
+	ErrNotRestricted   = errors.New("user has no such restriction")
+)
+
+const (
+	// maxTimeout caps timeouts; longer silences are what mutes are for
+	maxTimeout     = 28 * 24 * time.Hour
+	maxReasonRunes = 500
+)
+
+// ModerationResult is what a room is told about a moderator's action: the
+// event that updates clients and the system message recording it
+type ModerationResult struct {
+	Event         model.ModerationPayload
+	SystemMessage *model.MessageWithUser
+}
+
+// authorizeFunc checks that a user holds perm in a room and returns their
+// role. RoomService.Authorize is the usual one; reports let site admins act
+// in any room.
+type authorizeFunc func(ctx context.Context, roomID, userID uuid.UUID, perm model.Permission) (model.RoomRole, error)
+
+// ModerationService lets room staff kick, ban, mute and time out members.
+// Actors need the matching permission and must outrank their target.
+type ModerationService struct {
+	moderationRepo *repository.ModerationRepository
+	roomRepo       *repository.RoomRepository
+	userRepo       *repository.UserRepository
+	messageRepo    *repository.MessageRepository
+	roomService    *RoomService
+}
+
+func NewModerationService(
+	moderationRepo *repository.ModerationRepository,
+	roomRepo *repository.RoomRepository,
+	userRepo *repository.UserRepository,
+	messageRepo *repository.MessageRepository,
+	roomService *RoomService,
+) *ModerationService {
+	return &ModerationService{
+		moderationRepo: moderationRepo,
+		roomRepo:       roomRepo,
+		userRepo:       userRepo,
+		messageRepo:    messageRepo,
+		roomService:    roomService,
+	}
+}
+
+// Kick removes the target from the room. Unlike a ban it leaves no record,
+// so public rooms can be rejoined straight away.
+func (s *ModerationService) Kick(ctx context.Context, roomID, actorID, targetID uuid.UUID, req *model.ModerationRequest) (*ModerationResult, error) {
+	reason, err := cleanReason(req.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	actor, target, err := s.checkTarget(ctx, roomID, actorID, targetID, model.PermKick, s.roomService.Authorize)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.roomRepo.RemoveMember(ctx, roomID, targetID); err != nil {
+		return nil, err
+	}
+
+	text := withReason(fmt.Sprintf("%s kicked %s", actor.DisplayName, target.DisplayName), reason)
+	return s.record(ctx, model.ModerationPayload{
+		RoomID:  roomID,
+		UserID:  targetID,
+		Action:  model.ModerationKick,
+		ActorID: actorID,
+		Reason:  reason,
+	}, text)
+}
+
+// Restrict bans, mutes or times out the target, replacing any ban or mute
+// they already have. A ban also removes them from the room.
+func (s *ModerationService) Restrict(ctx context.Context, roomID, actorID, targetID uuid.UUID, kind model.RestrictionKind, req *model.ModerationRequest) (*ModerationResult, error) {
+	return s.restrict(ctx, roomID, actorID, targetID, kind, req, s.roomService.Authorize)
+}
+
+func (s *ModerationService) restrict(ctx context.Context, roomID, actorID, targetID uuid.UUID, kind model.RestrictionKind, req *model.ModerationRequest, authorize authorizeFunc) (*ModerationResult, error) {
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	if duration < 0 || (kind == model.RestrictionTimeout && (duration == 0 || duration > maxTimeout)) {
+		return nil, ErrInvalidDuration
+	}
+
+	reason, err := cleanReason(req.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	perm := model.PermMute
+	if kind == model.RestrictionBan {
+		perm = model.PermBan
+	}
+	actor, target, err := s.checkTarget(ctx, roomID, actorID, targetID, perm, authorize)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	res := &model.RoomRestriction{
+		ID:        uuid.New(),
+		RoomID:    roomID,
+		UserID:    targetID,
+		Kind:      kind,
+		Reason:    reason,
+		CreatedBy: &actorID,
+		CreatedAt: now,
+	}
+	if duration > 0 {
+		expiresAt := now.Add(duration)
+		res.ExpiresAt = &expiresAt
+	}
+
+	if err := s.moderationRepo.Restrict(ctx, res); err != nil {
+		return nil, err
+	}
+
+	var action model.ModerationAction
+	var text string
+	switch kind {
+	case model.RestrictionBan:
+		action = model.ModerationBan
+		text = fmt.Sprintf("%s banned %s", actor.DisplayName, target.DisplayName)
+	case model.RestrictionTimeout:
+		action = model.ModerationTimeout
+		text = fmt.Sprintf("%s timed out %s", actor.DisplayName, target.DisplayName)
+	default:
+		action = model.ModerationMute
+		text = fmt.Sprintf("%s muted %s", actor.DisplayName, target.DisplayName)
+	}
+	if duration > 0 {
+		text += " for " + formatDuration(duration)
+	}
+
+	return s.record(ctx, model.ModerationPayload{
+		RoomID:    roomID,
+		UserID:    targetID,
+		Action:    action,
+		ActorID:   actorID,
+		Reason:    reason,
+		ExpiresAt: res.ExpiresAt,
+	}, withReason(text, reason))
+}
+
+// Lift ends the target's ban, or their mute or timeout, ahead of time. The
+// actor must rank at least as high as whoever handed the restriction out.
+func (s *ModerationService) Lift(ctx context.Context, roomID, actorID, targetID uuid.UUID, kind model.RestrictionKind) (*ModerationResult, error) {
+	perm, action, verb := model.PermMute, model.ModerationUnmute, "unmuted"
+	if kind == model.RestrictionBan {
+		perm, action, verb = model.PermBan, model.ModerationUnban, "unbanned"
+	}
+
+	if actorID == targetID {
+		return nil, ErrSelfModeration
+	}
+	actorRole, err := s.roomService.Authorize(ctx, roomID, actorID, perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkCreators(ctx, roomID, targetID, kind, actorRole); err != nil {
+		return nil, err
+	}
+
+	actor, err := s.getUser(ctx, actorID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.getUser(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	lifted, err := s.moderationRepo.Lift(ctx, roomID, targetID, actorID, kind)
+	if err != nil {
+		return nil, err
+	}
+	if !lifted {
+		return nil, ErrNotRestricted
+	}
+
+	return s.record(ctx, model.ModerationPayload{
+		RoomID:  roomID,
+		UserID:  targetID,
+		Action:  action,
+		ActorID: actorID,
+	}, fmt.Sprintf("%s %s %s", actor.DisplayName, verb, target.DisplayName))
+}
+
+// checkCreators makes sure no active restriction of the kind being lifted
+// was handed out by someone who outranks the actor. Creators who have left
+// the room count as plain members.
+func (s *ModerationService) checkCreators(ctx context.Context, roomID, targetID uuid.UUID, kind model.RestrictionKind, actorRole model.RoomRole) error {
+	active, err := s.moderationRepo.Active(ctx, roomID, targetID)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, res := range active {
+		if res.Kind.Silences() != kind.Silences() {
+			continue
+		}
+		found = true
+		if res.CreatedBy == nil {
+			continue
+		}
+
+		creatorRole, err := s.roomRepo.GetRole(ctx, roomID, *res.CreatedBy)
+		if errors.Is(err, repository.ErrNotMember) {
+			creatorRole = model.RoleMember
+		} else if err != nil {
+			return err
+		}
+		if creatorRole.Outranks(actorRole) {
+			return ErrForbidden
+		}
+	}
+	if !found {
+		return ErrNotRestricted
+	}
+	return nil
+}
+
+// List returns the bans, mutes and timeouts in force in a room for staff
+// who may hand them out
+func (s *ModerationService) List(ctx context.Context, roomID, actorID uuid.UUID) ([]model.RoomRestriction, error) {
+	if _, err := s.roomService.Authorize(ctx, roomID, actorID, model.PermMute); err != nil {
+		return nil, err
+	}
+	return s.moderationRepo.ListActive(ctx, roomID)
+}
+
+// checkTarget authorizes the actor for perm and makes sure they outrank the
+// target. Targets who are not members count as plain members, so users can
+// be banned from a room before they join it.
+func (s *ModerationService) checkTarget(ctx context.Context, roomID, actorID, targetID uuid.UUID, perm model.Permission, authorize authorizeFunc) (actor, target *model.User, err error) {
+	if actorID == targetID {
+		return nil, nil, ErrSelfModeration
+	}
+
+	actorRole, err := authorize(ctx, roomID, actorID, perm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targetRole, err := s.roomRepo.GetRole(ctx, roomID, targetID)
+	if errors.Is(err, repository.ErrNotMember) {
+		targetRole = model.RoleMember
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if !actorRole.Outranks(targetRole) {
+		return nil, nil, ErrForbidden
+	}
+
+	if actor, err = s.getUser(ctx, actorID); err != nil {
+		return nil, nil, err
+	}
+	if target, err = s.getUser(ctx, targetID); err != nil {
+		return nil, nil, err
+	}
+	return actor, target, nil
+}
+
+func (s *ModerationService) record(ctx context.Context, event model.ModerationPayload, text string) (*ModerationResult, error) {
+	msg, err := s.messageRepo.CreateSystem(ctx, event.RoomID, text)
+	if err != nil {
+		return nil, err
+	}
+	return &ModerationResult{Event: event, SystemMessage: msg}, nil
+}
+
+func (s *ModerationService) getUser(ctx context.Context, id uuid.UUID) (*model.User, error) {
+	user, err := s.userRepo.GetByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+func cleanReason(reason string) (*string, error) {
+	reason = cleanText(reason)
+	if reason == "" {
+		return nil, nil
+	}
+	if err := checkLength("reason", reason, maxReasonRunes); err != nil {
+		return nil, err
+	}
+	return &reason, nil
+}
+
+func withReason(text string, reason *string) string {
+	if reason == nil {
+		return text
+	}
+	return text + ": " + *reason
+}
+
+// formatDuration writes d the way people say it, "1h" rather than "1h0m0s"
+func formatDuration(d time.Duration) string {
+	s := d.Round(time.Second).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
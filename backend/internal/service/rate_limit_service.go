@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitAction names a rate-limited action. It is also the action
+// reported to clients in rate_limited frames.
+type RateLimitAction string
+
+const (
+	RateLimitMessage    RateLimitAction = "message"
+	RateLimitTyping     RateLimitAction = "typing"
+	RateLimitRoomCreate RateLimitAction = "room_create"
+	RateLimitUserCreate RateLimitAction = "user_create"
+)
+
+// RateLimitError reports how long to wait before retrying. It matches
+// ErrRateLimited with errors.Is.
+type RateLimitError struct {
+	Action     RateLimitAction
+	RetryAfter time.Duration
+	SlowMode   bool // the room's slow mode, rather than the user's own rate
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limited, retry after %s", e.Action, e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// Payload builds the rate_limited frame for a WebSocket client
+func (e *RateLimitError) Payload(nonce string) model.RateLimitedPayload {
+	return model.RateLimitedPayload{
+		Action:       string(e.Action),
+		Nonce:        nonce,
+		RetryAfterMs: e.RetryAfter.Milliseconds(),
+		SlowMode:     e.SlowMode,
+	}
+}
+
+// RateLimitService enforces the configured limits. Limits fail open: when
+// Redis is unavailable actions are let through rather than taking chat down
+// with it.
+type RateLimitService struct {
+	rateLimitRepo *repository.RateLimitRepository
+	roomRepo      *repository.RoomRepository
+	limits        map[RateLimitAction]model.RateLimit
+}
+
+func NewRateLimitService(rateLimitRepo *repository.RateLimitRepository, roomRepo *repository.RoomRepository, limits map[RateLimitAction]model.RateLimit) *RateLimitService {
+	return &RateLimitService{
+		rateLimitRepo: rateLimitRepo,
+		roomRepo:      roomRepo,
+		limits:        limits,
+	}
+}
+
+// Allow takes one action for subject, a user ID or client address, from
+// its bucket. It returns a *RateLimitError once the bucket is empty.
+func (s *RateLimitService) Allow(ctx context.Context, action RateLimitAction, subject string) error {
+	limit := s.limits[action]
+	if !limit.Enabled() {
+		return nil
+	}
+
+	wait, err := s.rateLimitRepo.Take(ctx, string(action), subject, limit)
+	if err != nil {
+		log.Printf("Rate limiter unavailable, allowing %s: %v", action, err)
+		return nil
+	}
+	if wait > 0 {
+		return &RateLimitError{Action: action, RetryAfter: wait}
+	}
+	return nil
+}
+
+// AllowPost checks a new message against the sender's rate and the room's
+// slow mode, starting the sender's cooldown. Roles that may delete others'
+// messages are exempt from slow mode, so moderators can still speak up in a
+// busy room. Callers whose message then isn't stored call CancelPost.
+func (s *RateLimitService) AllowPost(ctx context.Context, roomID, userID uuid.UUID, role model.RoomRole) error {
+	if err := s.Allow(ctx, RateLimitMessage, userID.String()); err != nil {
+		return err
+	}
+	if role.Can(model.PermDeleteMessages) {
+		return nil
+	}
+
+	room, err := s.roomRepo.GetByID(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if room.SlowModeSeconds <= 0 {
+		return nil
+	}
+
+	wait, err := s.rateLimitRepo.StartCooldown(ctx, roomID, userID, time.Duration(room.SlowModeSeconds)*time.Second)
+	if err != nil {
+		log.Printf("Rate limiter unavailable, skipping slow mode: %v", err)
+		return nil
+	}
+	if wait > 0 {
+		return &RateLimitError{Action: RateLimitMessage, RetryAfter: wait, SlowMode: true}
+	}
+	return nil
+}
+
+// CancelPost ends the slow mode cooldown AllowPost started for a message
+// that was not stored after all, so a rejected or failed send doesn't make
+// the sender wait
+func (s *RateLimitService) CancelPost(ctx context.Context, roomID, userID uuid.UUID) {
+	if err := s.rateLimitRepo.EndCooldown(ctx, roomID, userID); err != nil {
+		log.Printf("Rate limiter unavailable, slow mode cooldown kept: %v", err)
+	}
+}
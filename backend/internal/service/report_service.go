@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+)
+
+var (
+	ErrInvalidReport  = errors.New("invalid report")
+	ErrCannotReport   = errors.New("message cannot be reported")
+	ErrReportNotFound = errors.New("report not found")
+)
+
+const (
+	maxReportDetailsRunes = 1000
+
+	// reportContextSize is how many timeline messages on each side of a
+	// reported message reviewers see
+	reportContextSize = 5
+)
+
+// ReportResolution is what came of resolving a report, for the caller to
+// announce: the deleted message and the ban, when there were any
+type ReportResolution struct {
+	Report     *model.Report
+	Resolved   int // reports closed, counting others on the same message
+	Deleted    *model.Message
+	Moderation *ModerationResult
+}
+
+// ReportService takes users' reports of messages and lets room staff, or
+// site admins in any room, work through them
+type ReportService struct {
+	reportRepo        *repository.ReportRepository
+	messageRepo       *repository.MessageRepository
+	roomService       *RoomService
+	moderationService *ModerationService
+	admins            map[uuid.UUID]bool
+}
+
+func NewReportService(
+	reportRepo *repository.ReportRepository,
+	messageRepo *repository.MessageRepository,
+	roomService *RoomService,
+	moderationService *ModerationService,
+	adminIDs []uuid.UUID,
+) *ReportService {
+	admins := make(map[uuid.UUID]bool, len(adminIDs))
+	for _, id := range adminIDs {
+		admins[id] = true
+	}
+
+	return &ReportService{
+		reportRepo:        reportRepo,
+		messageRepo:       messageRepo,
+		roomService:       roomService,
+		moderationService: moderationService,
+		admins:            admins,
+	}
+}
+
+// Report flags a message for the room's staff. Users may report messages in
+// rooms they can read, other than their own and system messages, once each.
+func (s *ReportService) Report(ctx context.Context, messageID, reporterID uuid.UUID, req *model.ReportRequest) (*model.Report, error) {
+	if !req.Reason.Valid() {
+		return nil, ErrInvalidReport
+	}
+
+	var details *string
+	if cleaned := cleanText(req.Details); cleaned != "" {
+		if err := checkLength("details", cleaned, maxReportDetailsRunes); err != nil {
+			return nil, err
+		}
+		details = &cleaned
+	}
+
+	msg, err := s.messageRepo.GetByID(ctx, messageID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't reveal whether messages in inaccessible rooms exist
+	allowed, err := s.roomService.CanAccess(ctx, msg.RoomID, reporterID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed || msg.DeletedAt != nil {
+		return nil, ErrMessageNotFound
+	}
+
+	if msg.MessageType == model.MessageTypeSystem || msg.UserID == nil || *msg.UserID == reporterID {
+		return nil, ErrCannotReport
+	}
+
+	report := &model.Report{
+		ID:          uuid.New(),
+		MessageID:   msg.ID,
+		RoomID:      msg.RoomID,
+		ReporterID:  reporterID,
+		AuthorID:    msg.UserID,
+		Content:     msg.Content,
+		Reason:      req.Reason,
+		Details:     details,
+		Status:      model.ReportOpen,
+		CreatedAt:   time.Now().UTC(),
+		OpenReports: 1,
+	}
+	if err := s.reportRepo.Create(ctx, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// ListOpen returns the open reports of a room, or of every room when roomID
+// is nil, which only site admins may ask for
+func (s *ReportService) ListOpen(ctx context.Context, actorID uuid.UUID, roomID *uuid.UUID, limit, offset int) ([]model.Report, error) {
+	if roomID == nil {
+		if !s.admins[actorID] {
+			return nil, ErrForbidden
+		}
+	} else if _, err := s.authorize(ctx, *roomID, actorID, model.PermDeleteMessages); err != nil {
+		return nil, err
+	}
+	return s.reportRepo.ListOpen(ctx, roomID, limit, offset)
+}
+
+// Get returns a report with the message in context and its audit trail
+func (s *ReportService) Get(ctx context.Context, reportID, actorID uuid.UUID) (*model.ReportDetail, error) {
+	report, err := s.getReport(ctx, reportID, actorID, model.PermDeleteMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &model.ReportDetail{Report: report}
+
+	detail.Message, err = s.messageRepo.GetByID(ctx, report.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	anchor := detail.Message
+	if anchor.ThreadRootID != nil {
+		if detail.ThreadRoot, err = s.messageRepo.GetByID(ctx, *anchor.ThreadRootID); err != nil {
+			return nil, err
+		}
+		anchor = detail.ThreadRoot
+	}
+
+	cursor := model.CursorFor(&anchor.Message)
+	if detail.Before, _, err = s.messageRepo.GetBefore(ctx, anchor.RoomID, cursor, reportContextSize); err != nil {
+		return nil, err
+	}
+	if detail.After, _, err = s.messageRepo.GetAfter(ctx, anchor.RoomID, cursor, reportContextSize); err != nil {
+		return nil, err
+	}
+	if detail.Before == nil {
+		detail.Before = []model.MessageWithUser{}
+	}
+	if detail.After == nil {
+		detail.After = []model.MessageWithUser{}
+	}
+
+	if detail.Audit, err = s.reportRepo.Audit(ctx, reportID); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// Resolve acts on a report: dismissing it, deleting the message, or banning
+// its author (which deletes the message too). The action settles every open
+// report on the same message. Dismissing and deleting need the permission to
+// delete messages; banning needs the permission to ban and to outrank the
+// author. If the deletion fails after the ban went through, the error comes
+// with a result holding the ban, which still has to be announced.
+func (s *ReportService) Resolve(ctx context.Context, reportID, actorID uuid.UUID, req *model.ResolveReportRequest) (*ReportResolution, error) {
+	if !req.Action.Valid() {
+		return nil, ErrInvalidReport
+	}
+	if req.DurationSeconds < 0 {
+		return nil, ErrInvalidDuration
+	}
+	note, err := cleanReason(req.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := s.getReport(ctx, reportID, actorID, model.PermDeleteMessages)
+	if err != nil {
+		return nil, err
+	}
+	if report.Status != model.ReportOpen {
+		return nil, repository.ErrReportNotOpen
+	}
+	if req.Action == model.ReportBanAuthor && report.AuthorID == nil {
+		return nil, ErrUserNotFound
+	}
+
+	// Claim the reports first so two reviewers can't both act on them.
+	// Postgres keeps microseconds, and Reopen matches on this time. If the
+	// action fails the reports are reopened, with the attempt left in their
+	// audit trail: a ban may have gone through before the deletion failed.
+	at := time.Now().UTC().Truncate(time.Microsecond)
+	ids, err := s.reportRepo.Resolve(ctx, report.MessageID, actorID, req.Action, note, at)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.act(ctx, report, actorID, req)
+	if err != nil {
+		if err := s.reportRepo.Reopen(ctx, ids, actorID, at); err != nil {
+			log.Printf("❌ Error reopening reports on message %s: %v", report.MessageID, err)
+		}
+		return result, err
+	}
+
+	report.Status = req.Action.Status()
+	report.Action = &req.Action
+	report.ResolvedBy = &actorID
+	report.ResolvedAt = &at
+	report.Note = note
+	report.OpenReports = 0
+
+	result.Report = report
+	result.Resolved = len(ids)
+	return result, nil
+}
+
+func (s *ReportService) act(ctx context.Context, report *model.Report, actorID uuid.UUID, req *model.ResolveReportRequest) (*ReportResolution, error) {
+	result := &ReportResolution{}
+
+	if req.Action == model.ReportBanAuthor {
+		moderation, err := s.moderationService.restrict(ctx, report.RoomID, actorID, *report.AuthorID, model.RestrictionBan, &model.ModerationRequest{
+			DurationSeconds: req.DurationSeconds,
+			Reason:          req.Reason,
+		}, s.authorize)
+		if err != nil {
+			return nil, err
+		}
+		result.Moderation = moderation
+	}
+
+	if req.Action != model.ReportDismiss {
+		deleted, err := s.messageRepo.Delete(ctx, report.MessageID)
+		// Already deleted, by its author or a moderator, is fine
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return result, err
+		}
+		result.Deleted = deleted
+	}
+
+	return result, nil
+}
+
+// getReport loads a report the actor may review with perm
+func (s *ReportService) getReport(ctx context.Context, reportID, actorID uuid.UUID, perm model.Permission) (*model.Report, error) {
+	report, err := s.reportRepo.Get(ctx, reportID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.authorize(ctx, report.RoomID, actorID, perm); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// authorize treats site admins as owners of every room
+func (s *ReportService) authorize(ctx context.Context, roomID, userID uuid.UUID, perm model.Permission) (model.RoomRole, error) {
+	if s.admins[userID] {
+		return model.RoleOwner, nil
+	}
+	return s.roomService.Authorize(ctx, roomID, userID, perm)
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/model"
+	"github.com/khonE3/chat-backend/internal/repository"
+)
+
+var (
+	ErrForbidden   = errors.New("permission denied")
+	ErrInvalidRole = errors.New("invalid role")
+	ErrRestricted  = errors.New("restricted in room")
+)
+
+// RestrictedError reports that a ban or mute stopped the user. It matches
+// ErrRestricted, and ErrForbidden for callers that only need allow or deny.
+type RestrictedError struct {
+	Restriction *model.RoomRestriction
+}
+
+func (e *RestrictedError) Error() string {
+	if e.Restriction.Kind == model.RestrictionBan {
+		return "You are banned from this room"
+	}
+	return "You are muted in this room"
+}
+
+func (e *RestrictedError) Is(target error) bool {
+	return target == ErrRestricted || target == ErrForbidden
+}
+
+// Detail describes the error for REST bodies and WebSocket frames
+func (e *RestrictedError) Detail() *model.ErrorDetail {
+	code := model.ErrCodeMuted
+	if e.Restriction.Kind == model.RestrictionBan {
+		code = model.ErrCodeBanned
+	}
+	return &model.ErrorDetail{Code: code, ExpiresAt: e.Restriction.ExpiresAt}
+}
+
+type RoomService struct {
+	roomRepo       *repository.RoomRepository
+	moderationRepo *repository.ModerationRepository
+}
+
+func NewRoomService(roomRepo *repository.RoomRepository, moderationRepo *repository.ModerationRepository) *RoomService {
+	return &RoomService{roomRepo: roomRepo, moderationRepo: moderationRepo}
+}
+
+// CanAccess reports whether the user may read the room
+func (s *RoomService) CanAccess(ctx context.Context, roomID, userID uuid.UUID) (bool, error) {
+	return s.roomRepo.CanAccess(ctx, roomID, userID)
+}
+
+// RoleOf returns the user's effective role in a room. Non-members of public
+// rooms act as plain members; non-members of private rooms get ErrNotMember.
+func (s *RoomService) RoleOf(ctx context.Context, roomID, userID uuid.UUID) (model.RoomRole, error) {
+	role, err := s.roomRepo.GetRole(ctx, roomID, userID)
+	if err == nil {
+		return role, nil
+	}
+	if !errors.Is(err, repository.ErrNotMember) {
+		return "", err
+	}
+
+	room, err := s.roomRepo.GetByID(ctx, roomID)
+	if err != nil {
+		return "", err
+	}
+	if room.IsPrivate {
+		return "", repository.ErrNotMember
+	}
+	return model.RoleMember, nil
+}
+
+// Authorize returns ErrForbidden unless the user's role grants perm.
+// Posting also fails with a *RestrictedError while the user is banned or
+// muted: banned users look like members to RoleOf in public rooms, and
+// muted members keep their role.
+func (s *RoomService) Authorize(ctx context.Context, roomID, userID uuid.UUID, perm model.Permission) (model.RoomRole, error) {
+	role, err := s.RoleOf(ctx, roomID, userID)
+	if errors.Is(err, repository.ErrNotMember) {
+		return "", ErrForbidden
+	}
+	if err != nil {
+		return "", err
+	}
+	if !role.Can(perm) {
+		return role, ErrForbidden
+	}
+
+	if perm == model.PermPost {
+		ban, mute, err := s.Restrictions(ctx, roomID, userID)
+		if err != nil {
+			return "", err
+		}
+		if ban != nil {
+			return role, &RestrictedError{Restriction: ban}
+		}
+		if mute != nil {
+			return role, &RestrictedError{Restriction: mute}
+		}
+	}
+	return role, nil
+}
+
+// Restrictions returns the user's ban and mute (or timeout) in force in a
+// room, either of which may be nil
+func (s *RoomService) Restrictions(ctx context.Context, roomID, userID uuid.UUID) (ban, mute *model.RoomRestriction, err error) {
+	active, err := s.moderationRepo.Active(ctx, roomID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range active {
+		switch {
+		case active[i].Kind == model.RestrictionBan:
+			ban = &active[i]
+		case active[i].Kind.Silences():
+			mute = &active[i]
+		}
+	}
+	return ban, mute, nil
+}
+
+// ChangeRole promotes or demotes a member. Actors may only manage members
+// ranked below them and only hand out roles below their own; ownership
+// cannot be assigned this way.
+func (s *RoomService) ChangeRole(ctx context.Context, roomID, actorID, targetID uuid.UUID, newRole model.RoomRole) error {
+	actorRole, err := s.Authorize(ctx, roomID, actorID, model.PermManageRoles)
+	if err != nil {
+		return err
+	}
+
+	targetRole, err := s.roomRepo.GetRole(ctx, roomID, targetID)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoleChange(actorRole, targetRole, newRole); err != nil {
+		return err
+	}
+
+	return s.roomRepo.SetRole(ctx, roomID, targetID, newRole)
+}
+
+// checkRoleChange reports whether an actor may move a member from one role
+// to another: both must rank below the actor's own
+func checkRoleChange(actorRole, targetRole, newRole model.RoomRole) error {
+	if !newRole.Valid() || newRole == model.RoleOwner {
+		return ErrInvalidRole
+	}
+	if !actorRole.Outranks(targetRole) || !actorRole.Outranks(newRole) {
+		return ErrForbidden
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/khonE3/chat-backend/internal/model"
+)
+
+func TestCheckRoleChange(t *testing.T) {
+	tests := []struct {
+		name                   string
+		actor, target, newRole model.RoomRole
+		want                   error
+	}{
+		{"owner promotes member to admin", model.RoleOwner, model.RoleMember, model.RoleAdmin, nil},
+		{"owner demotes admin", model.RoleOwner, model.RoleAdmin, model.RoleReadOnly, nil},
+		{"admin promotes member to moderator", model.RoleAdmin, model.RoleMember, model.RoleModerator, nil},
+		{"admin can't hand out admin", model.RoleAdmin, model.RoleMember, model.RoleAdmin, ErrForbidden},
+		{"admin can't demote a peer", model.RoleAdmin, model.RoleAdmin, model.RoleMember, ErrForbidden},
+		{"admin can't touch the owner", model.RoleAdmin, model.RoleOwner, model.RoleMember, ErrForbidden},
+		{"moderator can't promote", model.RoleModerator, model.RoleMember, model.RoleModerator, ErrForbidden},
+		{"ownership can't be assigned", model.RoleOwner, model.RoleAdmin, model.RoleOwner, ErrInvalidRole},
+		{"unknown role", model.RoleOwner, model.RoleMember, "superuser", ErrInvalidRole},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkRoleChange(tt.actor, tt.target, tt.newRole); !errors.Is(got, tt.want) {
+				t.Errorf("checkRoleChange(%q, %q, %q) = %v, want %v", tt.actor, tt.target, tt.newRole, got, tt.want)
+			}
+		})
+	}
+}
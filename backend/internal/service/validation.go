@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/khonE3/chat-backend/internal/model"
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrMessageTooLong = errors.New("message content is too long")
+
+// ValidationError rejects one field of user input. It matches
+// ErrEmptyMessage or ErrMessageTooLong with errors.Is, so callers that only
+// care about the reason need not unwrap it.
+type ValidationError struct {
+	Field string
+	Code  model.ErrorCode
+	Limit int // maximum length in characters, for too-long errors
+}
+
+// fieldLabels names validated fields in error messages
+var fieldLabels = map[string]string{
+	"content": "Message",
+	"caption": "Caption",
+	"reason":  "Reason",
+	"details": "Details",
+}
+
+func (e *ValidationError) Error() string {
+	label, ok := fieldLabels[e.Field]
+	if !ok {
+		label = e.Field
+	}
+
+	switch e.Code {
+	case model.ErrCodeEmptyMessage:
+		return fmt.Sprintf("%s is empty", label)
+	case model.ErrCodeMessageTooLong:
+		return fmt.Sprintf("%s must be at most %d characters", label, e.Limit)
+	}
+	return fmt.Sprintf("Invalid %s", e.Field)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	switch target {
+	case ErrEmptyMessage:
+		return e.Code == model.ErrCodeEmptyMessage
+	case ErrMessageTooLong:
+		return e.Code == model.ErrCodeMessageTooLong
+	}
+	return false
+}
+
+// Detail describes the error for REST bodies and WebSocket frames
+func (e *ValidationError) Detail() *model.ErrorDetail {
+	return &model.ErrorDetail{Code: e.Code, Field: e.Field, Limit: e.Limit}
+}
+
+// ContentValidator cleans up and checks user-written text before it is
+// stored, whichever transport it came in on. Lengths are counted in
+// characters (runes) after cleaning, so Thai vowel and tone marks each
+// count as one, as they do when typed.
+type ContentValidator struct {
+	maxRunes int
+}
+
+func NewContentValidator(maxRunes int) *ContentValidator {
+	return &ContentValidator{maxRunes: maxRunes}
+}
+
+// Message cleans message content, which must not end up empty
+func (v *ContentValidator) Message(content string) (string, error) {
+	content = cleanText(content)
+	if content == "" {
+		return "", &ValidationError{Field: "content", Code: model.ErrCodeEmptyMessage}
+	}
+	return content, v.checkLength("content", content)
+}
+
+// Caption cleans the optional caption of an attachment
+func (v *ContentValidator) Caption(caption string) (string, error) {
+	caption = cleanText(caption)
+	return caption, v.checkLength("caption", caption)
+}
+
+func (v *ContentValidator) checkLength(field, s string) error {
+	return checkLength(field, s, v.maxRunes)
+}
+
+func checkLength(field, s string, maxRunes int) error {
+	if utf8.RuneCountInString(s) > maxRunes {
+		return &ValidationError{Field: field, Code: model.ErrCodeMessageTooLong, Limit: maxRunes}
+	}
+	return nil
+}
+
+// cleanText puts text into a canonical form: invalid UTF-8 is replaced,
+// the text is NFC-normalized, line endings become \n, control characters
+// other than newline and tab are dropped, as are bidi overrides that could
+// make a message display differently from what it says, and surrounding
+// whitespace is trimmed.
+func cleanText(s string) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = norm.NFC.String(s)
+
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n', r == '\t':
+			return r
+		case r == '\r':
+			return '\n'
+		case unicode.IsControl(r), isBidiControl(r):
+			return -1
+		}
+		return r
+	}, s)
+
+	return strings.TrimSpace(s)
+}
+
+// isBidiControl reports whether r is an explicit bidi embedding, override
+// or isolate (U+202A–U+202E, U+2066–U+2069)
+func isBidiControl(r rune) bool {
+	return (r >= '\u202A' && r <= '\u202E') || (r >= '\u2066' && r <= '\u2069')
+}
@@ -0,0 +1,58 @@
+package service
+
+import "testing"
+
+func TestCleanText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"unchanged", "hello", "hello"},
+		{"trimmed", "  hello \n", "hello"},
+		{"crlf", "a\r\nb", "a\nb"},
+		{"lone cr", "a\rb", "a\nb"},
+		{"tab kept", "a\tb", "a\tb"},
+		{"control dropped", "a\x00b\x1bc", "abc"},
+		{"invalid utf-8", "a\xffb", "a\ufffdb"},
+		{"nfc", "e\u0301", "\u00e9"},
+		{"bidi override", "\u202egnp.exe", "gnp.exe"},
+		{"bidi isolate", "a\u2066b\u2069", "ab"},
+		{"only whitespace", " \r\n\t ", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanText(tt.in); got != tt.want {
+				t.Errorf("cleanText(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	v := NewContentValidator(5)
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"empty message", func() error { _, err := v.Message(" "); return err }(), "Message is empty"},
+		{"long message", func() error { _, err := v.Message("123456"); return err }(), "Message must be at most 5 characters"},
+		{"long caption", func() error { _, err := v.Caption("123456"); return err }(), "Caption must be at most 5 characters"},
+		{"long reason", checkLength("reason", "123", 2), "Reason must be at most 2 characters"},
+		{"long details", checkLength("details", "123", 2), "Details must be at most 2 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.err == nil {
+				t.Fatal("got no error")
+			}
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
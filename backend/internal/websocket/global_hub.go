@@ -3,10 +3,13 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"hash/fnv"
 	"log"
 	"sync"
 
 	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/middleware"
 	"github.com/khonE3/chat-backend/internal/model"
 	"github.com/khonE3/chat-backend/internal/repository"
 )
@@ -14,17 +17,20 @@ import (
 // GlobalClient represents a client subscribed to global updates
 type GlobalClient struct {
 	ID     string
-	UserID string
+	UserID uuid.UUID // uuid.Nil for anonymous clients
 	Conn   *websocket.Conn
 	Send   chan []byte
 	Hub    *GlobalHub
 	mu     sync.Mutex
 }
 
-// GlobalHub maintains global subscribers for homepage updates
+// GlobalHub maintains global subscribers for homepage updates. Room events
+// only reach clients who can see the room, and events about a user's own
+// state (unread counts, mentions) only reach that user.
 type GlobalHub struct {
-	// All connected global clients
-	clients map[*GlobalClient]bool
+	// Connected global clients by user ID; anonymous clients under uuid.Nil
+	clients map[uuid.UUID]map[*GlobalClient]bool
+	count   int
 
 	// Register requests
 	register chan *GlobalClient
@@ -35,12 +41,34 @@ type GlobalHub struct {
 	// Broadcast to all clients
 	broadcast chan []byte
 
-	// Room repository for fetching room stats
+	// Room repository for room stats, membership and unread counts
 	roomRepo *repository.RoomRepository
 
+	// Relays user and room events between instances
+	pubsubRepo *repository.PubSubRepository
+
+	// Room events waiting for delivery. A room always maps to the same
+	// worker, so its events reach clients in the order they happened.
+	roomEvents []chan roomEvent
+
 	mu sync.RWMutex
 }
 
+// roomEvent is a room event waiting to be delivered to local clients
+type roomEvent struct {
+	roomID string
+	data   []byte
+}
+
+const (
+	// globalMaxFrameBytes caps frames read from global clients
+	globalMaxFrameBytes = 512
+
+	// roomEventWorkers deliver room events, each for its own share of rooms
+	roomEventWorkers   = 8
+	roomEventQueueSize = 256
+)
+
 // GlobalMessage types
 type GlobalMessageType string
 
@@ -49,6 +77,11 @@ const (
 	GlobalTypeRoomCreated GlobalMessageType = "room_created"
 	GlobalTypeRoomDeleted GlobalMessageType = "room_deleted"
 	GlobalTypePresence    GlobalMessageType = "global_presence"
+	GlobalTypeMention     GlobalMessageType = "mention"
+
+	// globalTypeNewMessage is relayed between instances only; each instance
+	// turns it into room_stats with the unread count of each local client
+	globalTypeNewMessage GlobalMessageType = "new_message"
 )
 
 type GlobalMessage struct {
@@ -58,52 +91,70 @@ type GlobalMessage struct {
 
 type RoomStatsPayload struct {
 	RoomID      string `json:"room_id"`
-	OnlineCount int    `json:"online_count"`
-	UnreadCount int    `json:"unread_count,omitempty"`
+	OnlineCount *int   `json:"online_count,omitempty"`
+	HasNewMsg   bool   `json:"has_new_msg,omitempty"`
+	UnreadCount *int   `json:"unread_count,omitempty"`
 }
 
 type GlobalPresencePayload struct {
 	TotalOnline int `json:"total_online"`
 }
 
-func NewGlobalHub(roomRepo *repository.RoomRepository) *GlobalHub {
+func NewGlobalHub(roomRepo *repository.RoomRepository, pubsubRepo *repository.PubSubRepository) *GlobalHub {
+	roomEvents := make([]chan roomEvent, roomEventWorkers)
+	for i := range roomEvents {
+		roomEvents[i] = make(chan roomEvent, roomEventQueueSize)
+	}
+
 	return &GlobalHub{
-		clients:    make(map[*GlobalClient]bool),
+		clients:    make(map[uuid.UUID]map[*GlobalClient]bool),
 		register:   make(chan *GlobalClient),
 		unregister: make(chan *GlobalClient),
 		broadcast:  make(chan []byte, 256),
 		roomRepo:   roomRepo,
+		pubsubRepo: pubsubRepo,
+		roomEvents: roomEvents,
 	}
 }
 
 func (h *GlobalHub) Run() {
+	go h.listenEvents()
+	for _, events := range h.roomEvents {
+		go h.deliverRoomEvents(events)
+	}
+
 	for {
 		select {
 		case client := <-h.register:
 			h.mu.Lock()
-			h.clients[client] = true
+			if _, ok := h.clients[client.UserID]; !ok {
+				h.clients[client.UserID] = make(map[*GlobalClient]bool)
+			}
+			h.clients[client.UserID][client] = true
+			h.count++
 			h.mu.Unlock()
-			log.Printf("🌐 Global client connected: %s (total: %d)", client.UserID, len(h.clients))
+			log.Printf("🌐 Global client connected: %s (total: %d)", client.ID, h.GetOnlineCount())
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.Send)
+			if clients, ok := h.clients[client.UserID]; ok {
+				if _, ok := clients[client]; ok {
+					delete(clients, client)
+					close(client.Send)
+					h.count--
+					if len(clients) == 0 {
+						delete(h.clients, client.UserID)
+					}
+				}
 			}
 			h.mu.Unlock()
-			log.Printf("🌐 Global client disconnected: %s (total: %d)", client.UserID, len(h.clients))
+			log.Printf("🌐 Global client disconnected: %s (total: %d)", client.ID, h.GetOnlineCount())
 
 		case message := <-h.broadcast:
 			h.mu.RLock()
-			for client := range h.clients {
-				select {
-				case client.Send <- message:
-				default:
-					// Client buffer full, remove it
-					go func(c *GlobalClient) {
-						h.unregister <- c
-					}(client)
+			for _, clients := range h.clients {
+				for client := range clients {
+					h.queue(client, message)
 				}
 			}
 			h.mu.RUnlock()
@@ -111,46 +162,46 @@ func (h *GlobalHub) Run() {
 	}
 }
 
-// BroadcastRoomStats sends room statistics update to all global clients
+// queue hands data to a client without blocking. Must be called with h.mu
+// held for reading.
+func (h *GlobalHub) queue(client *GlobalClient, data []byte) {
+	select {
+	case client.Send <- data:
+	default:
+		// Client buffer full, remove it
+		go func(c *GlobalClient) {
+			h.unregister <- c
+		}(client)
+	}
+}
+
+// BroadcastRoomStats sends a room's online count to everyone who can see
+// the room, on every instance
 func (h *GlobalHub) BroadcastRoomStats(roomID string, onlineCount int) {
-	msg := GlobalMessage{
+	h.publishRoomEvent(roomID, GlobalMessage{
 		Type: GlobalTypeRoomStats,
 		Payload: RoomStatsPayload{
 			RoomID:      roomID,
-			OnlineCount: onlineCount,
+			OnlineCount: &onlineCount,
 		},
-	}
-	data, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("Failed to marshal room stats: %v", err)
-		return
-	}
-	h.broadcast <- data
+	})
 }
 
-// BroadcastNewMessage notifies about new message for unread count
-func (h *GlobalHub) BroadcastNewMessage(roomID string, senderUserID string) {
-	// Broadcast that room has new message
-	msg := GlobalMessage{
-		Type: GlobalTypeRoomStats,
-		Payload: map[string]interface{}{
-			"room_id":     roomID,
-			"has_new_msg": true,
-			"sender_id":   senderUserID,
-		},
-	}
-	data, _ := json.Marshal(msg)
-	h.broadcast <- data
+// BroadcastNewMessage tells everyone who can see the room that it has a new
+// message. Members also get their own unread count.
+func (h *GlobalHub) BroadcastNewMessage(roomID string) {
+	h.publishRoomEvent(roomID, GlobalMessage{
+		Type:    globalTypeNewMessage,
+		Payload: RoomStatsPayload{RoomID: roomID, HasNewMsg: true},
+	})
 }
 
 // BroadcastRoomCreated notifies about new room
 func (h *GlobalHub) BroadcastRoomCreated(room *model.Room) {
-	msg := GlobalMessage{
+	h.publishRoomEvent(room.ID.String(), GlobalMessage{
 		Type:    GlobalTypeRoomCreated,
 		Payload: room,
-	}
-	data, _ := json.Marshal(msg)
-	h.broadcast <- data
+	})
 }
 
 // BroadcastTotalOnline sends total online count
@@ -165,12 +216,212 @@ func (h *GlobalHub) BroadcastTotalOnline(total int) {
 	h.broadcast <- data
 }
 
-// HandleGlobalWebSocket handles WebSocket connection for global updates
+// NotifyMention tells a user, on whichever instance they are connected,
+// that a message mentioned them
+func (h *GlobalHub) NotifyMention(userID uuid.UUID, payload *model.MentionPayload) {
+	h.SendToUser(userID, GlobalMessage{
+		Type:    GlobalTypeMention,
+		Payload: payload,
+	})
+}
+
+// SendToUser delivers msg to every connection of a signed-in user, on this
+// instance and the others
+func (h *GlobalHub) SendToUser(userID uuid.UUID, msg GlobalMessage) {
+	if userID == uuid.Nil {
+		return
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to marshal %s: %v", msg.Type, err)
+		return
+	}
+
+	if err := h.pubsubRepo.PublishUserEvent(context.Background(), userID.String(), data); err != nil {
+		log.Printf("Failed to publish %s for user %s: %v", msg.Type, userID, err)
+	}
+
+	h.deliverToUser(userID, data)
+}
+
+// SendUnreadCount sends a user their unread count for one room
+func (h *GlobalHub) SendUnreadCount(roomID, userID uuid.UUID) {
+	unread, err := h.roomRepo.GetUnreadCount(context.Background(), roomID, userID)
+	if err != nil {
+		log.Printf("Failed to count unread messages in room %s: %v", roomID, err)
+		return
+	}
+
+	h.SendToUser(userID, GlobalMessage{
+		Type: GlobalTypeRoomStats,
+		Payload: RoomStatsPayload{
+			RoomID:      roomID.String(),
+			UnreadCount: &unread,
+		},
+	})
+}
+
+// deliverToUser queues data on the user's local connections
+func (h *GlobalHub) deliverToUser(userID uuid.UUID, data []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients[userID] {
+		h.queue(client, data)
+	}
+}
+
+// publishRoomEvent shares a room event with the other instances and
+// delivers it to local clients
+func (h *GlobalHub) publishRoomEvent(roomID string, msg GlobalMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to marshal %s: %v", msg.Type, err)
+		return
+	}
+
+	if err := h.pubsubRepo.PublishRoomEvent(context.Background(), roomID, data); err != nil {
+		log.Printf("Failed to publish %s for room %s: %v", msg.Type, roomID, err)
+	}
+
+	h.enqueueRoomEvent(roomID, data)
+}
+
+// enqueueRoomEvent hands a room event to the worker for its room without
+// blocking. If the worker has fallen that far behind the event is dropped;
+// the next one for the room brings clients up to date.
+func (h *GlobalHub) enqueueRoomEvent(roomID string, data []byte) {
+	hash := fnv.New32a()
+	hash.Write([]byte(roomID))
+
+	select {
+	case h.roomEvents[hash.Sum32()%roomEventWorkers] <- roomEvent{roomID: roomID, data: data}:
+	default:
+		log.Printf("⚠️ Dropped global event for room %s: delivery queue full", roomID)
+	}
+}
+
+// deliverRoomEvents delivers queued room events one at a time
+func (h *GlobalHub) deliverRoomEvents(events <-chan roomEvent) {
+	for event := range events {
+		h.deliverRoomEvent(event.roomID, event.data)
+	}
+}
+
+// deliverRoomEvent sends a room event to the local clients who can see the
+// room: everyone for public rooms, members for private rooms, DMs and
+// groups. A new message becomes a room_stats with each member's own unread
+// count; non-members of public rooms just learn there is something new.
+func (h *GlobalHub) deliverRoomEvent(roomID string, data []byte) {
+	var event struct {
+		Type    GlobalMessageType `json:"type"`
+		Payload RoomStatsPayload  `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.Printf("Failed to decode global room event: %v", err)
+		return
+	}
+
+	roomUUID, err := uuid.Parse(roomID)
+	if err != nil {
+		return
+	}
+
+	ctx := context.Background()
+	room, err := h.roomRepo.GetByID(ctx, roomUUID)
+	if err != nil {
+		log.Printf("Failed to load room %s for global event: %v", roomID, err)
+		return
+	}
+
+	h.mu.RLock()
+	recipients := make(map[uuid.UUID][]*GlobalClient, len(h.clients))
+	userIDs := make([]uuid.UUID, 0, len(h.clients))
+	for userID, clients := range h.clients {
+		for client := range clients {
+			recipients[userID] = append(recipients[userID], client)
+		}
+		if userID != uuid.Nil {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	h.mu.RUnlock()
+
+	// One query finds which local users are members and their unread counts
+	unread, err := h.roomRepo.UnreadCounts(ctx, roomUUID, userIDs)
+	if err != nil {
+		log.Printf("Failed to count unread messages in room %s: %v", roomID, err)
+		return
+	}
+
+	for userID, clients := range recipients {
+		count, member := unread[userID]
+		if !member && room.IsPrivate {
+			continue
+		}
+
+		msg := data
+		if event.Type == globalTypeNewMessage {
+			payload := RoomStatsPayload{RoomID: roomID, HasNewMsg: true}
+			if member {
+				payload.UnreadCount = &count
+			}
+			msg, _ = json.Marshal(GlobalMessage{Type: GlobalTypeRoomStats, Payload: payload})
+		}
+
+		h.mu.RLock()
+		for _, client := range clients {
+			if h.clients[userID][client] {
+				h.queue(client, msg)
+			}
+		}
+		h.mu.RUnlock()
+	}
+}
+
+// listenEvents delivers user and room events published by other instances
+func (h *GlobalHub) listenEvents() {
+	pubsub := h.pubsubRepo.SubscribeGlobalEvents(context.Background())
+	defer pubsub.Close()
+
+	for redisMsg := range pubsub.Channel() {
+		env, err := h.pubsubRepo.DecodeEnvelope(redisMsg.Payload)
+		if err != nil {
+			log.Printf("Failed to decode global event: %v", err)
+			continue
+		}
+
+		// Our own publications were already delivered locally
+		if h.pubsubRepo.IsOwn(env) {
+			continue
+		}
+
+		switch {
+		case env.UserID != "":
+			userID, err := uuid.Parse(env.UserID)
+			if err == nil {
+				h.deliverToUser(userID, env.Message)
+			}
+		case env.RoomID != "":
+			h.enqueueRoomEvent(env.RoomID, env.Message)
+		}
+	}
+}
+
+// HandleGlobalWebSocket handles WebSocket connection for global updates.
+// Signed-in clients are identified by their session; anyone else only
+// sees public rooms.
 func (h *GlobalHub) HandleGlobalWebSocket(c *websocket.Conn) {
-	userID := c.Query("userId", "anonymous")
+	userID := uuid.Nil
+	clientID := "anonymous"
+	if session, ok := c.Locals(middleware.LocalsSession).(*model.Session); ok {
+		userID = session.UserID
+		clientID = session.UserID.String()
+	}
 
 	client := &GlobalClient{
-		ID:     userID,
+		ID:     clientID,
 		UserID: userID,
 		Conn:   c,
 		Send:   make(chan []byte, 256),
@@ -179,18 +430,34 @@ func (h *GlobalHub) HandleGlobalWebSocket(c *websocket.Conn) {
 
 	h.register <- client
 
-	// Send initial room stats
+	// Send initial room list: public rooms, plus the private rooms a signed-in
+	// user belongs to, with their unread counts
 	go func() {
 		ctx := context.Background()
-		rooms, err := h.roomRepo.List(ctx, false)
-		if err == nil {
-			msg := GlobalMessage{
-				Type:    "rooms_init",
-				Payload: rooms,
-			}
-			data, _ := json.Marshal(msg)
-			client.Send <- data
+
+		var rooms []model.RoomWithMembers
+		var err error
+		if userID == uuid.Nil {
+			rooms, err = h.roomRepo.List(ctx, false)
+		} else {
+			rooms, err = h.roomRepo.ListWithUnread(ctx, userID)
 		}
+		if err != nil {
+			log.Printf("Failed to list rooms for global client %s: %v", clientID, err)
+			return
+		}
+
+		msg := GlobalMessage{
+			Type:    "rooms_init",
+			Payload: rooms,
+		}
+		data, _ := json.Marshal(msg)
+
+		h.mu.RLock()
+		if h.clients[userID][client] {
+			h.queue(client, data)
+		}
+		h.mu.RUnlock()
 	}()
 
 	// Start goroutines
@@ -204,12 +471,15 @@ func (c *GlobalClient) readPump() {
 		c.Conn.Close()
 	}()
 
+	// Global clients don't send messages, just receive, so anything but a
+	// tiny frame is a misbehaving client
+	c.Conn.SetReadLimit(globalMaxFrameBytes)
+
 	for {
 		_, _, err := c.Conn.ReadMessage()
 		if err != nil {
 			break
 		}
-		// Global clients don't send messages, just receive
 	}
 }
 
@@ -231,5 +501,5 @@ func (c *GlobalClient) writePump() {
 func (h *GlobalHub) GetOnlineCount() int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return len(h.clients)
+	return h.count
 }
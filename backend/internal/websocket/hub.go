@@ -1,19 +1,28 @@
 package websocket
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/google/uuid"
+	"github.com/khonE3/chat-backend/internal/middleware"
 	"github.com/khonE3/chat-backend/internal/model"
 	"github.com/khonE3/chat-backend/internal/repository"
 	"github.com/khonE3/chat-backend/internal/service"
+	"github.com/redis/go-redis/v9"
 )
 
+// unfurlTimeout bounds fetching all link previews of one message
+const unfurlTimeout = 20 * time.Second
+
 // Client represents a WebSocket client connection
 type Client struct {
 	ID          string
@@ -21,10 +30,16 @@ type Client struct {
 	Username    string
 	DisplayName string
 	RoomID      string
+	Since       string // last seen message ID or seq when reconnecting
 	Conn        *websocket.Conn
 	Hub         *Hub
 	Send        chan []byte
 	mu          sync.Mutex
+
+	// mute is the user's mute or timeout in the room, loaded on connect and
+	// kept current by moderation events, so muted clients are turned away
+	// without a database round trip. The service still has the final say.
+	mute atomic.Pointer[model.RoomRestriction]
 }
 
 // Hub maintains the set of active clients and broadcasts messages
@@ -41,32 +56,56 @@ type Hub struct {
 	// Broadcast to room
 	broadcast chan *RoomMessage
 
+	// Redis subscriptions for rooms with at least one local client
+	subscriptions map[string]*roomSubscription
+
 	// Services
 	chatService     *service.ChatService
 	presenceService *service.PresenceService
+	roomService     *service.RoomService
+	previewService  *service.LinkPreviewService // nil when link previews are off
+	mentionService  *service.MentionService
+	rateLimiter     *service.RateLimitService
 	pubsubRepo      *repository.PubSubRepository
 
 	// Global hub for homepage updates
 	globalHub *GlobalHub
 
+	// Frames larger than this close the connection
+	maxFrameBytes int64
+
 	mu sync.RWMutex
 }
 
+// roomSubscription is a room's Redis subscription. SUBSCRIBE is a network
+// round trip, so it is made off the Run loop and without h.mu; ready is
+// closed once it is done.
+type roomSubscription struct {
+	pubsub *redis.PubSub // nil until ready
+	ready  chan struct{}
+}
+
 type RoomMessage struct {
 	RoomID  string
 	Message []byte
 }
 
-func NewHub(chatService *service.ChatService, presenceService *service.PresenceService, pubsubRepo *repository.PubSubRepository, globalHub *GlobalHub) *Hub {
+func NewHub(chatService *service.ChatService, presenceService *service.PresenceService, roomService *service.RoomService, previewService *service.LinkPreviewService, mentionService *service.MentionService, rateLimiter *service.RateLimitService, pubsubRepo *repository.PubSubRepository, globalHub *GlobalHub, maxFrameBytes int64) *Hub {
 	return &Hub{
 		rooms:           make(map[string]map[*Client]bool),
 		register:        make(chan *Client),
 		unregister:      make(chan *Client),
 		broadcast:       make(chan *RoomMessage),
+		subscriptions:   make(map[string]*roomSubscription),
 		chatService:     chatService,
 		presenceService: presenceService,
+		roomService:     roomService,
+		previewService:  previewService,
+		mentionService:  mentionService,
+		rateLimiter:     rateLimiter,
 		pubsubRepo:      pubsubRepo,
 		globalHub:       globalHub,
+		maxFrameBytes:   maxFrameBytes,
 	}
 }
 
@@ -94,10 +133,21 @@ func (h *Hub) registerClient(client *Client) {
 	}
 	h.rooms[client.RoomID][client] = true
 
+	sub, ok := h.subscriptions[client.RoomID]
+	if !ok {
+		sub = &roomSubscription{ready: make(chan struct{})}
+		h.subscriptions[client.RoomID] = sub
+		go h.subscribeRoom(client.RoomID, sub)
+	}
+
 	log.Printf("👤 Client %s joined room %s", client.Username, client.RoomID)
 
 	// Update presence
 	go func() {
+		// Load history only once events from other instances are relayed,
+		// so none published in between are missed
+		<-sub.ready
+
 		ctx := context.Background()
 		h.presenceService.UserJoined(ctx, client.RoomID, client.UserID.String(), &model.User{
 			ID:          client.UserID,
@@ -106,18 +156,10 @@ func (h *Hub) registerClient(client *Client) {
 		})
 
 		// Publish presence update
-		h.pubsubRepo.PublishPresence(ctx, client.RoomID, &model.PresencePayload{
-			UserID:      client.UserID.String(),
-			Username:    client.Username,
-			DisplayName: client.DisplayName,
-			IsOnline:    true,
-		})
+		h.announcePresence(ctx, client, true)
 
 		// Notify global hub about room stats change (for homepage real-time updates)
-		if h.globalHub != nil {
-			onlineCount := len(h.rooms[client.RoomID])
-			h.globalHub.BroadcastRoomStats(client.RoomID, onlineCount)
-		}
+		h.broadcastRoomStats(ctx, client.RoomID)
 
 		// Send online users list to the new client
 		onlineUsers, err := h.presenceService.GetOnlineUsers(ctx, client.RoomID)
@@ -129,19 +171,57 @@ func (h *Hub) registerClient(client *Client) {
 			Payload: onlineUsers,
 		})
 
-		// Send recent message history
-		messages, err := h.chatService.GetRecentMessages(ctx, client.RoomID, 50)
+		// A reconnecting client gets exactly what it missed, anyone else the
+		// recent message history
+		if client.Since != "" && h.sendReplay(ctx, client) {
+			return
+		}
+
+		messages, err := h.chatService.GetRecentMessages(ctx, client.RoomID, 50, client.UserID)
 		if err != nil {
 			log.Printf("Failed to get recent messages for room %s: %v", client.RoomID, err)
 		}
 		h.sendToClient(client, model.WSMessage{
 			Type:    model.WSTypeHistory,
 			Payload: messages,
+			Seq:     lastSeq(messages),
 		})
 	}()
 }
 
+// sendReplay sends the messages missed since client.Since. It reports false
+// when the client should get the regular history instead.
+func (h *Hub) sendReplay(ctx context.Context, client *Client) bool {
+	replay, err := h.chatService.ReplaySince(ctx, client.RoomID, client.Since, client.UserID)
+	if err != nil {
+		if !errors.Is(err, service.ErrInvalidSince) && !errors.Is(err, service.ErrMessageNotFound) {
+			log.Printf("Failed to replay room %s since %s: %v", client.RoomID, client.Since, err)
+		}
+		return false
+	}
+
+	seq := lastSeq(replay.Messages)
+	if seq == 0 {
+		seq = replay.Since
+	}
+	h.sendToClient(client, model.WSMessage{
+		Type:    model.WSTypeReplay,
+		Payload: replay,
+		Seq:     seq,
+	})
+	return true
+}
+
+func lastSeq(messages []model.MessageWithUser) int64 {
+	if len(messages) == 0 {
+		return 0
+	}
+	return messages[len(messages)-1].Seq
+}
+
 func (h *Hub) unregisterClient(client *Client) {
+	var unsubscribe *redis.PubSub
+
 	h.mu.Lock()
 	if clients, ok := h.rooms[client.RoomID]; ok {
 		if _, ok := clients[client]; ok {
@@ -150,11 +230,16 @@ func (h *Hub) unregisterClient(client *Client) {
 
 			if len(clients) == 0 {
 				delete(h.rooms, client.RoomID)
+				unsubscribe = h.dropSubscription(client.RoomID)
 			}
 		}
 	}
 	h.mu.Unlock()
 
+	if unsubscribe != nil {
+		go h.closeSubscription(client.RoomID, unsubscribe)
+	}
+
 	log.Printf("👋 Client %s left room %s", client.Username, client.RoomID)
 
 	// Update presence
@@ -163,26 +248,28 @@ func (h *Hub) unregisterClient(client *Client) {
 		h.presenceService.UserLeft(ctx, client.RoomID, client.UserID.String())
 
 		// Publish presence update
-		h.pubsubRepo.PublishPresence(ctx, client.RoomID, &model.PresencePayload{
-			UserID:      client.UserID.String(),
-			Username:    client.Username,
-			DisplayName: client.DisplayName,
-			IsOnline:    false,
-		})
+		h.announcePresence(ctx, client, false)
 
 		// Notify global hub about room stats change (for homepage real-time updates)
-		if h.globalHub != nil {
-			h.mu.RLock()
-			onlineCount := 0
-			if clients, ok := h.rooms[client.RoomID]; ok {
-				onlineCount = len(clients)
-			}
-			h.mu.RUnlock()
-			h.globalHub.BroadcastRoomStats(client.RoomID, onlineCount)
-		}
+		h.broadcastRoomStats(ctx, client.RoomID)
 	}()
 }
 
+// broadcastRoomStats sends the room's online count, across all instances,
+// to the global hub
+func (h *Hub) broadcastRoomStats(ctx context.Context, roomID string) {
+	if h.globalHub == nil {
+		return
+	}
+
+	onlineCount, err := h.presenceService.GetOnlineCount(ctx, roomID)
+	if err != nil {
+		log.Printf("Failed to count online users in room %s: %v", roomID, err)
+		return
+	}
+	h.globalHub.BroadcastRoomStats(roomID, int(onlineCount))
+}
+
 func (h *Hub) broadcastToRoom(roomMsg *RoomMessage) {
 	h.mu.RLock()
 	clients, ok := h.rooms[roomMsg.RoomID]
@@ -192,15 +279,344 @@ func (h *Hub) broadcastToRoom(roomMsg *RoomMessage) {
 		return
 	}
 
+	removed := removedMembers(roomMsg.Message)
+	moderation := moderationEvent(roomMsg.Message)
+
 	for client := range clients {
+		disconnect := removed[client.UserID]
+		if moderation != nil && client.UserID == moderation.UserID {
+			client.applyModeration(moderation)
+			disconnect = disconnect || moderation.Action.Disconnects()
+		}
+
 		select {
 		case client.Send <- roomMsg.Message:
 		default:
-			h.unregister <- client
+			// Client buffer full, remove it without blocking the hub loop
+			go func(c *Client) {
+				h.unregister <- c
+			}(client)
+			continue
+		}
+
+		// Members who were removed, kicked or banned get the event, then
+		// their connection closes once it has been written
+		if disconnect {
+			go func(c *Client) {
+				h.unregister <- c
+			}(client)
+		}
+	}
+}
+
+// removedMembers returns the users a members_changed event takes out of the
+// room, or nil for any other event
+func removedMembers(data []byte) map[uuid.UUID]bool {
+	if !bytes.Contains(data, []byte(model.WSTypeMembers)) {
+		return nil
+	}
+
+	var event struct {
+		Type    model.WSMessageType         `json:"type"`
+		Payload model.MembersChangedPayload `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil || event.Type != model.WSTypeMembers {
+		return nil
+	}
+	if event.Payload.Action == model.MembershipAdded {
+		return nil
+	}
+
+	removed := make(map[uuid.UUID]bool, len(event.Payload.Users))
+	for _, u := range event.Payload.Users {
+		removed[u.ID] = true
+	}
+	return removed
+}
+
+// moderationEvent returns the payload of a moderation event, or nil for any
+// other event
+func moderationEvent(data []byte) *model.ModerationPayload {
+	if !bytes.Contains(data, []byte(model.WSTypeModeration)) {
+		return nil
+	}
+
+	var event struct {
+		Type    model.WSMessageType     `json:"type"`
+		Payload model.ModerationPayload `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil || event.Type != model.WSTypeModeration {
+		return nil
+	}
+	return &event.Payload
+}
+
+// BroadcastToRoom delivers an event to every client in the room on all
+// instances. Used for events that originate outside a WebSocket, e.g. REST.
+func (h *Hub) BroadcastToRoom(roomID string, msg model.WSMessage) {
+	if err := h.pubsubRepo.Publish(context.Background(), roomID, msg); err != nil {
+		log.Printf("Failed to publish %s to room %s: %v", msg.Type, roomID, err)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	h.broadcast <- &RoomMessage{
+		RoomID:  roomID,
+		Message: data,
+	}
+}
+
+// BroadcastMessage delivers a newly stored timeline message to the room on
+// all instances and tells the global hub about it
+func (h *Hub) BroadcastMessage(msg *model.MessageWithUser) {
+	roomID := msg.RoomID.String()
+
+	// Share with other instances
+	if err := h.pubsubRepo.PublishMessage(context.Background(), roomID, msg); err != nil {
+		log.Printf("Failed to publish message: %v", err)
+	}
+
+	data, _ := json.Marshal(model.WSMessage{
+		Type:    model.WSTypeMessage,
+		Payload: msg,
+		Seq:     msg.Seq,
+	})
+	h.broadcast <- &RoomMessage{
+		RoomID:  roomID,
+		Message: data,
+	}
+
+	// Notify global hub about new message (for homepage unread counts)
+	if h.globalHub != nil {
+		h.globalHub.BroadcastNewMessage(roomID)
+	}
+}
+
+// AnnounceMessage delivers a newly posted message, then notifies anyone it
+// mentions and unfurls its links. Thread replies stay out of the timeline;
+// the room gets a thread_reply instead.
+func (h *Hub) AnnounceMessage(ctx context.Context, msg *model.MessageWithUser) {
+	if msg.ThreadRootID != nil {
+		h.broadcastThreadReply(ctx, msg)
+	} else {
+		h.BroadcastMessage(msg)
+	}
+
+	h.NotifyMentions(msg)
+	h.UnfurlLinks(&msg.Message)
+}
+
+// AckHeld sends the ack for an approved held message to its sender's
+// connections to the room on this instance. A sender connected elsewhere
+// gets it by resending with the same nonce.
+func (h *Hub) AckHeld(msg *model.MessageWithUser) {
+	if msg.Nonce == nil || msg.UserID == nil {
+		return
+	}
+
+	ack := model.WSMessage{
+		Type: model.WSTypeAck,
+		Payload: model.AckPayload{
+			Nonce:     *msg.Nonce,
+			MessageID: msg.ID,
+			Seq:       msg.Seq,
+			CreatedAt: msg.CreatedAt,
+		},
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.rooms[msg.RoomID.String()] {
+		if client.UserID == *msg.UserID {
+			h.sendToClient(client, ack)
+		}
+	}
+}
+
+// broadcastThreadReply tells the room about a new reply along with the
+// root's updated reply count
+func (h *Hub) broadcastThreadReply(ctx context.Context, reply *model.MessageWithUser) {
+	root, err := h.chatService.GetMessage(ctx, *reply.ThreadRootID)
+	if err != nil {
+		log.Printf("Failed to load thread root %s: %v", reply.ThreadRootID, err)
+		return
+	}
+
+	payload := model.ThreadReplyPayload{
+		RootID:     root.ID.String(),
+		Message:    reply,
+		ReplyCount: root.ReplyCount,
+	}
+	if root.LastReplyAt != nil {
+		payload.LastReplyAt = *root.LastReplyAt
+	}
+
+	h.BroadcastToRoom(reply.RoomID.String(), model.WSMessage{
+		Type:    model.WSTypeThreadReply,
+		Payload: payload,
+	})
+}
+
+// AnnounceModeration sends the system message recording a moderation
+// action, then the moderation event, which disconnects the target of a kick
+// or ban once they have seen why
+func (h *Hub) AnnounceModeration(result *service.ModerationResult) {
+	h.BroadcastMessage(result.SystemMessage)
+	h.BroadcastToRoom(result.Event.RoomID.String(), model.WSMessage{
+		Type:    model.WSTypeModeration,
+		Payload: result.Event,
+	})
+}
+
+// UnfurlLinks fetches previews for links in msg in the background and sends
+// the room a message_update once they are stored
+func (h *Hub) UnfurlLinks(msg *model.Message) {
+	if h.previewService == nil {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), unfurlTimeout)
+		defer cancel()
+
+		update, err := h.previewService.Unfurl(ctx, msg)
+		if err != nil {
+			log.Printf("Failed to unfurl links in message %s: %v", msg.ID, err)
+			return
+		}
+		if update == nil {
+			return
+		}
+
+		h.BroadcastToRoom(update.RoomID, model.WSMessage{
+			Type:    model.WSTypeUpdate,
+			Payload: update,
+		})
+	}()
+}
+
+// SyncUnread sends a user their current unread count for a room on the
+// global WebSocket, e.g. after they read it on another device
+func (h *Hub) SyncUnread(roomID, userID uuid.UUID) {
+	if h.globalHub == nil {
+		return
+	}
+	go h.globalHub.SendUnreadCount(roomID, userID)
+}
+
+// NotifyMentions records who msg mentions and pushes a mention event to
+// each of them on the global WebSocket, in or out of the room
+func (h *Hub) NotifyMentions(msg *model.MessageWithUser) {
+	go func() {
+		notices, err := h.mentionService.Record(context.Background(), msg)
+		if err != nil {
+			log.Printf("Failed to record mentions in message %s: %v", msg.ID, err)
+			return
+		}
+
+		if h.globalHub == nil {
+			return
+		}
+		for _, notice := range notices {
+			h.globalHub.NotifyMention(notice.UserID, notice.Payload)
+		}
+	}()
+}
+
+// subscribeRoom subscribes to a room's Redis channels and starts relaying
+// their events to local clients. It runs on its own goroutine; if the room's
+// last local client left in the meantime the subscription is closed again.
+func (h *Hub) subscribeRoom(roomID string, sub *roomSubscription) {
+	pubsub := h.pubsubRepo.Subscribe(context.Background(), roomID)
+
+	h.mu.Lock()
+	current := h.subscriptions[roomID] == sub
+	if current {
+		sub.pubsub = pubsub
+	}
+	h.mu.Unlock()
+	close(sub.ready)
+
+	if !current {
+		h.closeSubscription(roomID, pubsub)
+		return
+	}
+
+	log.Printf("📡 Subscribed to room %s (instance %s)", roomID, h.pubsubRepo.InstanceID())
+
+	h.listenRoom(roomID, pubsub)
+}
+
+// dropSubscription forgets a room's subscription once its last local client
+// has left, returning the one to close, if it is ready. One still being made
+// is closed by subscribeRoom. Must be called with h.mu held.
+func (h *Hub) dropSubscription(roomID string) *redis.PubSub {
+	sub, ok := h.subscriptions[roomID]
+	if !ok {
+		return nil
+	}
+	delete(h.subscriptions, roomID)
+	return sub.pubsub
+}
+
+// closeSubscription stops relaying Redis events for a room. It talks to
+// Redis, so call it off the Run loop and without h.mu held.
+func (h *Hub) closeSubscription(roomID string, pubsub *redis.PubSub) {
+	if err := pubsub.Close(); err != nil {
+		log.Printf("Failed to close subscription for room %s: %v", roomID, err)
+	}
+
+	log.Printf("📴 Unsubscribed from room %s", roomID)
+}
+
+// listenRoom fans out events published by other instances to local clients
+func (h *Hub) listenRoom(roomID string, pubsub *redis.PubSub) {
+	for redisMsg := range pubsub.Channel() {
+		env, err := h.pubsubRepo.DecodeEnvelope(redisMsg.Payload)
+		if err != nil {
+			log.Printf("Failed to decode pub/sub payload for room %s: %v", roomID, err)
+			continue
+		}
+
+		// Our own publications were already delivered locally
+		if h.pubsubRepo.IsOwn(env) {
+			continue
+		}
+
+		h.broadcast <- &RoomMessage{
+			RoomID:  roomID,
+			Message: env.Message,
 		}
 	}
 }
 
+// announcePresence tells local clients and other instances that a user came
+// online or went offline in the room
+func (h *Hub) announcePresence(ctx context.Context, client *Client, isOnline bool) {
+	payload := &model.PresencePayload{
+		UserID:      client.UserID.String(),
+		Username:    client.Username,
+		DisplayName: client.DisplayName,
+		IsOnline:    isOnline,
+	}
+
+	if err := h.pubsubRepo.PublishPresence(ctx, client.RoomID, payload); err != nil {
+		log.Printf("Failed to publish presence for room %s: %v", client.RoomID, err)
+	}
+
+	data, _ := json.Marshal(model.WSMessage{
+		Type:    model.WSTypePresence,
+		Payload: payload,
+	})
+	h.broadcast <- &RoomMessage{
+		RoomID:  client.RoomID,
+		Message: data,
+	}
+}
+
 func (h *Hub) sendToClient(client *Client, msg model.WSMessage) {
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -216,33 +632,58 @@ func (h *Hub) sendToClient(client *Client, msg model.WSMessage) {
 
 func (h *Hub) HandleWebSocket(c *websocket.Conn) {
 	roomID := c.Params("roomId")
-	userID := c.Query("userId")
-	username := c.Query("username", "anonymous")
-	displayName := c.Query("displayName", username)
 
-	if roomID == "" || userID == "" {
+	// The upgrade route is guarded by middleware.RequireAuth, which stores the
+	// verified session before the connection is handed to us
+	session, ok := c.Locals(middleware.LocalsSession).(*model.Session)
+	if !ok {
 		c.WriteJSON(model.WSMessage{
 			Type:    model.WSTypeError,
-			Payload: "Missing roomId or userId",
+			Payload: "Authentication required",
+			Error:   &model.ErrorDetail{Code: model.ErrCodeUnauthorized},
 		})
 		c.Close()
 		return
 	}
 
-	if _, err := uuid.Parse(roomID); err != nil {
+	if roomID == "" {
+		c.WriteJSON(model.WSMessage{
+			Type:    model.WSTypeError,
+			Payload: "Missing roomId",
+			Error:   &model.ErrorDetail{Code: model.ErrCodeInvalidRequest, Field: "roomId"},
+		})
+		c.Close()
+		return
+	}
+
+	roomUUID, err := uuid.Parse(roomID)
+	if err != nil {
 		c.WriteJSON(model.WSMessage{
 			Type:    model.WSTypeError,
 			Payload: "Invalid roomId format",
+			Error:   &model.ErrorDetail{Code: model.ErrCodeInvalidRequest, Field: "roomId"},
 		})
 		c.Close()
 		return
 	}
 
-	parsedUserID, err := uuid.Parse(userID)
+	ban, mute, err := h.roomService.Restrictions(context.Background(), roomUUID, session.UserID)
 	if err != nil {
+		log.Printf("Error loading restrictions of %s in room %s: %v", session.UserID, roomID, err)
+		c.WriteJSON(model.WSMessage{
+			Type:    model.WSTypeError,
+			Payload: "Something went wrong",
+			Error:   &model.ErrorDetail{Code: model.ErrCodeInternal},
+		})
+		c.Close()
+		return
+	}
+	if ban != nil {
+		banned := &service.RestrictedError{Restriction: ban}
 		c.WriteJSON(model.WSMessage{
 			Type:    model.WSTypeError,
-			Payload: "Invalid userId format",
+			Payload: banned.Error(),
+			Error:   banned.Detail(),
 		})
 		c.Close()
 		return
@@ -250,14 +691,16 @@ func (h *Hub) HandleWebSocket(c *websocket.Conn) {
 
 	client := &Client{
 		ID:          uuid.New().String(),
-		UserID:      parsedUserID,
-		Username:    username,
-		DisplayName: displayName,
+		UserID:      session.UserID,
+		Username:    session.Username,
+		DisplayName: session.DisplayName,
 		RoomID:      roomID,
+		Since:       c.Query("since"),
 		Conn:        c,
 		Hub:         h,
 		Send:        make(chan []byte, 256),
 	}
+	client.mute.Store(mute)
 
 	h.register <- client
 
@@ -272,10 +715,16 @@ func (c *Client) readPump() {
 		c.Conn.Close()
 	}()
 
+	// The connection replies with close code 1009 and reads fail once a
+	// frame goes over the limit
+	c.Conn.SetReadLimit(c.Hub.maxFrameBytes)
+
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				log.Printf("Closing WebSocket of %s: frame over %d bytes", c.UserID, c.Hub.maxFrameBytes)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
@@ -283,6 +732,7 @@ func (c *Client) readPump() {
 
 		var incoming model.WSIncomingMessage
 		if err := json.Unmarshal(message, &incoming); err != nil {
+			c.sendError(model.ErrCodeInvalidRequest, "Frames must be JSON objects")
 			continue
 		}
 
@@ -331,78 +781,392 @@ func (c *Client) writePump() {
 func (c *Client) handleMessage(msg *model.WSIncomingMessage) {
 	ctx := context.Background()
 
+	if mute := c.activeMute(); mute != nil && silenced(msg.Type) {
+		muted := &service.RestrictedError{Restriction: mute}
+		if msg.Type == model.WSTypeMessage {
+			c.sendNack(msg.Nonce, nackFor(muted))
+		} else {
+			c.sendErrorDetail(muted.Error(), muted.Detail())
+		}
+		return
+	}
+
 	switch msg.Type {
 	case model.WSTypeMessage:
-		// Save message and broadcast
-		savedMsg, err := c.Hub.chatService.SendMessage(ctx, c.RoomID, c.UserID, msg.Content)
+		c.handleSend(ctx, msg)
+
+	case model.WSTypeEdit:
+		c.handleEdit(ctx, msg)
+
+	case model.WSTypeRead:
+		c.handleRead(ctx, msg)
+
+	case model.WSTypeDelete:
+		c.handleDelete(ctx, msg)
+
+	case model.WSTypeReactionAdd, model.WSTypeReactionDel:
+		c.handleReaction(ctx, msg)
+
+	case model.WSTypeTyping:
+		c.handleTyping(ctx, true)
+
+	case model.WSTypeStopTyping:
+		c.handleTyping(ctx, false)
+
+	default:
+		c.sendError(model.ErrCodeUnknownType, fmt.Sprintf("Unknown message type %q", msg.Type))
+	}
+}
+
+// silenced reports whether muted users are kept from sending t. They may
+// still read, mark as read and delete their own messages.
+func silenced(t model.WSMessageType) bool {
+	switch t {
+	case model.WSTypeMessage, model.WSTypeEdit, model.WSTypeReactionAdd, model.WSTypeReactionDel,
+		model.WSTypeTyping, model.WSTypeStopTyping:
+		return true
+	}
+	return false
+}
+
+// activeMute returns the client's cached mute unless it has run out
+func (c *Client) activeMute() *model.RoomRestriction {
+	mute := c.mute.Load()
+	if mute == nil {
+		return nil
+	}
+	if mute.ExpiresAt != nil && !time.Now().Before(*mute.ExpiresAt) {
+		c.mute.CompareAndSwap(mute, nil)
+		return nil
+	}
+	return mute
+}
+
+// applyModeration keeps the cached mute in step with moderation events
+// aimed at the client's user
+func (c *Client) applyModeration(event *model.ModerationPayload) {
+	switch event.Action {
+	case model.ModerationMute, model.ModerationTimeout:
+		kind := model.RestrictionMute
+		if event.Action == model.ModerationTimeout {
+			kind = model.RestrictionTimeout
+		}
+		c.mute.Store(&model.RoomRestriction{
+			RoomID:    event.RoomID,
+			UserID:    event.UserID,
+			Kind:      kind,
+			Reason:    event.Reason,
+			ExpiresAt: event.ExpiresAt,
+		})
+	case model.ModerationUnmute:
+		c.mute.Store(nil)
+	}
+}
+
+// handleTyping relays a typing indicator to the room. Typing and stop_typing
+// share one bucket so a client cannot flood the room with either.
+func (c *Client) handleTyping(ctx context.Context, isTyping bool) {
+	if err := c.Hub.rateLimiter.Allow(ctx, service.RateLimitTyping, c.RoomID+":"+c.UserID.String()); err != nil {
+		c.sendRateLimited(err, "")
+		return
+	}
+
+	payload := model.TypingPayload{
+		UserID:      c.UserID.String(),
+		Username:    c.Username,
+		DisplayName: c.DisplayName,
+		IsTyping:    isTyping,
+	}
+	c.Hub.pubsubRepo.PublishTyping(ctx, c.RoomID, &payload)
+
+	msgType := model.WSTypeTyping
+	if !isTyping {
+		msgType = model.WSTypeStopTyping
+	}
+	data, _ := json.Marshal(model.WSMessage{Type: msgType, Payload: payload})
+	c.Hub.broadcast <- &RoomMessage{
+		RoomID:  c.RoomID,
+		Message: data,
+	}
+}
+
+// handleSend stores a chat message, acks or nacks it to the sender and
+// broadcasts it. A resent nonce is acked again without a second broadcast.
+func (c *Client) handleSend(ctx context.Context, msg *model.WSIncomingMessage) {
+	var replyToID *uuid.UUID
+	if msg.ReplyToID != "" {
+		id, err := uuid.Parse(msg.ReplyToID)
 		if err != nil {
-			log.Printf("Error saving message: %v", err)
+			c.sendNack(msg.Nonce, model.NackPayload{Code: model.NackInvalid, Message: "Invalid reply_to_id"})
 			return
 		}
+		replyToID = &id
+	}
+
+	savedMsg, duplicate, err := c.Hub.chatService.SendMessage(ctx, c.RoomID, c.UserID, msg.Content, replyToID, msg.Nonce)
+	if errors.Is(err, service.ErrRateLimited) {
+		c.sendRateLimited(err, msg.Nonce)
+		return
+	}
+
+	// Automod timing the sender out is announced like a moderator's timeout
+	var blocked *service.AutomodError
+	if errors.As(err, &blocked) && blocked.Mute != nil {
+		c.Hub.AnnounceModeration(blocked.Mute)
+	}
+	if err != nil {
+		c.sendNack(msg.Nonce, nackFor(err))
+		return
+	}
+
+	c.Hub.sendToClient(c, model.WSMessage{
+		Type: model.WSTypeAck,
+		Payload: model.AckPayload{
+			Nonce:     msg.Nonce,
+			MessageID: savedMsg.ID,
+			Seq:       savedMsg.Seq,
+			CreatedAt: savedMsg.CreatedAt,
+			Duplicate: duplicate,
+		},
+	})
+	if duplicate {
+		return
+	}
+
+	c.Hub.AnnounceMessage(ctx, savedMsg)
+}
+
+func (c *Client) sendNack(nonce string, nack model.NackPayload) {
+	nack.Nonce = nonce
+	c.Hub.sendToClient(c, model.WSMessage{
+		Type:    model.WSTypeNack,
+		Payload: nack,
+	})
+}
+
+// sendRateLimited tells the client an action was dropped by a rate limit
+func (c *Client) sendRateLimited(err error, nonce string) {
+	var limited *service.RateLimitError
+	if !errors.As(err, &limited) {
+		return
+	}
+	c.Hub.sendToClient(c, model.WSMessage{
+		Type:    model.WSTypeRateLimited,
+		Payload: limited.Payload(nonce),
+	})
+}
 
-		// Broadcast to room via hub
-		wsMsg := model.WSMessage{
-			Type:    model.WSTypeMessage,
-			Payload: savedMsg,
+// nackFor maps a SendMessage error to the nack telling the sender why
+func nackFor(err error) model.NackPayload {
+	var blocked *service.AutomodError
+	if errors.As(err, &blocked) {
+		switch {
+		case blocked.Held != nil:
+			return model.NackPayload{Code: model.NackHeld, Message: blocked.Error()}
+		case blocked.Mute != nil:
+			return model.NackPayload{Code: model.NackMuted, Message: blocked.Error(), ExpiresAt: blocked.Mute.Event.ExpiresAt}
 		}
-		data, _ := json.Marshal(wsMsg)
-		c.Hub.broadcast <- &RoomMessage{
-			RoomID:  c.RoomID,
-			Message: data,
+		return model.NackPayload{Code: model.NackAutomod, Message: blocked.Error()}
+	}
+
+	var restricted *service.RestrictedError
+	if errors.As(err, &restricted) {
+		code := model.NackMuted
+		if restricted.Restriction.Kind == model.RestrictionBan {
+			code = model.NackForbidden
 		}
+		return model.NackPayload{Code: code, Message: restricted.Error(), ExpiresAt: restricted.Restriction.ExpiresAt}
+	}
 
-		// Notify global hub about new message (for homepage unread counts)
-		if c.Hub.globalHub != nil {
-			c.Hub.globalHub.BroadcastNewMessage(c.RoomID, c.UserID.String())
+	var invalid *service.ValidationError
+	if errors.As(err, &invalid) {
+		code := model.NackEmptyMessage
+		if invalid.Code == model.ErrCodeMessageTooLong {
+			code = model.NackTooLong
 		}
+		return model.NackPayload{Code: code, Message: invalid.Error(), Limit: invalid.Limit}
+	}
 
-	case model.WSTypeTyping:
-		// Broadcast typing indicator
-		c.Hub.pubsubRepo.PublishTyping(ctx, c.RoomID, &model.TypingPayload{
-			UserID:      c.UserID.String(),
-			Username:    c.Username,
-			DisplayName: c.DisplayName,
-			IsTyping:    true,
-		})
+	switch {
+	case errors.Is(err, service.ErrForbidden), errors.Is(err, repository.ErrNotMember):
+		return model.NackPayload{Code: model.NackForbidden, Message: "You don't have permission to do that in this room"}
+	case errors.Is(err, service.ErrInvalidNonce):
+		return model.NackPayload{Code: model.NackInvalid, Message: "Nonce must be at most 64 bytes"}
+	case errors.Is(err, service.ErrMessageNotFound):
+		return model.NackPayload{Code: model.NackReplyNotFound, Message: "Message to reply to not found"}
+	case errors.Is(err, service.ErrNonceReused):
+		return model.NackPayload{Code: model.NackNonceReused, Message: "Nonce already used for another message"}
+	}
+	log.Printf("Error saving message: %v", err)
+	return model.NackPayload{Code: model.NackInternal, Message: "Message could not be saved, please retry"}
+}
 
-		// Broadcast to local clients
-		wsMsg := model.WSMessage{
-			Type: model.WSTypeTyping,
-			Payload: model.TypingPayload{
-				UserID:      c.UserID.String(),
-				Username:    c.Username,
-				DisplayName: c.DisplayName,
-				IsTyping:    true,
-			},
-		}
-		data, _ := json.Marshal(wsMsg)
-		c.Hub.broadcast <- &RoomMessage{
-			RoomID:  c.RoomID,
-			Message: data,
+// authorize checks a room permission for the client, replying with an error
+// frame when it is denied
+func (c *Client) authorize(ctx context.Context, perm model.Permission) bool {
+	roomID, err := uuid.Parse(c.RoomID)
+	if err != nil {
+		return false
+	}
+
+	if _, err := c.Hub.roomService.Authorize(ctx, roomID, c.UserID, perm); err != nil {
+		if !errors.Is(err, service.ErrForbidden) {
+			log.Printf("Error checking %s permission: %v", perm, err)
 		}
+		c.sendError(model.ErrCodeForbidden, "You don't have permission to do that in this room")
+		return false
+	}
+	return true
+}
 
-	case model.WSTypeStopTyping:
-		// Broadcast stop typing
-		c.Hub.pubsubRepo.PublishTyping(ctx, c.RoomID, &model.TypingPayload{
-			UserID:      c.UserID.String(),
-			Username:    c.Username,
-			DisplayName: c.DisplayName,
-			IsTyping:    false,
-		})
+func (c *Client) sendError(code model.ErrorCode, message string) {
+	c.sendErrorDetail(message, &model.ErrorDetail{Code: code})
+}
 
-		wsMsg := model.WSMessage{
-			Type: model.WSTypeStopTyping,
-			Payload: model.TypingPayload{
-				UserID:      c.UserID.String(),
-				Username:    c.Username,
-				DisplayName: c.DisplayName,
-				IsTyping:    false,
-			},
-		}
-		data, _ := json.Marshal(wsMsg)
-		c.Hub.broadcast <- &RoomMessage{
-			RoomID:  c.RoomID,
-			Message: data,
-		}
+func (c *Client) sendErrorDetail(message string, detail *model.ErrorDetail) {
+	c.Hub.sendToClient(c, model.WSMessage{
+		Type:    model.WSTypeError,
+		Payload: message,
+		Error:   detail,
+	})
+}
+
+// handleEdit applies a message_edit frame
+func (c *Client) handleEdit(ctx context.Context, msg *model.WSIncomingMessage) {
+	roomID, messageID, ok := c.parseMessageRef(msg)
+	if !ok {
+		return
+	}
+
+	edited, err := c.Hub.chatService.EditMessage(ctx, roomID, messageID, c.UserID, msg.Content)
+	if err != nil {
+		c.sendActionError(err)
+		return
+	}
+
+	c.Hub.BroadcastToRoom(c.RoomID, model.WSMessage{
+		Type:    model.WSTypeEdit,
+		Payload: edited,
+	})
+	c.Hub.UnfurlLinks(&edited.Message)
+}
+
+// handleDelete applies a message_delete frame
+func (c *Client) handleDelete(ctx context.Context, msg *model.WSIncomingMessage) {
+	roomID, messageID, ok := c.parseMessageRef(msg)
+	if !ok {
+		return
+	}
+
+	deleted, err := c.Hub.chatService.DeleteMessage(ctx, roomID, messageID, c.UserID)
+	if err != nil {
+		c.sendActionError(err)
+		return
+	}
+
+	c.Hub.BroadcastToRoom(c.RoomID, model.WSMessage{
+		Type: model.WSTypeDelete,
+		Payload: model.MessageDeletedPayload{
+			MessageID: deleted.ID.String(),
+			RoomID:    c.RoomID,
+			DeletedBy: c.UserID.String(),
+			DeletedAt: *deleted.DeletedAt,
+		},
+	})
+}
+
+// handleRead advances the client's read marker and tells the room
+func (c *Client) handleRead(ctx context.Context, msg *model.WSIncomingMessage) {
+	roomID, messageID, ok := c.parseMessageRef(msg)
+	if !ok {
+		return
+	}
+
+	receipt, err := c.Hub.chatService.MarkRead(ctx, roomID, c.UserID, &messageID)
+	if err != nil {
+		c.sendActionError(err)
+		return
+	}
+	c.Hub.SyncUnread(roomID, c.UserID)
+	if receipt == nil {
+		return
+	}
+
+	c.Hub.BroadcastToRoom(c.RoomID, model.WSMessage{
+		Type:    model.WSTypeReadReceipt,
+		Payload: receipt,
+	})
+}
+
+// handleReaction applies a reaction_add or reaction_remove frame
+func (c *Client) handleReaction(ctx context.Context, msg *model.WSIncomingMessage) {
+	roomID, messageID, ok := c.parseMessageRef(msg)
+	if !ok {
+		return
+	}
+
+	var payload *model.ReactionPayload
+	var err error
+	if msg.Type == model.WSTypeReactionAdd {
+		payload, err = c.Hub.chatService.AddReaction(ctx, roomID, messageID, c.UserID, msg.Emoji)
+	} else {
+		payload, err = c.Hub.chatService.RemoveReaction(ctx, roomID, messageID, c.UserID, msg.Emoji)
+	}
+	if err != nil {
+		c.sendActionError(err)
+		return
+	}
+
+	c.Hub.BroadcastToRoom(c.RoomID, model.WSMessage{
+		Type:    msg.Type,
+		Payload: payload,
+	})
+}
+
+func (c *Client) parseMessageRef(msg *model.WSIncomingMessage) (uuid.UUID, uuid.UUID, bool) {
+	roomID, err := uuid.Parse(c.RoomID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	messageID, err := uuid.Parse(msg.MessageID)
+	if err != nil {
+		c.sendErrorDetail("Invalid message_id", &model.ErrorDetail{Code: model.ErrCodeInvalidRequest, Field: "message_id"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return roomID, messageID, true
+}
+
+// sendActionError reports a failed ChatService call as an error frame
+func (c *Client) sendActionError(err error) {
+	var invalid *service.ValidationError
+	if errors.As(err, &invalid) {
+		c.sendErrorDetail(invalid.Error(), invalid.Detail())
+		return
+	}
+
+	var restricted *service.RestrictedError
+	if errors.As(err, &restricted) {
+		c.sendErrorDetail(restricted.Error(), restricted.Detail())
+		return
+	}
+
+	var blocked *service.AutomodError
+	if errors.As(err, &blocked) {
+		c.sendError(model.ErrCodeAutomod, blocked.Error())
+		return
+	}
+
+	switch {
+	case errors.Is(err, service.ErrMessageNotFound):
+		c.sendError(model.ErrCodeNotFound, "Message not found")
+	case errors.Is(err, service.ErrInvalidEmoji):
+		c.sendErrorDetail("Invalid emoji", &model.ErrorDetail{Code: model.ErrCodeInvalidRequest, Field: "emoji"})
+	case errors.Is(err, service.ErrForbidden):
+		c.sendError(model.ErrCodeForbidden, "You don't have permission to do that in this room")
+	default:
+		log.Printf("Error handling message action: %v", err)
+		c.sendError(model.ErrCodeInternal, "Something went wrong")
 	}
 }
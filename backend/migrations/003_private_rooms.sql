@@ -0,0 +1,26 @@
+-- Migration: 003_private_rooms.sql
+-- Invitations and join requests for private rooms
+
+-- Invitations sent by a room owner; consumed when the invitee joins
+CREATE TABLE IF NOT EXISTS room_invitations (
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    invited_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (room_id, user_id)
+);
+
+-- Pending requests to join a private room, awaiting owner approval
+CREATE TABLE IF NOT EXISTS room_join_requests (
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_invitations_user ON room_invitations(user_id);
+
+-- Room creators are members of their own rooms
+INSERT INTO room_members (room_id, user_id)
+SELECT id, created_by FROM rooms WHERE created_by IS NOT NULL
+ON CONFLICT DO NOTHING;
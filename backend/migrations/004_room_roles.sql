@@ -0,0 +1,10 @@
+-- Migration: 004_room_roles.sql
+-- Per-room member roles
+
+ALTER TABLE room_members
+    ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'member';
+
+-- Room creators own their rooms
+UPDATE room_members rm SET role = 'owner'
+FROM rooms r
+WHERE rm.room_id = r.id AND rm.user_id = r.created_by;
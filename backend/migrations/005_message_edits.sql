@@ -0,0 +1,6 @@
+-- Migration: 005_message_edits.sql
+-- Track edited and deleted (retracted) messages
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
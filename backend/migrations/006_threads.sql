@@ -0,0 +1,9 @@
+-- Migration: 006_threads.sql
+-- Threaded replies: reply_to_id is the quoted message, thread_root_id the
+-- top-level message the thread hangs off
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS reply_to_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS thread_root_id UUID REFERENCES messages(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_messages_thread_root ON messages(thread_root_id, created_at);
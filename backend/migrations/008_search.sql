@@ -0,0 +1,13 @@
+-- Migration: 008_search.sql
+-- Full-text search over messages. Thai is written without spaces between
+-- words, so tsvector matching alone misses most Thai queries; a trigram
+-- index backs substring matching as the fallback.
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', content)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_messages_content_trgm ON messages USING GIN (content gin_trgm_ops);
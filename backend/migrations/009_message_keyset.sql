@@ -0,0 +1,6 @@
+-- Migration: 009_message_keyset.sql
+-- Keyset pagination over the room timeline orders by (created_at, id)
+
+CREATE INDEX IF NOT EXISTS idx_messages_room_timeline
+    ON messages(room_id, created_at DESC, id DESC)
+    WHERE thread_root_id IS NULL;
@@ -0,0 +1,21 @@
+-- Migration: 010_message_seq.sql
+-- Per-room sequence numbers for timeline messages, so reconnecting clients
+-- can detect and replay gaps. rooms.last_seq is the counter; thread replies
+-- are not part of the timeline and get no seq.
+
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS last_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+-- Number existing timeline messages in order
+UPDATE messages m SET seq = n.seq
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY room_id ORDER BY created_at, id) AS seq
+    FROM messages
+    WHERE thread_root_id IS NULL
+) n
+WHERE m.id = n.id AND NOT EXISTS (SELECT 1 FROM messages s WHERE s.seq IS NOT NULL);
+
+UPDATE rooms r
+SET last_seq = COALESCE((SELECT MAX(seq) FROM messages WHERE room_id = r.id), 0);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_room_seq ON messages(room_id, seq) WHERE seq IS NOT NULL;
@@ -0,0 +1,9 @@
+-- Migration: 011_message_nonce.sql
+-- Client-generated nonces make sends idempotent: a retried send with the
+-- same nonce returns the stored message instead of creating a duplicate
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_nonce TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_user_nonce
+    ON messages(user_id, client_nonce)
+    WHERE client_nonce IS NOT NULL;
@@ -0,0 +1,10 @@
+-- Migration: 012_read_receipts.sql
+-- Read markers point at a specific message; users can opt out of sharing
+-- read receipts (their marker still drives their own unread counts)
+
+ALTER TABLE room_members
+    ADD COLUMN IF NOT EXISTS last_read_message_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS read_receipts BOOLEAN NOT NULL DEFAULT true;
+
+CREATE INDEX IF NOT EXISTS idx_room_members_last_read ON room_members(room_id, last_read_at);
@@ -0,0 +1,7 @@
+-- Migration: 013_direct_messages.sql
+-- Rooms now have a kind: regular 'room's are listed, 'dm's are private
+-- 1:1 conversations whose ID is derived from the two participants
+
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'room';
+
+CREATE INDEX IF NOT EXISTS idx_rooms_kind ON rooms(kind);
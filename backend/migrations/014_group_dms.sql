@@ -0,0 +1,6 @@
+-- Migration: 014_group_dms.sql
+-- Group DMs are rooms of kind 'group'; members record who added them so
+-- membership changes can be traced
+
+ALTER TABLE room_members
+    ADD COLUMN IF NOT EXISTS added_by UUID REFERENCES users(id) ON DELETE SET NULL;
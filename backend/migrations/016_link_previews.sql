@@ -0,0 +1,5 @@
+-- Migration: 016_link_previews.sql
+-- Link previews are unfurled after a message is sent and stored with it.
+-- Editing a message clears them until the new content is unfurled.
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS link_previews JSONB;
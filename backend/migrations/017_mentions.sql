@@ -0,0 +1,16 @@
+-- Migration: 017_mentions.sql
+-- One row per user mentioned in a message. kind records how they were
+-- mentioned: by name, through @here or through @room.
+
+CREATE TABLE IF NOT EXISTS mentions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    mentioned_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    kind VARCHAR(10) NOT NULL DEFAULT 'user',
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE(message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_mentions_user ON mentions(user_id, created_at DESC);
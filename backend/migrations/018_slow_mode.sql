@@ -0,0 +1,6 @@
+-- Migration: 018_slow_mode.sql
+-- Slow mode: the minimum number of seconds between messages from one member
+-- of a room. 0 turns it off; moderators and above are exempt.
+
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS slow_mode_seconds INTEGER NOT NULL DEFAULT 0
+    CHECK (slow_mode_seconds >= 0);
@@ -0,0 +1,20 @@
+-- Migration: 019_moderation.sql
+-- Bans, mutes and timeouts of users in rooms. Rows are kept after they end
+-- so moderators can see who was restricted before; a restriction is active
+-- until expires_at (never when NULL) unless lifted_at is set.
+
+CREATE TABLE IF NOT EXISTS room_restrictions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(10) NOT NULL,
+    reason TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    expires_at TIMESTAMPTZ,
+    lifted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    lifted_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_restrictions_active
+    ON room_restrictions(room_id, user_id) WHERE lifted_at IS NULL;
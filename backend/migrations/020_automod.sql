@@ -0,0 +1,55 @@
+-- Migration: 020_automod.sql
+-- Automod rules, global when room_id is NULL. Only the settings of a rule's
+-- kind are filled in. Instances learn of changes over Redis pub/sub and
+-- reload from here.
+
+CREATE TABLE IF NOT EXISTS automod_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    action VARCHAR(10) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    mute_seconds INTEGER NOT NULL DEFAULT 0,
+    words TEXT[] NOT NULL DEFAULT '{}',
+    pattern TEXT NOT NULL DEFAULT '',
+    link_mode VARCHAR(10) NOT NULL DEFAULT '',
+    domains TEXT[] NOT NULL DEFAULT '{}',
+    min_letters INTEGER NOT NULL DEFAULT 0,
+    max_caps_ratio DOUBLE PRECISION NOT NULL DEFAULT 0,
+    max_mentions INTEGER NOT NULL DEFAULT 0,
+    max_run_length INTEGER NOT NULL DEFAULT 0,
+    max_lines INTEGER NOT NULL DEFAULT 0,
+    max_repeats INTEGER NOT NULL DEFAULT 0,
+    window_seconds INTEGER NOT NULL DEFAULT 0,
+    created_by UUID NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_automod_rules_room ON automod_rules(room_id);
+
+-- Messages automod held back for review. rule_name is copied here so the
+-- queue still reads sensibly after a rule is deleted. The sender's nonce is
+-- kept, so retrying a held send doesn't queue it again, and the message
+-- posted on approval carries the nonce the sender's client is waiting on.
+CREATE TABLE IF NOT EXISTS held_messages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    reply_to_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+    rule_id UUID NOT NULL,
+    rule_name VARCHAR(100) NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending',
+    client_nonce VARCHAR(64),
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_held_messages_pending
+    ON held_messages(room_id, created_at) WHERE status = 'pending';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_held_messages_user_nonce
+    ON held_messages(user_id, client_nonce) WHERE client_nonce IS NOT NULL;
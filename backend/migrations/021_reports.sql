@@ -0,0 +1,35 @@
+-- Migration: 021_reports.sql
+-- Users' reports of messages and the audit trail of what was done about
+-- them. content keeps the message as it was reported, since acting on a
+-- report usually deletes it. A user reports a given message once.
+
+CREATE TABLE IF NOT EXISTS reports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    reporter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    author_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    content TEXT NOT NULL,
+    reason VARCHAR(20) NOT NULL,
+    details TEXT,
+    status VARCHAR(10) NOT NULL DEFAULT 'open',
+    action VARCHAR(20),
+    resolved_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    resolved_at TIMESTAMPTZ,
+    note TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_message_reporter ON reports(message_id, reporter_id);
+CREATE INDEX IF NOT EXISTS idx_reports_open ON reports(room_id, created_at) WHERE status = 'open';
+
+CREATE TABLE IF NOT EXISTS report_audit (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    report_id UUID NOT NULL REFERENCES reports(id) ON DELETE CASCADE,
+    actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(20) NOT NULL,
+    note TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_report_audit_report ON report_audit(report_id, created_at);
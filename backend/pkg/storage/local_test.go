@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	root := t.TempDir()
+	l, err := NewLocal(filepath.Join(root, "uploads"))
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{"a.png", true},
+		{"rooms/1/a.png", true},
+		{"rooms/../a.png", true},
+		{"/a.png", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../a.png", false},
+		{"rooms/../../a.png", false},
+		{"../uploads-other/a.png", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			p, err := l.path(tt.key)
+			if (err == nil) != tt.ok {
+				t.Fatalf("path(%q) = %q, %v; want ok=%v", tt.key, p, err, tt.ok)
+			}
+			if tt.ok && !strings.HasPrefix(p, l.dir+string(filepath.Separator)) {
+				t.Errorf("path(%q) = %q escapes %q", tt.key, p, l.dir)
+			}
+		})
+	}
+}
+
+func TestLocalRoundTrip(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	ctx := context.Background()
+	key := "rooms/1/a.txt"
+
+	if _, err := l.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get before Put = %v, want ErrNotFound", err)
+	}
+
+	if err := l.Put(ctx, key, strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	rc, err := l.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(got) != "hello" {
+		t.Fatalf("Get = %q, %v; want %q", got, err, "hello")
+	}
+
+	// The temporary upload file is renamed into place, never left behind
+	entries, err := os.ReadDir(filepath.Join(l.dir, "rooms", "1"))
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want 1", len(entries))
+	}
+
+	if err := l.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := l.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing object = %v, want nil", err)
+	}
+	if _, err := l.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,326 @@
+// Package unfurl fetches OpenGraph / Twitter card metadata for link
+// previews. Requests only ever reach public addresses: the dialer checks
+// every resolved IP, including those of redirect targets, so a hostname
+// pointing at an internal service is refused.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+var (
+	ErrBlockedAddress = errors.New("address is not publicly routable")
+	ErrNotHTML        = errors.New("response is not an HTML page")
+)
+
+const (
+	maxRedirects      = 3
+	maxTitleRunes     = 300
+	maxDescRunes      = 500
+	maxURLBytes       = 2048
+	userAgent         = "IsanChatBot/1.0 (+link preview)"
+	acceptHTMLHeaders = "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1"
+)
+
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// Empty reports whether the page had nothing worth showing
+func (p *Preview) Empty() bool {
+	return p.Title == "" && p.Description == "" && p.ImageURL == ""
+}
+
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+// NewFetcher returns a Fetcher that gives up on a page after timeout and
+// reads at most maxBytes of it
+func NewFetcher(timeout time.Duration, maxBytes int64) *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublic(ip) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		// No proxy: it would be the proxy's address that gets checked
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return checkURL(req.URL)
+		},
+	}
+
+	return &Fetcher{client: client, maxBytes: maxBytes}
+}
+
+// Fetch downloads rawURL and extracts its preview metadata
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", acceptHTMLHeaders)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	// Thai sites still serve TIS-620 / windows-874 now and then
+	var body io.Reader = io.LimitReader(resp.Body, f.maxBytes)
+	if utf8Body, err := charset.NewReader(body, resp.Header.Get("Content-Type")); err == nil {
+		body = utf8Body
+	}
+
+	preview := parse(body, resp.Request.URL)
+	preview.URL = rawURL
+	return preview, nil
+}
+
+// checkURL allows only http(s) on the default ports, and refuses literal
+// IPs that aren't public before any connection is made
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.User != nil {
+		return errors.New("credentials in URL")
+	}
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		return fmt.Errorf("unsupported port %s", port)
+	}
+
+	host := u.Hostname()
+	if host == "" || strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublic(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
+
+// nonPublic lists ranges net.IP's predicates don't cover
+var nonPublic = mustParseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved
+	"64:ff9b::/96",  // NAT64, would reach IPv4 hosts
+	"2002::/16",     // 6to4, likewise
+)
+
+func isPublic(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range nonPublic {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// parse reads <meta> tags from the document head. OpenGraph wins over
+// Twitter cards, which win over <title> and the plain description.
+func parse(r io.Reader, base *url.URL) *Preview {
+	var og, twitter, plain Preview
+
+	z := html.NewTokenizer(r)
+	inTitle := false
+loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break loop
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "body":
+				break loop
+			case "title":
+				inTitle = true
+			case "meta":
+				if hasAttr {
+					applyMeta(z, &og, &twitter, &plain)
+				}
+			}
+
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "title" {
+				inTitle = false
+			}
+
+		case html.TextToken:
+			if inTitle && plain.Title == "" {
+				plain.Title = strings.TrimSpace(string(z.Text()))
+			}
+		}
+	}
+
+	p := &Preview{
+		Title:       truncate(first(og.Title, twitter.Title, plain.Title), maxTitleRunes),
+		Description: truncate(first(og.Description, twitter.Description, plain.Description), maxDescRunes),
+		SiteName:    truncate(og.SiteName, maxTitleRunes),
+	}
+	if img := first(og.ImageURL, twitter.ImageURL); img != "" {
+		p.ImageURL = resolveImage(base, img)
+	}
+	return p
+}
+
+func applyMeta(z *html.Tokenizer, og, twitter, plain *Preview) {
+	var key, content string
+	for {
+		k, v, more := z.TagAttr()
+		switch strings.ToLower(string(k)) {
+		case "property", "name":
+			if key == "" {
+				key = strings.ToLower(strings.TrimSpace(string(v)))
+			}
+		case "content":
+			content = strings.TrimSpace(string(v))
+		}
+		if !more {
+			break
+		}
+	}
+	if content == "" {
+		return
+	}
+
+	switch key {
+	case "og:title":
+		og.Title = content
+	case "og:description":
+		og.Description = content
+	case "og:image", "og:image:url", "og:image:secure_url":
+		if og.ImageURL == "" {
+			og.ImageURL = content
+		}
+	case "og:site_name":
+		og.SiteName = content
+	case "twitter:title":
+		twitter.Title = content
+	case "twitter:description":
+		twitter.Description = content
+	case "twitter:image", "twitter:image:src":
+		if twitter.ImageURL == "" {
+			twitter.ImageURL = content
+		}
+	case "description":
+		plain.Description = content
+	}
+}
+
+// resolveImage makes a relative image URL absolute. Only http(s) images
+// are kept; clients load them directly, so nothing is fetched here.
+func resolveImage(base *url.URL, raw string) string {
+	ref, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	u := base.ResolveReference(ref)
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.String()) > maxURLBytes {
+		return ""
+	}
+	return u.String()
+}
+
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func truncate(s string, maxRunes int) string {
+	s = strings.ToValidUTF8(s, "")
+	if utf8.RuneCountInString(s) <= maxRunes {
+		return s
+	}
+	return string([]rune(s)[:maxRunes-1]) + "…"
+}
@@ -6,12 +6,7 @@ import { useWebSocket } from "@/hooks/useWebSocket";
 import MessageList from "@/components/chat/MessageList";
 import MessageInput from "@/components/chat/MessageInput";
 import OnlineUsers from "@/components/chat/OnlineUsers";
-
-interface User {
-  id: string;
-  username: string;
-  display_name: string;
-}
+import { authHeaders, getSession, roomApi, Session } from "@/lib/api";
 
 interface Room {
   id: string;
@@ -26,15 +21,17 @@ export default function ChatRoom() {
   const router = useRouter();
   const roomId = params.roomId as string;
 
-  const [user, setUser] = useState<User | null>(null);
+  const [session, setSession] = useState<Session | null>(null);
   const [room, setRoom] = useState<Room | null>(null);
   const [showOnlineUsers, setShowOnlineUsers] = useState(true);
 
-  // Load user from localStorage
+  const user = session?.user ?? null;
+
+  // Load the session from localStorage
   useEffect(() => {
-    const savedUser = localStorage.getItem("chat_user");
-    if (savedUser) {
-      setUser(JSON.parse(savedUser));
+    const saved = getSession();
+    if (saved) {
+      setSession(saved);
     } else {
       router.push("/");
     }
@@ -56,7 +53,9 @@ export default function ChatRoom() {
 
   const fetchRoom = async () => {
     try {
-      const res = await fetch(`${API_URL}/api/rooms/${roomId}`);
+      const res = await fetch(`${API_URL}/api/rooms/${roomId}`, {
+        headers: authHeaders(),
+      });
       if (res.ok) {
         const data = await res.json();
         setRoom(data);
@@ -69,9 +68,7 @@ export default function ChatRoom() {
   const markAsRead = async () => {
     if (!user) return;
     try {
-      await fetch(`${API_URL}/api/rooms/${roomId}/read?userId=${user.id}`, {
-        method: "POST",
-      });
+      await roomApi.markRead(roomId);
     } catch (error) {
       console.error("Failed to mark as read:", error);
     }
@@ -87,12 +84,7 @@ export default function ChatRoom() {
     sendMessage,
     sendTyping,
     sendStopTyping,
-  } = useWebSocket(
-    roomId,
-    user?.id || "",
-    user?.username || "",
-    user?.display_name || ""
-  );
+  } = useWebSocket(roomId, session?.token || "");
 
   // Mark as read when new messages arrive
   useEffect(() => {
@@ -3,6 +3,14 @@
 import { useState, useEffect, useCallback } from "react";
 import { useRouter } from "next/navigation";
 import { useGlobalWebSocket } from "@/hooks/useGlobalWebSocket";
+import {
+  authApi,
+  authHeaders,
+  clearSession,
+  getSession,
+  saveSession,
+  Session,
+} from "@/lib/api";
 
 interface Room {
   id: string;
@@ -14,46 +22,40 @@ interface Room {
   unread_count: number;
 }
 
-interface User {
-  id: string;
-  username: string;
-  display_name: string;
-}
-
 const API_URL = process.env.NEXT_PUBLIC_API_URL || "http://127.0.0.1:3001";
 
 export default function Home() {
   const router = useRouter();
   const [rooms, setRooms] = useState<Room[]>([]);
-  const [user, setUser] = useState<User | null>(null);
+  const [session, setSession] = useState<Session | null>(null);
   const [username, setUsername] = useState("");
   const [displayName, setDisplayName] = useState("");
+  const [password, setPassword] = useState("");
+  const [isRegister, setIsRegister] = useState(false);
+  const [loginError, setLoginError] = useState<string | null>(null);
   const [isLoading, setIsLoading] = useState(false);
   const [showLogin, setShowLogin] = useState(true);
   const [lastUpdated, setLastUpdated] = useState<Date | null>(null);
 
+  const user = session?.user ?? null;
+
   // Real-time updates via WebSocket
-  const { isConnected: wsConnected, onRoomUpdate } = useGlobalWebSocket(user?.id || "");
+  const { isConnected: wsConnected, onRoomUpdate } = useGlobalWebSocket(session?.token || "");
 
-  // Check for existing user in localStorage
+  // Check for an existing session in localStorage
   useEffect(() => {
-    const savedUser = localStorage.getItem("chat_user");
-    if (savedUser) {
-      setUser(JSON.parse(savedUser));
+    const saved = getSession();
+    if (saved) {
+      setSession(saved);
       setShowLogin(false);
     }
   }, []);
 
-  // Fetch rooms (initial load only)
+  // Fetch rooms (initial load only). Signed-in users also get their unread
+  // counts and the private rooms they belong to.
   const fetchRooms = useCallback(async () => {
     try {
-      const savedUser = localStorage.getItem("chat_user");
-      let url = `${API_URL}/api/rooms`;
-      if (savedUser) {
-        const userData = JSON.parse(savedUser);
-        url += `?userId=${userData.id}`;
-      }
-      const res = await fetch(url);
+      const res = await fetch(`${API_URL}/api/rooms`, { headers: authHeaders() });
       if (res.ok) {
         const data = await res.json();
         setRooms(data);
@@ -99,38 +101,41 @@ export default function Home() {
 
   const handleLogin = async (e: React.FormEvent) => {
     e.preventDefault();
-    if (!username.trim() || !displayName.trim()) return;
+    if (!username.trim()) return;
+    if (isRegister && (!displayName.trim() || !password)) return;
 
     setIsLoading(true);
+    setLoginError(null);
     try {
-      const res = await fetch(`${API_URL}/api/users`, {
-        method: "POST",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({
-          username: username.trim().toLowerCase(),
-          display_name: displayName.trim(),
-        }),
-      });
+      const name = username.trim().toLowerCase();
+      const next = isRegister
+        ? await authApi.register(name, displayName.trim(), password)
+        : await authApi.login(name, password, displayName.trim() || undefined);
 
-      if (res.ok) {
-        const userData = await res.json();
-        setUser(userData);
-        localStorage.setItem("chat_user", JSON.stringify(userData));
-        setShowLogin(false);
-      }
+      saveSession(next);
+      setSession(next);
+      setShowLogin(false);
+      setPassword("");
     } catch (error) {
       console.error("Login failed:", error);
+      setLoginError(error instanceof Error ? error.message : "เข้าสู่ระบบไม่สำเร็จ");
     } finally {
       setIsLoading(false);
     }
   };
 
-  const handleLogout = () => {
-    localStorage.removeItem("chat_user");
-    setUser(null);
+  const handleLogout = async () => {
+    try {
+      await authApi.logout();
+    } catch {
+      // The session is dropped locally either way
+    }
+    clearSession();
+    setSession(null);
     setShowLogin(true);
     setUsername("");
     setDisplayName("");
+    setPassword("");
   };
 
   const joinRoom = (roomId: string) => {
@@ -176,25 +181,58 @@ export default function Home() {
                       required
                     />
                   </div>
+                  {isRegister && (
+                    <div>
+                      <label className="block text-sm font-medium text-[var(--color-earth-700)] mb-1">
+                        ชื่อที่แสดง (Display Name)
+                      </label>
+                      <input
+                        type="text"
+                        value={displayName}
+                        onChange={(e) => setDisplayName(e.target.value)}
+                        placeholder="สมชาย"
+                        className="input-isan"
+                        required
+                      />
+                    </div>
+                  )}
                   <div>
                     <label className="block text-sm font-medium text-[var(--color-earth-700)] mb-1">
-                      ชื่อที่แสดง (Display Name)
+                      รหัสผ่าน (Password)
                     </label>
                     <input
-                      type="text"
-                      value={displayName}
-                      onChange={(e) => setDisplayName(e.target.value)}
-                      placeholder="สมชาย"
+                      type="password"
+                      value={password}
+                      onChange={(e) => setPassword(e.target.value)}
                       className="input-isan"
-                      required
+                      autoComplete={isRegister ? "new-password" : "current-password"}
+                      minLength={isRegister ? 8 : undefined}
+                      required={isRegister}
                     />
                   </div>
+                  {loginError && (
+                    <p className="text-sm text-red-600">{loginError}</p>
+                  )}
                   <button
                     type="submit"
                     disabled={isLoading}
                     className="btn-gold w-full"
                   >
-                    {isLoading ? "กำลังเข้าสู่ระบบ..." : "เข้าสู่ระบบ"}
+                    {isLoading
+                      ? "กำลังเข้าสู่ระบบ..."
+                      : isRegister
+                        ? "สมัครสมาชิก"
+                        : "เข้าสู่ระบบ"}
+                  </button>
+                  <button
+                    type="button"
+                    onClick={() => {
+                      setIsRegister(!isRegister);
+                      setLoginError(null);
+                    }}
+                    className="text-sm text-[var(--color-earth-600)] hover:underline w-full"
+                  >
+                    {isRegister ? "มีบัญชีแล้ว? เข้าสู่ระบบ" : "ยังไม่มีบัญชี? สมัครสมาชิก"}
                   </button>
                 </form>
               ) : user ? (
@@ -1,6 +1,7 @@
 "use client";
 
 import { useState, useEffect, useRef, useCallback } from "react";
+import { withToken } from "@/lib/api";
 
 const WS_URL = process.env.NEXT_PUBLIC_WS_URL || "ws://localhost:3001";
 
@@ -39,7 +40,8 @@ interface UseGlobalWebSocketReturn {
     onRoomUpdate: (callback: (roomId: string, stats: RoomStatsPayload) => void) => void;
 }
 
-export function useGlobalWebSocket(userId: string): UseGlobalWebSocketReturn {
+// useGlobalWebSocket connects as the user the session token belongs to
+export function useGlobalWebSocket(token: string): UseGlobalWebSocketReturn {
     const [isConnected, setIsConnected] = useState(false);
     const [roomUpdates, setRoomUpdates] = useState<Map<string, { online_count: number; has_new_msg?: boolean }>>(new Map());
     const [newRooms, setNewRooms] = useState<Room[]>([]);
@@ -52,7 +54,7 @@ export function useGlobalWebSocket(userId: string): UseGlobalWebSocketReturn {
     const maxReconnectAttempts = 5;
 
     const connect = useCallback(() => {
-        if (!userId || userId === "") {
+        if (!token) {
             return;
         }
 
@@ -68,11 +70,12 @@ export function useGlobalWebSocket(userId: string): UseGlobalWebSocketReturn {
             reconnectTimeoutRef.current = null;
         }
 
-        const wsUrl = `${WS_URL}/ws/global?userId=${userId}`;
+        // Keep the token out of the logs
+        const wsUrl = `${WS_URL}/ws/global`;
         console.log("🌐 Connecting to Global WebSocket:", wsUrl);
 
         try {
-            const ws = new WebSocket(wsUrl);
+            const ws = new WebSocket(withToken(wsUrl, token));
             wsRef.current = ws;
 
             ws.onopen = () => {
@@ -111,7 +114,7 @@ export function useGlobalWebSocket(userId: string): UseGlobalWebSocketReturn {
         } catch (e) {
             console.error("Failed to create Global WebSocket:", e);
         }
-    }, [userId]);
+    }, [token]);
 
     const handleMessage = useCallback((data: GlobalWSMessage) => {
         switch (data.type) {
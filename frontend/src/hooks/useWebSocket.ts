@@ -2,6 +2,7 @@
 
 import { useState, useEffect, useRef, useCallback } from "react";
 import { Message, OnlineUser, TypingUser, WSMessage, WSMessageType } from "@/types";
+import { authHeaders, withToken } from "@/lib/api";
 
 const WS_URL = process.env.NEXT_PUBLIC_WS_URL || "ws://127.0.0.1:3001";
 const API_URL = process.env.NEXT_PUBLIC_API_URL || "http://127.0.0.1:3001";
@@ -17,11 +18,10 @@ interface UseWebSocketReturn {
   sendStopTyping: () => void;
 }
 
+// useWebSocket joins a room as the user the session token belongs to
 export function useWebSocket(
   roomId: string,
-  userId: string,
-  username: string,
-  displayName: string
+  token: string
 ): UseWebSocketReturn {
   const [messages, setMessages] = useState<Message[]>([]);
   const [onlineUsers, setOnlineUsers] = useState<OnlineUser[]>([]);
@@ -64,7 +64,9 @@ export function useWebSocket(
     historyFetchAttemptedRef.current = true;
 
     try {
-      const res = await fetch(`${API_URL}/api/rooms/${roomId}/messages?limit=50&offset=0`);
+      const res = await fetch(`${API_URL}/api/rooms/${roomId}/messages?limit=50&offset=0`, {
+        headers: authHeaders(),
+      });
       if (!res.ok) {
         return;
       }
@@ -82,8 +84,8 @@ export function useWebSocket(
   // Connect to WebSocket
   const connect = useCallback(() => {
     // Guard against empty parameters
-    if (!roomId || !userId || userId === "") {
-      console.log("⏸️ WebSocket: Waiting for roomId and userId...");
+    if (!roomId || !token) {
+      console.log("⏸️ WebSocket: Waiting for roomId and session...");
       return;
     }
 
@@ -101,12 +103,13 @@ export function useWebSocket(
       reconnectTimeoutRef.current = null;
     }
 
-    const wsUrl = `${WS_URL}/ws/${roomId}?userId=${userId}&username=${encodeURIComponent(username)}&displayName=${encodeURIComponent(displayName)}`;
+    // Keep the token out of the logs
+    const wsUrl = `${WS_URL}/ws/${roomId}`;
 
     console.log("🔗 Connecting to WebSocket:", wsUrl);
 
     try {
-      const ws = new WebSocket(wsUrl);
+      const ws = new WebSocket(withToken(wsUrl, token));
       wsRef.current = ws;
 
       ws.onopen = () => {
@@ -179,7 +182,7 @@ export function useWebSocket(
       setError("ไม่สามารถสร้างการเชื่อมต่อได้");
       fetchHistoryHttp();
     }
-  }, [roomId, userId, username, displayName]);
+  }, [roomId, token]);
 
   // Handle incoming messages
   const handleMessage = useCallback((data: WSMessage) => {
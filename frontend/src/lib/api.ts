@@ -1,5 +1,60 @@
 const API_URL = process.env.NEXT_PUBLIC_API_URL || "http://127.0.0.1:3001";
 
+const SESSION_KEY = "chat_session";
+
+export interface SessionUser {
+  id: string;
+  username: string;
+  display_name: string;
+}
+
+// Session is what /api/auth/login and /api/auth/register return
+export interface Session {
+  token: string;
+  expires_at: string;
+  user: SessionUser;
+}
+
+// getSession returns the stored session, dropping it once it has expired
+export function getSession(): Session | null {
+  if (typeof window === "undefined") return null;
+
+  const saved = localStorage.getItem(SESSION_KEY);
+  if (!saved) return null;
+
+  try {
+    const session = JSON.parse(saved) as Session;
+    if (new Date(session.expires_at).getTime() > Date.now()) {
+      return session;
+    }
+  } catch {
+    // Fall through and clear the unreadable value
+  }
+  clearSession();
+  return null;
+}
+
+export function saveSession(session: Session) {
+  localStorage.setItem(SESSION_KEY, JSON.stringify(session));
+}
+
+export function clearSession() {
+  localStorage.removeItem(SESSION_KEY);
+}
+
+// authHeaders sends the session token as a bearer token
+export function authHeaders(): Record<string, string> {
+  const session = getSession();
+  return session ? { Authorization: `Bearer ${session.token}` } : {};
+}
+
+// withToken adds the session token to a WebSocket URL, since browsers can't
+// set headers on the upgrade request
+export function withToken(url: string, token: string): string {
+  const sep = url.includes("?") ? "&" : "?";
+  return `${url}${sep}token=${encodeURIComponent(token)}`;
+}
+
 // Generic fetch wrapper with error handling
 async function fetchApi<T>(
   endpoint: string,
@@ -11,6 +66,7 @@ async function fetchApi<T>(
     ...options,
     headers: {
       "Content-Type": "application/json",
+      ...authHeaders(),
       ...options?.headers,
     },
   });
@@ -23,17 +79,28 @@ async function fetchApi<T>(
   return response.json();
 }
 
+// Auth API
+export const authApi = {
+  // displayName is only used when the server allows nickname-only login
+  // and password is left empty
+  login: (username: string, password: string, displayName?: string) =>
+    fetchApi<Session>("/api/auth/login", {
+      method: "POST",
+      body: JSON.stringify({ username, password, display_name: displayName }),
+    }),
+
+  register: (username: string, displayName: string, password: string) =>
+    fetchApi<Session>("/api/auth/register", {
+      method: "POST",
+      body: JSON.stringify({ username, display_name: displayName, password }),
+    }),
+
+  logout: () =>
+    fetchApi<{ message: string }>("/api/auth/logout", { method: "POST" }),
+};
+
 // User API
 export const userApi = {
-  create: (username: string, displayName: string) =>
-    fetchApi<{ id: string; username: string; display_name: string }>(
-      "/api/users",
-      {
-        method: "POST",
-        body: JSON.stringify({ username, display_name: displayName }),
-      }
-    ),
-
   getById: (id: string) =>
     fetchApi<{ id: string; username: string; display_name: string }>(
       `/api/users/${id}`
@@ -73,10 +140,14 @@ export const roomApi = {
       body: JSON.stringify({ name, description, is_private: isPrivate }),
     }),
 
-  join: (roomId: string, userId: string) =>
+  join: (roomId: string) =>
     fetchApi<{ message: string }>(`/api/rooms/${roomId}/join`, {
       method: "POST",
-      body: JSON.stringify({ user_id: userId }),
+    }),
+
+  markRead: (roomId: string) =>
+    fetchApi<unknown>(`/api/rooms/${roomId}/read`, {
+      method: "POST",
     }),
 
   getMembers: (roomId: string) =>
@@ -105,6 +176,7 @@ export const messageApi = {
 };
 
 export default {
+  auth: authApi,
   user: userApi,
   room: roomApi,
   message: messageApi,